@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spicyneuron/llama-matchmaker/config"
+	"github.com/spicyneuron/llama-matchmaker/logger"
+)
+
+// runCheck loads configs the way the proxy would, without listening, and prints lint warnings
+// It returns the exit code: 1 when a config fails to load (or has warnings with -strict), else 0.
+func runCheck(args []string, stdout, stderr io.Writer) int {
+	var paths configFiles
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Var(&paths, "config", "Path to YAML configuration (can be specified multiple times)")
+	fs.Var(&paths, "c", "Alias for -config")
+	strict := fs.Bool("strict", false, "Exit non-zero when there are lint warnings")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: llama-matchmaker check -config <config.yml> [-config <routes.yml> ...] [-strict]")
+		fmt.Fprintln(stderr)
+		fmt.Fprintln(stderr, "Loads, validates and compiles configs without starting the proxy, then prints lint warnings.")
+		fmt.Fprintln(stderr)
+		fmt.Fprintln(stderr, "Options:")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if len(paths) == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	// Logs would interleave with the report
+	logger.SetOutput(stderr)
+	defer logger.SetOutput(os.Stdout)
+
+	// Load validates and compiles templates, reporting every error at once
+	cfg, _, err := config.Load(paths, config.CliOverrides{})
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	warnings := config.Lint(cfg)
+	for _, w := range warnings {
+		fmt.Fprintf(stdout, "warning: %s\n", w)
+	}
+
+	routes := 0
+	for _, p := range cfg.Proxies {
+		routes += len(p.Routes)
+	}
+	fmt.Fprintf(stdout, "%d proxies, %d routes, %d warnings\n", len(cfg.Proxies), routes, len(warnings))
+
+	if *strict && len(warnings) > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunCheck(t *testing.T) {
+	tmpDir := t.TempDir()
+	validPath := filepath.Join(tmpDir, "valid.yml")
+	if err := writeFile(validPath, `
+proxy:
+  listen: localhost:8081
+  target: http://localhost:8080
+  routes:
+    - methods: POST
+      paths: /chat
+      on_request:
+        - merge: {a: 1}
+          stop: true
+        - merge: {b: 2}
+`); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	invalidPath := filepath.Join(tmpDir, "invalid.yml")
+	if err := writeFile(invalidPath, `
+proxy:
+  listen: localhost:8081
+  target: http://localhost:8080
+  routes:
+    - methods: POST
+      paths: /chat
+      on_requst:
+        - merge: {a: 1}
+`); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		args       []string
+		wantCode   int
+		wantStdout string
+		wantStderr string
+	}{
+		{"warnings", []string{"-config", validPath}, 0, "on_request 1: unreachable", ""},
+		{"strict warnings", []string{"-c", validPath, "-strict"}, 1, "1 proxies, 1 routes, 1 warnings", ""},
+		{"invalid config", []string{"-config", invalidPath}, 1, "", invalidPath + ":8:7: unknown key 'on_requst' (did you mean 'on_request'?)"},
+		{"no config", nil, 2, "", "Usage: llama-matchmaker check"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			if code := runCheck(tt.args, &stdout, &stderr); code != tt.wantCode {
+				t.Errorf("exit code = %d, want %d (stderr: %s)", code, tt.wantCode, stderr.String())
+			}
+			if !strings.Contains(stdout.String(), tt.wantStdout) {
+				t.Errorf("stdout = %q, want it to contain %q", stdout.String(), tt.wantStdout)
+			}
+			if !strings.Contains(stderr.String(), tt.wantStderr) {
+				t.Errorf("stderr = %q, want it to contain %q", stderr.String(), tt.wantStderr)
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// JSON value types accepted by the type condition
+const (
+	TypeString  = "string"
+	TypeNumber  = "number"
+	TypeBoolean = "boolean"
+	TypeArray   = "array"
+	TypeObject  = "object"
+	TypeNull    = "null"
+)
+
+// MatchCondition is the map form of a pattern field, for tests a regex can't express
+// Every key given must hold. eq, type and the comparisons must all hold for the same value;
+// exists, absent and not look at every value found, so they can match missing keys.
+type MatchCondition struct {
+	Not    *PatternField `yaml:"not,omitempty"`
+	Exists *bool         `yaml:"exists,omitempty"`
+	Absent bool          `yaml:"absent,omitempty"`
+	Eq     any           `yaml:"eq,omitempty"`
+	Type   string        `yaml:"type,omitempty"`
+	GT     *float64      `yaml:"gt,omitempty"`
+	GTE    *float64      `yaml:"gte,omitempty"`
+	LT     *float64      `yaml:"lt,omitempty"`
+	LTE    *float64      `yaml:"lte,omitempty"`
+
+	// hasEq tells eq: null apart from no eq at all
+	hasEq bool
+}
+
+var conditionKeys = map[string]bool{
+	"not": true, "exists": true, "absent": true, "eq": true, "type": true,
+	"gt": true, "gte": true, "lt": true, "lte": true,
+}
+
+// unmarshalCondition decodes a condition map, rejecting keys it doesn't know
+func unmarshalCondition(unmarshal func(any) error) (*MatchCondition, error) {
+	var raw map[string]any
+	if err := unmarshal(&raw); err != nil {
+		return nil, err
+	}
+	for _, key := range sortedKeys(raw) {
+		if !conditionKeys[key] {
+			return nil, fmt.Errorf("unknown match condition '%s' (expected not, exists, absent, eq, type, gt, gte, lt or lte)", key)
+		}
+	}
+
+	var cond MatchCondition
+	if err := unmarshal(&cond); err != nil {
+		return nil, err
+	}
+	_, cond.hasEq = raw["eq"]
+	// A bare null in YAML decodes to nothing; type: null means the JSON type
+	if t, ok := raw["type"]; ok && t == nil {
+		cond.Type = TypeNull
+	}
+	return &cond, nil
+}
+
+// validate checks the condition and compiles nested patterns
+// eq values are normalised to their JSON form so 4096 from YAML equals 4096 from a body.
+func (c *MatchCondition) validate() error {
+	if c.Not == nil && c.Exists == nil && !c.Absent && !c.hasEq && c.Type == "" && !c.comparesNumbers() {
+		return fmt.Errorf("match condition is empty")
+	}
+	if c.Absent && c.Exists != nil && *c.Exists {
+		return fmt.Errorf("match condition cannot require both exists: true and absent")
+	}
+	switch c.Type {
+	case "", TypeString, TypeNumber, TypeBoolean, TypeArray, TypeObject, TypeNull:
+	default:
+		return fmt.Errorf("unknown type '%s' (expected %s, %s, %s, %s, %s or %s)", c.Type, TypeString, TypeNumber, TypeBoolean, TypeArray, TypeObject, TypeNull)
+	}
+	if c.Not != nil {
+		if err := c.Not.Validate(); err != nil {
+			return fmt.Errorf("not: %w", err)
+		}
+	}
+	if c.hasEq {
+		encoded, err := json.Marshal(c.Eq)
+		if err != nil {
+			return fmt.Errorf("eq: %w", err)
+		}
+		if err := json.Unmarshal(encoded, &c.Eq); err != nil {
+			return fmt.Errorf("eq: %w", err)
+		}
+	}
+	return nil
+}
+
+func (c *MatchCondition) comparesNumbers() bool {
+	return c.GT != nil || c.GTE != nil || c.LT != nil || c.LTE != nil
+}
+
+// matches reports whether the values found for a key satisfy the condition
+func (c *MatchCondition) matches(values []any) bool {
+	if c.Exists != nil && (len(values) > 0) != *c.Exists {
+		return false
+	}
+	if c.Absent && len(values) > 0 {
+		return false
+	}
+	if c.Not != nil && c.Not.MatchesValues(values) {
+		return false
+	}
+	if !c.hasEq && c.Type == "" && !c.comparesNumbers() {
+		return true
+	}
+	for _, value := range values {
+		if c.matchesValue(value) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *MatchCondition) matchesValue(value any) bool {
+	if c.hasEq && !reflect.DeepEqual(value, c.Eq) {
+		return false
+	}
+	if c.Type != "" && jsonType(value) != c.Type {
+		return false
+	}
+	if !c.comparesNumbers() {
+		return true
+	}
+	n, ok := numericValue(value)
+	if !ok {
+		return false
+	}
+	return (c.GT == nil || n > *c.GT) &&
+		(c.GTE == nil || n >= *c.GTE) &&
+		(c.LT == nil || n < *c.LT) &&
+		(c.LTE == nil || n <= *c.LTE)
+}
+
+// jsonType names the JSON type of a decoded value
+func jsonType(value any) string {
+	switch value.(type) {
+	case nil:
+		return TypeNull
+	case string:
+		return TypeString
+	case bool:
+		return TypeBoolean
+	case float64, float32, int, int64, json.Number:
+		return TypeNumber
+	case []any:
+		return TypeArray
+	case map[string]any:
+		return TypeObject
+	default:
+		return ""
+	}
+}
+
+// numericValue reads numbers and numeric strings, since headers and captures are always strings
+func numericValue(value any) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case json.Number:
+		n, err := v.Float64()
+		return n, err == nil
+	case string:
+		n, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return n, err == nil
+	default:
+		return 0, false
+	}
+}
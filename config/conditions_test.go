@@ -0,0 +1,83 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestMatchConditions(t *testing.T) {
+	tests := []struct {
+		name   string
+		field  string
+		values []any
+		want   bool
+	}{
+		{"not regex", "{not: ^qwen}", []any{"llama3"}, true},
+		{"not regex rejects match", "{not: ^qwen}", []any{"qwen3"}, false},
+		{"not regex on missing key", "{not: ^qwen}", nil, true},
+		{"exists false on missing key", "{exists: false}", nil, true},
+		{"exists false on present key", "{exists: false}", []any{[]any{}}, false},
+		{"exists true on null value", "{exists: true}", []any{nil}, true},
+		{"absent", "{absent: true}", nil, true},
+		{"gt number", "{gt: 8192}", []any{16384.0}, true},
+		{"gt boundary", "{gt: 8192}", []any{8192.0}, false},
+		{"range", "{gte: 1, lte: 2}", []any{1.5}, true},
+		{"range outside", "{gte: 1, lte: 2}", []any{2.5}, false},
+		{"numeric string", "{lt: 10}", []any{"5"}, true},
+		{"comparison on missing key", "{lt: 10}", nil, false},
+		{"comparison on text", "{lt: 10}", []any{"few"}, false},
+		{"type array", "{type: array}", []any{[]any{}}, true},
+		{"type object", "{type: object}", []any{[]any{}}, false},
+		{"type null", "{type: null}", []any{nil}, true},
+		{"eq typed number", "{eq: 4096}", []any{4096.0}, true},
+		{"eq number is not string", "{eq: 4096}", []any{"4096"}, false},
+		{"eq bool", "{eq: true}", []any{true}, true},
+		{"eq null", "{eq: null}", []any{nil}, true},
+		{"eq object", "{eq: {type: json_object}}", []any{map[string]any{"type": "json_object"}}, true},
+		{"any wildcard value", "{eq: tool}", []any{"user", "tool"}, true},
+		{"conditions hold for the same value", "{type: number, gt: 1}", []any{"5", 0.5}, false},
+		{"not a condition", "{not: {exists: true}}", nil, true},
+		{"not a list", "{not: [a, b]}", []any{"b"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var field PatternField
+			if err := yaml.Unmarshal([]byte(tt.field), &field); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+			if err := field.Validate(); err != nil {
+				t.Fatalf("validate: %v", err)
+			}
+			if got := field.MatchesValues(tt.values); got != tt.want {
+				t.Errorf("MatchesValues(%v) = %v, want %v", tt.values, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchConditionErrors(t *testing.T) {
+	tests := []struct {
+		field  string
+		errMsg string
+	}{
+		{"{gt: 1, greater: 2}", "unknown match condition 'greater'"},
+		{"{type: list}", "unknown type 'list'"},
+		{"{exists: true, absent: true}", "cannot require both"},
+		{"{not: '['}", "not: invalid regex pattern"},
+		{"{}", "match condition is empty"},
+	}
+
+	for _, tt := range tests {
+		var field PatternField
+		err := yaml.Unmarshal([]byte(tt.field), &field)
+		if err == nil {
+			err = field.Validate()
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+			t.Errorf("%s: error = %v, want error containing %q", tt.field, err, tt.errMsg)
+		}
+	}
+}
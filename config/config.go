@@ -1,10 +1,13 @@
 package config
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -15,8 +18,12 @@ import (
 // Config represents the full proxy configuration
 type Config struct {
 	Proxies ProxyEntries `yaml:"proxy"`
+
+	// secrets read from ${file:...} references or the environment into addresses, for Redact
+	secrets []string
 }
 
+// watchList tracks the files a config depends on
 type watchList struct {
 	paths []string
 	seen  map[string]struct{}
@@ -41,15 +48,96 @@ func (w *watchList) Paths() []string {
 	return w.paths
 }
 
+// loadState is shared by every file of one Load: the files to watch, the secrets read,
+// the file each YAML node came from, and problems found while decoding (ex: unknown keys)
+type loadState struct {
+	watched *watchList
+	secrets []string
+	sources map[*yaml.Node]string
+	errs    []error
+}
+
+func newLoadState() *loadState {
+	return &loadState{watched: newWatchList(), sources: make(map[*yaml.Node]string)}
+}
+
+func (s *loadState) addSecret(secret string) {
+	if secret == "" {
+		return
+	}
+	s.secrets = append(s.secrets, secret)
+}
+
 // ProxyConfig contains proxy-level settings
 type ProxyConfig struct {
-	Listen  string        `yaml:"listen"`
-	Target  string        `yaml:"target"`
-	Timeout time.Duration `yaml:"timeout"`
-	SSLCert string        `yaml:"ssl_cert"`
-	SSLKey  string        `yaml:"ssl_key"`
-	Debug   bool          `yaml:"debug"`
-	Routes  []Route       `yaml:"routes"`
+	Listen    string         `yaml:"listen"`
+	Target    string         `yaml:"target"`
+	Targets   []TargetConfig `yaml:"targets"`
+	Balance   string         `yaml:"balance"`
+	Health    HealthConfig   `yaml:"health"`
+	Retry     RetryConfig    `yaml:"retry"`
+	Fallbacks []string       `yaml:"fallbacks"`
+	Timeout   time.Duration  `yaml:"timeout"`
+	SSLCert   string         `yaml:"ssl_cert"`
+	SSLKey    string         `yaml:"ssl_key"`
+	Debug     bool           `yaml:"debug"`
+	Routes    []Route        `yaml:"routes"`
+
+	// Where the proxy was defined, for error messages (nil when built in code)
+	Source *Source `yaml:"-"`
+}
+
+// Load balancing strategies for proxies with multiple targets
+const (
+	BalanceRoundRobin    = "round_robin"
+	BalanceLeastRequests = "least_requests"
+	BalanceWeighted      = "weighted"
+)
+
+// TargetConfig is one upstream in a proxy's targets list
+type TargetConfig struct {
+	URL    string `yaml:"url"`
+	Weight int    `yaml:"weight"`
+}
+
+// UnmarshalYAML accepts either a bare URL string or a map with url/weight
+func (t *TargetConfig) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		t.URL = value.Value
+		return nil
+	}
+	type plain TargetConfig
+	return value.Decode((*plain)(t))
+}
+
+// HealthConfig controls passive health checks for multiple targets
+type HealthConfig struct {
+	MaxFailures int           `yaml:"max_failures"`
+	Cooldown    time.Duration `yaml:"cooldown"`
+}
+
+// RetryConfig controls retries of failed upstream attempts
+// Retries only happen before any response bytes reach the client.
+type RetryConfig struct {
+	// Attempts is the number of tries per target (default 1, no retry)
+	Attempts   int           `yaml:"attempts"`
+	Backoff    time.Duration `yaml:"backoff"`
+	MaxBackoff time.Duration `yaml:"max_backoff"`
+	// OnStatus lists retryable upstream status codes (default 502, 503, 504)
+	OnStatus []int `yaml:"on_status"`
+	// OnNetworkError retries connection failures (default true)
+	OnNetworkError *bool `yaml:"on_network_error"`
+}
+
+// TargetList returns every configured upstream, folding the single target into the list
+func (p ProxyConfig) TargetList() []TargetConfig {
+	if len(p.Targets) > 0 {
+		return p.Targets
+	}
+	if p.Target != "" {
+		return []TargetConfig{{URL: p.Target}}
+	}
+	return nil
 }
 
 // ProxyEntries allows proxy to be defined as a single map or a list
@@ -91,15 +179,37 @@ type CliOverrides struct {
 
 // Route defines matching criteria and operations with compiled templates
 type Route struct {
-	Methods    PatternField `yaml:"methods"`
-	Paths      PatternField `yaml:"paths"`
-	TargetPath string       `yaml:"target_path"`
+	Methods PatternField `yaml:"methods"`
+	Paths   PatternField `yaml:"paths"`
+	Hosts   PatternField `yaml:"hosts,omitempty"`
+
+	// Request body and header criteria; the whole route is skipped unless all match
+	MatchBody    map[string]PatternField `yaml:"match_body,omitempty"`
+	MatchHeaders map[string]PatternField `yaml:"match_headers,omitempty"`
+
+	Target       string `yaml:"target"`
+	TargetPath   string `yaml:"target_path"`
+	Translate    string `yaml:"translate"`
+	StreamFormat string `yaml:"stream_format"`
+
+	// Forces stream on or off upstream regardless of what the client asked for
+	UpstreamStream *bool `yaml:"upstream_stream,omitempty"`
+
+	// Query parameters that must be present and match, and edits to the outbound query
+	Query       map[string]PatternField `yaml:"query,omitempty"`
+	TargetQuery QueryEdits              `yaml:"target_query,omitempty"`
 
 	OnRequest  []Action `yaml:"on_request,omitempty"`
 	OnResponse []Action `yaml:"on_response,omitempty"`
 
+	// Actions run once on the message assembled from a streamed response
+	OnStreamEnd []Action `yaml:"on_stream_end,omitempty"`
+
 	// Compiled templates (not serialized)
 	Compiled *CompiledRoute `yaml:"-"`
+
+	// Where the route was defined, for error messages (nil when built in code)
+	Source *Source `yaml:"-"`
 }
 
 // Action defines a transformation to apply
@@ -107,19 +217,81 @@ type Action struct {
 	// Matching criteria
 	MatchBody    map[string]PatternField `yaml:"match_body,omitempty"`
 	MatchHeaders map[string]PatternField `yaml:"match_headers,omitempty"`
+	MatchTarget  PatternField            `yaml:"match_target,omitempty"`
+	MatchStatus  StatusField             `yaml:"match_status,omitempty"`
 
 	// Transformations
-	Template string         `yaml:"template,omitempty"`
-	Merge    map[string]any `yaml:"merge,omitempty"`
-	Default  map[string]any `yaml:"default,omitempty"`
-	Delete   []string       `yaml:"delete,omitempty"`
-	Stop     bool           `yaml:"stop,omitempty"`
+	Template  string         `yaml:"template,omitempty"`
+	Merge     map[string]any `yaml:"merge,omitempty"`
+	MergeDeep map[string]any `yaml:"merge_deep,omitempty"`
+	Default   map[string]any `yaml:"default,omitempty"`
+	Delete    []string       `yaml:"delete,omitempty"`
+	Stop      bool           `yaml:"stop,omitempty"`
+
+	// Header rewrites applied to the outbound request or client response
+	SetHeaders    map[string]string `yaml:"set_headers,omitempty"`
+	AddHeaders    map[string]string `yaml:"add_headers,omitempty"`
+	RemoveHeaders []string          `yaml:"remove_headers,omitempty"`
+
+	// Upstream override for matching requests (on_request only)
+	Target string `yaml:"target,omitempty"`
+
+	// Array handling for merge_deep: replace (default), append, prepend, or union
+	ArrayMerge    string `yaml:"array_merge,omitempty"`
+	ArrayMergeKey string `yaml:"array_merge_key,omitempty"`
+
+	// Request-scoped variables set from body paths, readable later as $vars.<name>
+	Capture map[string]string `yaml:"capture,omitempty"`
+
+	// Client response status and error body rewrites (on_response only)
+	SetStatus   int    `yaml:"set_status,omitempty"`
+	ErrorFormat string `yaml:"error_format,omitempty"`
+
+	// Where the action was defined, for error messages (nil when built in code)
+	Source *Source `yaml:"-"`
 }
 
-// PatternField can be a single pattern or array of patterns
+// QueryEdits adds, replaces or removes outbound query parameters
+// Removals apply first, then set, then add, matching header rewrites.
+type QueryEdits struct {
+	Set    map[string]string `yaml:"set,omitempty"`
+	Add    map[string]string `yaml:"add,omitempty"`
+	Remove []string          `yaml:"remove,omitempty"`
+}
+
+// Len returns the number of edits
+func (q QueryEdits) Len() int {
+	return len(q.Set) + len(q.Add) + len(q.Remove)
+}
+
+// Array merge strategies for merge_deep
+const (
+	ArrayMergeReplace = "replace"
+	ArrayMergeAppend  = "append"
+	ArrayMergePrepend = "prepend"
+	ArrayMergeUnion   = "union"
+)
+
+// Protocol translations for routes, named client-to-upstream
+const (
+	TranslateOllamaToOpenAI = "ollama-to-openai"
+	TranslateOpenAIToOllama = "openai-to-ollama"
+)
+
+// Output framing for streamed responses
+const (
+	StreamFormatSSE         = "sse"
+	StreamFormatNDJSON      = "ndjson"
+	StreamFormatPassthrough = "passthrough"
+)
+
+// PatternField can be a single pattern, array of patterns, or a condition map
 type PatternField struct {
 	Patterns []string
 	Compiled []*regexp.Regexp
+
+	// Condition replaces the patterns when the field is a map (ex: {not: ^qwen}, {gt: 4096})
+	Condition *MatchCondition
 }
 
 // UnmarshalYAML allows both string and []string for pattern fields
@@ -136,7 +308,17 @@ func (p *PatternField) UnmarshalYAML(unmarshal func(any) error) error {
 		return nil
 	}
 
-	return fmt.Errorf("patterns must be string or []string")
+	var raw map[string]any
+	if err := unmarshal(&raw); err == nil {
+		cond, err := unmarshalCondition(unmarshal)
+		if err != nil {
+			return err
+		}
+		p.Condition = cond
+		return nil
+	}
+
+	return fmt.Errorf("patterns must be string, []string or a condition map")
 }
 
 // Validate checks if all patterns are valid regex and compiles them
@@ -151,11 +333,17 @@ func (p *PatternField) Validate() error {
 		}
 		p.Compiled = append(p.Compiled, re)
 	}
+	if p.Condition != nil {
+		return p.Condition.validate()
+	}
 	return nil
 }
 
-// Matches checks if input matches any compiled pattern
+// Matches checks if input matches any compiled pattern or satisfies the condition
 func (p PatternField) Matches(input string) bool {
+	if p.Condition != nil {
+		return p.Condition.matches([]any{input})
+	}
 	for _, re := range p.Compiled {
 		if re.MatchString(input) {
 			return true
@@ -164,8 +352,51 @@ func (p PatternField) Matches(input string) bool {
 	return false
 }
 
-// Len returns the number of patterns
+// Captures returns the groups of the first pattern matching input, or nil
+// Groups are keyed by number ("1", "2", ...) and named groups also by name;
+// optional groups that took no part in the match are left out.
+func (p PatternField) Captures(input string) map[string]string {
+	for _, re := range p.Compiled {
+		loc := re.FindStringSubmatchIndex(input)
+		if loc == nil {
+			continue
+		}
+		names := re.SubexpNames()
+		groups := make(map[string]string, len(names))
+		for i := 1; i < len(names); i++ {
+			if loc[2*i] < 0 {
+				continue
+			}
+			value := input[loc[2*i]:loc[2*i+1]]
+			groups[strconv.Itoa(i)] = value
+			if names[i] != "" {
+				groups[names[i]] = value
+			}
+		}
+		return groups
+	}
+	return nil
+}
+
+// MatchesValues checks the values found for a key, which is empty when the key is missing
+// Patterns match when any value's text matches; missing keys only satisfy conditions.
+func (p PatternField) MatchesValues(values []any) bool {
+	if p.Condition != nil {
+		return p.Condition.matches(values)
+	}
+	for _, value := range values {
+		if p.Matches(fmt.Sprintf("%v", value)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Len returns the number of patterns, counting a condition as one
 func (p PatternField) Len() int {
+	if p.Condition != nil {
+		return len(p.Patterns) + 1
+	}
 	return len(p.Patterns)
 }
 
@@ -181,7 +412,7 @@ func Load(configPaths []string, overrides CliOverrides) (*Config, []string, erro
 		mergedConfig *Config
 		loadFields   []any
 	)
-	watchedFiles := newWatchList()
+	state := newLoadState()
 
 	for i, configPath := range configPaths {
 		// Add main config file to watched files
@@ -189,9 +420,9 @@ func Load(configPaths []string, overrides CliOverrides) (*Config, []string, erro
 		if err != nil {
 			absPath = configPath
 		}
-		watchedFiles.Add(absPath)
+		state.watched.Add(absPath)
 
-		cfg, err := loadConfigFile(configPath, watchedFiles)
+		cfg, err := loadConfigFile(configPath, state)
 		if err != nil {
 			return nil, nil, fmt.Errorf("failed to parse config file %s: %w", configPath, err)
 		}
@@ -206,10 +437,10 @@ func Load(configPaths []string, overrides CliOverrides) (*Config, []string, erro
 
 			// Add SSL cert/key files to watched files
 			if cfg.Proxies[i].SSLCert != "" {
-				watchedFiles.Add(cfg.Proxies[i].SSLCert)
+				state.watched.Add(cfg.Proxies[i].SSLCert)
 			}
 			if cfg.Proxies[i].SSLKey != "" {
-				watchedFiles.Add(cfg.Proxies[i].SSLKey)
+				state.watched.Add(cfg.Proxies[i].SSLKey)
 			}
 		}
 
@@ -264,6 +495,7 @@ func Load(configPaths []string, overrides CliOverrides) (*Config, []string, erro
 	}
 
 	mergedConfig.Proxies = proxies
+	mergedConfig.secrets = state.secrets
 
 	var overrideFields []any
 	if overrides.Listen != "" {
@@ -288,18 +520,22 @@ func Load(configPaths []string, overrides CliOverrides) (*Config, []string, erro
 		logger.Debug("Applied CLI overrides", overrideFields...)
 	}
 
-	if err := Validate(mergedConfig); err != nil {
-		return nil, nil, fmt.Errorf("config validation failed: %w", err)
+	// Report unknown keys, validation errors and template errors all at once
+	var errs []error
+	if err := errors.Join(append(state.errs, Validate(mergedConfig))...); err != nil {
+		errs = append(errs, fmt.Errorf("config validation failed: %w", err))
 	}
-
 	if err := CompileTemplates(mergedConfig); err != nil {
-		return nil, nil, fmt.Errorf("template compilation failed: %w", err)
+		errs = append(errs, fmt.Errorf("template compilation failed: %w", err))
+	}
+	if len(errs) > 0 {
+		return nil, nil, errors.Join(errs...)
 	}
 
-	return mergedConfig, watchedFiles.Paths(), nil
+	return mergedConfig, state.watched.Paths(), nil
 }
 
-func loadConfigFile(configPath string, watchedFiles *watchList) (Config, error) {
+func loadConfigFile(configPath string, state *loadState) (Config, error) {
 	data, err := os.ReadFile(configPath)
 	if err != nil {
 		return Config{}, fmt.Errorf("failed to read config file %s: %w", configPath, err)
@@ -309,8 +545,13 @@ func loadConfigFile(configPath string, watchedFiles *watchList) (Config, error)
 	if err := yaml.Unmarshal(data, &root); err != nil {
 		return Config{}, fmt.Errorf("failed to parse config file %s: %w", configPath, err)
 	}
+	state.markSource(&root, configPath)
 
-	if err := expandIncludes(&root, filepath.Dir(configPath), watchedFiles); err != nil {
+	if err := interpolateNode(&root, filepath.Dir(configPath), state); err != nil {
+		return Config{}, fmt.Errorf("failed to interpolate config file %s: %w", configPath, err)
+	}
+
+	if err := expandIncludes(&root, filepath.Dir(configPath), state); err != nil {
 		return Config{}, err
 	}
 
@@ -318,16 +559,17 @@ func loadConfigFile(configPath string, watchedFiles *watchList) (Config, error)
 	if err := root.Decode(&cfg); err != nil {
 		return Config{}, fmt.Errorf("failed to decode config %s: %w", configPath, err)
 	}
+	state.errs = append(state.errs, state.annotate(&root, reflect.ValueOf(&cfg))...)
 
 	return cfg, nil
 }
 
 // expandIncludes recursively inlines include nodes and tracks every referenced file for watching.
-func expandIncludes(node *yaml.Node, baseDir string, watchedFiles *watchList) error {
+func expandIncludes(node *yaml.Node, baseDir string, state *loadState) error {
 	switch node.Kind {
 	case yaml.DocumentNode:
 		for _, child := range node.Content {
-			if err := expandIncludes(child, baseDir, watchedFiles); err != nil {
+			if err := expandIncludes(child, baseDir, state); err != nil {
 				return err
 			}
 		}
@@ -337,28 +579,29 @@ func expandIncludes(node *yaml.Node, baseDir string, watchedFiles *watchList) er
 			val := node.Content[i+1]
 
 			if key.Value == "include" && len(node.Content) == 2 {
-				included, err := loadIncludeNode(val, baseDir, watchedFiles)
+				included, err := loadIncludeNode(val, baseDir, state)
 				if err != nil {
 					return err
 				}
 				*node = *included
-				return expandIncludes(node, baseDir, watchedFiles)
+				state.sources[node] = state.sources[included]
+				return expandIncludes(node, baseDir, state)
 			}
 
 			// Allow include as the value of a mapping (e.g., on_request: { include: file.yml })
 			if val.Kind == yaml.MappingNode && isIncludeNode(val) {
-				included, err := loadIncludeNode(val.Content[1], baseDir, watchedFiles)
+				included, err := loadIncludeNode(val.Content[1], baseDir, state)
 				if err != nil {
 					return err
 				}
 				node.Content[i+1] = included
-				if err := expandIncludes(included, baseDir, watchedFiles); err != nil {
+				if err := expandIncludes(included, baseDir, state); err != nil {
 					return err
 				}
 				continue
 			}
 
-			if err := expandIncludes(val, baseDir, watchedFiles); err != nil {
+			if err := expandIncludes(val, baseDir, state); err != nil {
 				return err
 			}
 		}
@@ -366,20 +609,20 @@ func expandIncludes(node *yaml.Node, baseDir string, watchedFiles *watchList) er
 		var newContent []*yaml.Node
 		for _, item := range node.Content {
 			if isIncludeNode(item) {
-				included, err := loadIncludeNode(item.Content[1], baseDir, watchedFiles)
+				included, err := loadIncludeNode(item.Content[1], baseDir, state)
 				if err != nil {
 					return err
 				}
 
 				if included.Kind == yaml.SequenceNode {
 					for _, child := range included.Content {
-						if err := expandIncludes(child, baseDir, watchedFiles); err != nil {
+						if err := expandIncludes(child, baseDir, state); err != nil {
 							return err
 						}
 						newContent = append(newContent, child)
 					}
 				} else {
-					if err := expandIncludes(included, baseDir, watchedFiles); err != nil {
+					if err := expandIncludes(included, baseDir, state); err != nil {
 						return err
 					}
 					newContent = append(newContent, included)
@@ -387,7 +630,7 @@ func expandIncludes(node *yaml.Node, baseDir string, watchedFiles *watchList) er
 				continue
 			}
 
-			if err := expandIncludes(item, baseDir, watchedFiles); err != nil {
+			if err := expandIncludes(item, baseDir, state); err != nil {
 				return err
 			}
 			newContent = append(newContent, item)
@@ -403,7 +646,7 @@ func isIncludeNode(node *yaml.Node) bool {
 		node.Content[0].Value == "include"
 }
 
-func loadIncludeNode(pathNode *yaml.Node, baseDir string, watchedFiles *watchList) (*yaml.Node, error) {
+func loadIncludeNode(pathNode *yaml.Node, baseDir string, state *loadState) (*yaml.Node, error) {
 	if pathNode.Kind != yaml.ScalarNode {
 		return nil, fmt.Errorf("include path must be a string")
 	}
@@ -415,7 +658,7 @@ func loadIncludeNode(pathNode *yaml.Node, baseDir string, watchedFiles *watchLis
 	if err != nil {
 		absPath = includePath
 	}
-	watchedFiles.Add(absPath)
+	state.watched.Add(absPath)
 
 	data, err := os.ReadFile(includePath)
 	if err != nil {
@@ -426,8 +669,13 @@ func loadIncludeNode(pathNode *yaml.Node, baseDir string, watchedFiles *watchLis
 	if err := yaml.Unmarshal(data, &root); err != nil {
 		return nil, fmt.Errorf("failed to parse include file %s: %w", includePath, err)
 	}
+	state.markSource(&root, includePath)
+
+	if err := interpolateNode(&root, filepath.Dir(includePath), state); err != nil {
+		return nil, fmt.Errorf("failed to interpolate include file %s: %w", includePath, err)
+	}
 
-	if err := expandIncludes(&root, filepath.Dir(includePath), watchedFiles); err != nil {
+	if err := expandIncludes(&root, filepath.Dir(includePath), state); err != nil {
 		return nil, err
 	}
 
@@ -443,7 +691,9 @@ func applyOverrides(proxy *ProxyConfig, overrides CliOverrides, pwd string) {
 		proxy.Listen = overrides.Listen
 	}
 	if overrides.Target != "" {
+		// A CLI target replaces any configured target list
 		proxy.Target = overrides.Target
+		proxy.Targets = nil
 	}
 	if overrides.Timeout > 0 {
 		proxy.Timeout = overrides.Timeout
@@ -0,0 +1,157 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/textproto"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/spicyneuron/llama-matchmaker/logger"
+)
+
+// Header edit operations
+const (
+	HeaderSet    = "set"
+	HeaderAdd    = "add"
+	HeaderRemove = "remove"
+)
+
+// HeaderEdit is a single header rewrite produced by a matched action
+type HeaderEdit struct {
+	Op    string
+	Name  string
+	Value string
+}
+
+// headerEdits renders an action's header rewrites in apply order: remove, set, add
+// Values containing template actions are executed against the current body.
+func headerEdits(op ActionExec, data map[string]any, templates map[string]*template.Template, ex *Exchange, phase string, ruleIndex, opIndex int) []HeaderEdit {
+	var edits []HeaderEdit
+	for _, name := range op.RemoveHeaders {
+		edits = append(edits, HeaderEdit{Op: HeaderRemove, Name: textproto.CanonicalMIMEHeaderKey(name)})
+	}
+	for _, name := range sortedStringKeys(op.SetHeaders) {
+		value, ok := renderHeaderValue(op.SetHeaders[name], data, templates, ex, phase, ruleIndex, opIndex)
+		if ok {
+			edits = append(edits, HeaderEdit{Op: HeaderSet, Name: textproto.CanonicalMIMEHeaderKey(name), Value: value})
+		}
+	}
+	for _, name := range sortedStringKeys(op.AddHeaders) {
+		value, ok := renderHeaderValue(op.AddHeaders[name], data, templates, ex, phase, ruleIndex, opIndex)
+		if ok {
+			edits = append(edits, HeaderEdit{Op: HeaderAdd, Name: textproto.CanonicalMIMEHeaderKey(name), Value: value})
+		}
+	}
+	return edits
+}
+
+func renderHeaderValue(raw string, data map[string]any, templates map[string]*template.Template, ex *Exchange, phase string, ruleIndex, opIndex int) (string, bool) {
+	tmpl, ok := templates[raw]
+	if !ok {
+		// Captures come from the client's path and body, so they may hold line breaks too
+		return singleLineHeaderValue(ExpandVars(raw, exchangeVars(ex))), true
+	}
+	var buf bytes.Buffer
+	if err := exchangeTemplate(tmpl, ex).Execute(&buf, templateContext(data, ex)); err != nil {
+		logger.Error("Header template execution error", "phase", phase, "rule_index", ruleIndex, "op_index", opIndex, "err", templateError(err))
+		return "", false
+	}
+	return singleLineHeaderValue(buf.String()), true
+}
+
+// singleLineHeaderValue drops carriage returns and folds newlines, since header values cannot span lines
+func singleLineHeaderValue(value string) string {
+	return strings.TrimSpace(strings.NewReplacer("\r", "", "\n", " ").Replace(value))
+}
+
+// applyHeaderEdits mirrors edits into the first-value header map used for match_headers
+func applyHeaderEdits(headers map[string]string, edits []HeaderEdit) {
+	if headers == nil {
+		return
+	}
+	for _, e := range edits {
+		switch e.Op {
+		case HeaderRemove:
+			delete(headers, e.Name)
+		case HeaderSet:
+			headers[e.Name] = e.Value
+		case HeaderAdd:
+			if _, exists := headers[e.Name]; !exists {
+				headers[e.Name] = e.Value
+			}
+		}
+	}
+}
+
+// hasHeaderActions reports whether an action rewrites headers
+func (op Action) hasHeaderActions() bool {
+	return len(op.SetHeaders) > 0 || len(op.AddHeaders) > 0 || len(op.RemoveHeaders) > 0
+}
+
+// HasHeaderActions reports whether any of the actions rewrite headers
+func HasHeaderActions(actions []Action) bool {
+	for _, op := range actions {
+		if op.hasHeaderActions() {
+			return true
+		}
+	}
+	return false
+}
+
+// BodyGatedHeaderActions lists the actions whose header or status rewrites are gated on match_body
+func BodyGatedHeaderActions(actions []Action) []int {
+	var gated []int
+	for i, op := range actions {
+		if len(op.MatchBody) > 0 && (op.hasHeaderActions() || op.SetStatus != 0) {
+			gated = append(gated, i)
+		}
+	}
+	return gated
+}
+
+// compileHeaderTemplates parses header values that contain template actions
+func compileHeaderTemplates(actions []Action, templates map[string]*template.Template, name, label string) error {
+	var errs []error
+	for j, op := range actions {
+		for key, values := range map[string]map[string]string{"set_headers": op.SetHeaders, "add_headers": op.AddHeaders} {
+			for header, raw := range values {
+				if !strings.Contains(raw, "{{") {
+					continue
+				}
+				if _, done := templates[raw]; done {
+					continue
+				}
+				tmpl, err := parseTemplate(fmt.Sprintf("%s_%d_header", name, j), raw)
+				if err != nil {
+					errs = append(errs, op.Source.errorf(key+"."+header, "%s operation %d header '%s': %w", label, j, header, err))
+					continue
+				}
+				templates[raw] = tmpl
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// validateHeaderName rejects names that cannot be sent on the wire
+func validateHeaderName(name string) error {
+	if name == "" {
+		return fmt.Errorf("header name is empty")
+	}
+	if strings.ContainsAny(name, " \t\r\n:") {
+		return fmt.Errorf("invalid header name '%s'", name)
+	}
+	return nil
+}
+
+func sortedStringKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,170 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// interpolationPattern finds ${...} references along with their $${...} escapes
+var interpolationPattern = regexp.MustCompile(`\$?\$\{([^{}]*)\}`)
+
+// envRefPattern splits an environment reference into its name and optional :-default
+var envRefPattern = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*)(:-(.*))?$`)
+
+// envPrefix marks a reference to an environment variable, ex: ${env:OPENAI_KEY}
+// Bare ${name} references are left for request time, so the environment can't shadow a capture.
+const envPrefix = "env:"
+
+// secretFilePrefix marks a reference to a file holding a secret, ex: ${file:secrets/openai_key}
+const secretFilePrefix = "file:"
+
+// addressKeys hold upstream and listen addresses, which often carry credentials (ex: ?key=${env:API_KEY})
+var addressKeys = map[string]bool{"listen": true, "target": true, "targets": true, "url": true, "fallbacks": true}
+
+// interpolateNode expands environment variables and file secrets in every scalar value of a file
+// Relative secret paths resolve against baseDir. Secret files are watched for rotation and
+// their contents recorded so logs can redact them, as are environment values used in addresses.
+func interpolateNode(node *yaml.Node, baseDir string, state *loadState) error {
+	return interpolateValue(node, "", baseDir, state)
+}
+
+// interpolateValue interpolates a node found under key (sequence items share their parent's key)
+func interpolateValue(node *yaml.Node, key, baseDir string, state *loadState) error {
+	switch node.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, child := range node.Content {
+			if err := interpolateValue(child, key, baseDir, state); err != nil {
+				return err
+			}
+		}
+	case yaml.MappingNode:
+		// Keys are left alone; only values are interpolated
+		for i := 1; i < len(node.Content); i += 2 {
+			if err := interpolateValue(node.Content[i], node.Content[i-1].Value, baseDir, state); err != nil {
+				return err
+			}
+		}
+	case yaml.ScalarNode:
+		if !strings.Contains(node.Value, "${") {
+			return nil
+		}
+		value, err := interpolate(node.Value, addressKeys[key], baseDir, state)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", node.Line, err)
+		}
+		if value != node.Value {
+			node.Value = value
+			// Plain scalars resolve their type again, so ports, durations and booleans decode
+			if node.Style == 0 {
+				node.Tag = ""
+			}
+		}
+	}
+	return nil
+}
+
+// interpolate expands ${env:VAR}, ${env:VAR:-default} and ${file:path} references in s
+// $${...} is written out as ${...}, and other references such as ${model} are left for request
+// time. Unset variables without a default are an error. Environment values are recorded as
+// secrets when secret is set.
+func interpolate(s string, secret bool, baseDir string, state *loadState) (string, error) {
+	var firstErr error
+	out := interpolationPattern.ReplaceAllStringFunc(s, func(ref string) string {
+		if strings.HasPrefix(ref, "$$") {
+			return ref[1:]
+		}
+		inner := ref[2 : len(ref)-1]
+
+		if path, ok := strings.CutPrefix(inner, secretFilePrefix); ok {
+			secret, err := readSecret(path, baseDir, state)
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+			return secret
+		}
+
+		name, ok := strings.CutPrefix(inner, envPrefix)
+		if !ok {
+			return ref
+		}
+		m := envRefPattern.FindStringSubmatch(name)
+		if m == nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("invalid environment reference %s, ex: ${env:API_KEY} or ${env:PORT:-8080}", ref)
+			}
+			return ref
+		}
+		hasDefault := m[2] != ""
+		if value, ok := os.LookupEnv(m[1]); ok && (value != "" || !hasDefault) {
+			if secret {
+				state.addSecret(value)
+			}
+			return value
+		}
+		if hasDefault {
+			return m[3]
+		}
+		if firstErr == nil {
+			firstErr = fmt.Errorf("environment variable %s is not set (use ${env:%s:-default} for a fallback)", m[1], m[1])
+		}
+		return ref
+	})
+	return out, firstErr
+}
+
+// readSecret reads a secret file, trimming the surrounding whitespace editors and tools add
+func readSecret(path, baseDir string, state *loadState) (string, error) {
+	if path == "" {
+		return "", fmt.Errorf("secret reference needs a path, ex: ${file:secrets/api_key}")
+	}
+	secretPath := ResolvePath(path, baseDir)
+
+	absPath, err := filepath.Abs(secretPath)
+	if err != nil {
+		absPath = secretPath
+	}
+	state.watched.Add(absPath)
+
+	data, err := os.ReadFile(secretPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret file %s: %w", secretPath, err)
+	}
+	secret := strings.TrimSpace(string(data))
+	state.addSecret(secret)
+	return secret, nil
+}
+
+// Redact replaces secrets read from files, and environment values used in addresses,
+// with [REDACTED], for logging config values
+func (c *Config) Redact(s string) string {
+	for _, secret := range c.secrets {
+		s = strings.ReplaceAll(s, secret, "[REDACTED]")
+	}
+	return s
+}
+
+// RedactURL masks the password and query values of an upstream URL, for logging
+// API keys are often passed as query parameters, so every value is masked. Strings
+// that don't parse as URLs are returned as written.
+func RedactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	if u.RawQuery != "" {
+		params := strings.Split(u.RawQuery, "&")
+		for i, param := range params {
+			if name, _, ok := strings.Cut(param, "="); ok {
+				params[i] = name + "=[REDACTED]"
+			}
+		}
+		u.RawQuery = strings.Join(params, "&")
+	}
+	return u.Redacted()
+}
@@ -0,0 +1,347 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/template/parse"
+)
+
+// Warning is a lint finding: the config loads, but part of it can never take effect
+type Warning struct {
+	Position Position
+	Message  string
+}
+
+func (w Warning) String() string {
+	if w.Position.Line > 0 {
+		return fmt.Sprintf("%s: %s", w.Position, w.Message)
+	}
+	return w.Message
+}
+
+// catchAllPatterns are patterns that match every method or path
+var catchAllPatterns = map[string]bool{
+	".*": true, "^.*": true, "^.*$": true, ".+": true, "^.+$": true,
+	"/": true, "^/": true, "/.*": true, "^/.*": true, "^/.*$": true,
+}
+
+// requestInfoFields are the keys of .request in response templates
+var requestInfoFields = map[string]bool{"body": true, "headers": true, "method": true, "path": true}
+
+// Lint reports parts of a validated config that can never take effect
+// Warnings are ordered by proxy, route and action.
+func Lint(cfg *Config) []Warning {
+	var warnings []Warning
+	for i := range cfg.Proxies {
+		warnings = append(warnings, lintProxy(&cfg.Proxies[i])...)
+	}
+	return warnings
+}
+
+func lintProxy(p *ProxyConfig) []Warning {
+	var warnings []Warning
+	warn := func(source *Source, key, format string, args ...any) {
+		warnings = append(warnings, Warning{Position: source.At(key), Message: fmt.Sprintf(format, args...)})
+	}
+
+	defined := definedVars(p.Routes)
+
+	for i := range p.Routes {
+		route := &p.Routes[i]
+
+		for j := range i {
+			if shadows(&p.Routes[j], route) {
+				warn(route.Source, "", "route %d never takes effect: route %d matches every request it does and already sets its %s", i, j, strings.Join(routeSettings(route), ", "))
+				break
+			}
+		}
+
+		for _, key := range []string{"target_path", "target"} {
+			value := route.TargetPath
+			if key == "target" {
+				value = route.Target
+			}
+			for _, name := range undefinedRefs(value, defined) {
+				warn(route.Source, key, "route %d: %s uses ${%s}, but no paths or match_body group or capture defines it", i, key, name)
+			}
+		}
+
+		for _, phase := range []struct {
+			name    string
+			actions []Action
+		}{
+			{"on_request", route.OnRequest},
+			{"on_response", route.OnResponse},
+			{"on_stream_end", route.OnStreamEnd},
+		} {
+			for j, op := range phase.actions {
+				if op.Stop && !op.hasMatchCriteria() && j+1 < len(phase.actions) {
+					next := phase.actions[j+1]
+					warn(next.Source, "", "route %d %s %d: unreachable, %s %d always stops (%d actions skipped)", i, phase.name, j+1, phase.name, j, len(phase.actions)-j-1)
+				}
+
+				if op.Template != "" {
+					for _, msg := range lintTemplate(op.Template, phase.name, defined) {
+						warn(op.Source, "template", "route %d %s %d template: %s", i, phase.name, j, msg)
+					}
+				}
+				for _, key := range []string{"set_headers", "add_headers"} {
+					headers := op.SetHeaders
+					if key == "add_headers" {
+						headers = op.AddHeaders
+					}
+					for _, name := range sortedStringKeys(headers) {
+						if !strings.Contains(headers[name], "{{") {
+							continue
+						}
+						for _, msg := range lintTemplate(headers[name], phase.name, defined) {
+							warn(op.Source, key+"."+name, "route %d %s %d header '%s': %s", i, phase.name, j, name, msg)
+						}
+					}
+				}
+			}
+		}
+	}
+	return warnings
+}
+
+// hasMatchCriteria reports whether the action only runs for some requests or responses
+func (a *Action) hasMatchCriteria() bool {
+	return len(a.MatchBody) > 0 || len(a.MatchHeaders) > 0 || a.MatchTarget.Len() > 0 || a.MatchStatus.Len() > 0
+}
+
+// routeSettings lists what a route does, by YAML key
+func routeSettings(r *Route) []string {
+	var settings []string
+	if r.Translate != "" {
+		settings = append(settings, "translate")
+	}
+	if r.StreamFormat != "" {
+		settings = append(settings, "stream_format")
+	}
+	if r.UpstreamStream != nil {
+		settings = append(settings, "upstream_stream")
+	}
+	return settings
+}
+
+// shadows reports whether later can never take effect because of earlier
+// Every matching route runs, so only first-wins settings (translate, stream_format and
+// upstream_stream) can be shadowed, and only when later does nothing else.
+func shadows(earlier, later *Route) bool {
+	if len(later.OnRequest) > 0 || len(later.OnResponse) > 0 || len(later.OnStreamEnd) > 0 ||
+		later.Target != "" || later.TargetPath != "" || later.TargetQuery.Len() > 0 {
+		return false
+	}
+	if len(routeSettings(later)) == 0 {
+		return false
+	}
+	if (later.Translate != "" && earlier.Translate == "") ||
+		(later.StreamFormat != "" && earlier.StreamFormat == "") ||
+		(later.UpstreamStream != nil && earlier.UpstreamStream == nil) {
+		return false
+	}
+
+	return coversPatterns(earlier.Methods, later.Methods, false) &&
+		coversPatterns(earlier.Paths, later.Paths, false) &&
+		coversPatterns(earlier.Hosts, later.Hosts, true) &&
+		coversCriteria(earlier.Query, later.Query) &&
+		coversCriteria(earlier.MatchBody, later.MatchBody) &&
+		coversCriteria(earlier.MatchHeaders, later.MatchHeaders)
+}
+
+// coversPatterns reports whether a matches every input b matches
+// This is a conservative check: a catch-all pattern, or every pattern of b also in a.
+func coversPatterns(a, b PatternField, emptyMatchesAll bool) bool {
+	if a.Len() == 0 {
+		return emptyMatchesAll
+	}
+	if a.Condition != nil || b.Condition != nil {
+		return reflect.DeepEqual(a.Condition, b.Condition) && subsetPatterns(b.Patterns, a.Patterns)
+	}
+	for _, pattern := range a.Patterns {
+		if catchAllPatterns[pattern] {
+			return true
+		}
+	}
+	return b.Len() > 0 && subsetPatterns(b.Patterns, a.Patterns)
+}
+
+// subsetPatterns reports whether every pattern in a is also in b, ignoring case like matching does
+func subsetPatterns(a, b []string) bool {
+	have := make(map[string]bool, len(b))
+	for _, pattern := range b {
+		have[strings.ToLower(pattern)] = true
+	}
+	for _, pattern := range a {
+		if !have[strings.ToLower(pattern)] {
+			return false
+		}
+	}
+	return true
+}
+
+// coversCriteria reports whether every criterion in a is also required by b
+func coversCriteria(a, b map[string]PatternField) bool {
+	for key, patterns := range a {
+		other, ok := b[key]
+		if !ok || !coversPatterns(patterns, other, false) {
+			return false
+		}
+	}
+	return true
+}
+
+// definedVars collects every variable a proxy's requests can have: regex groups from
+// paths and match_body, and capture names
+func definedVars(routes []Route) map[string]bool {
+	defined := make(map[string]bool)
+	addGroups := func(p PatternField) {
+		for _, re := range p.Compiled {
+			for i, name := range re.SubexpNames() {
+				if i == 0 {
+					continue
+				}
+				defined[strconv.Itoa(i)] = true
+				if name != "" {
+					defined[name] = true
+				}
+			}
+		}
+	}
+
+	for i := range routes {
+		route := &routes[i]
+		addGroups(route.Paths)
+		for _, p := range route.MatchBody {
+			addGroups(p)
+		}
+		for _, actions := range [][]Action{route.OnRequest, route.OnResponse, route.OnStreamEnd} {
+			for _, op := range actions {
+				for _, p := range op.MatchBody {
+					addGroups(p)
+				}
+				for name := range op.Capture {
+					defined[name] = true
+				}
+			}
+		}
+	}
+	return defined
+}
+
+// undefinedRefs lists ${name} references in s that no variable defines
+func undefinedRefs(s string, defined map[string]bool) []string {
+	var names []string
+	for _, m := range varRefPattern.FindAllStringSubmatch(s, -1) {
+		if !defined[m[1]] {
+			names = append(names, m[1])
+		}
+	}
+	return names
+}
+
+// lintTemplate reports references in a template that are never set in its phase
+// Only references that can be known without the body are checked: $vars and .request.
+func lintTemplate(text, phase string, defined map[string]bool) []string {
+	tmpl, err := parseTemplate("lint", text)
+	if err != nil {
+		// Compile errors are reported by Load
+		return nil
+	}
+
+	refs := &templateRefs{}
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil {
+			refs.walk(t.Tree.Root, true)
+		}
+	}
+
+	var msgs []string
+	seen := make(map[string]bool)
+	add := func(msg string) {
+		if !seen[msg] {
+			seen[msg] = true
+			msgs = append(msgs, msg)
+		}
+	}
+	for _, name := range refs.vars {
+		if !defined[name] {
+			add(fmt.Sprintf("$vars.%s is never set; no paths or match_body group or capture defines it", name))
+		}
+	}
+	for _, field := range refs.fields {
+		if len(field) < 2 || field[0] != "request" {
+			continue
+		}
+		ref := "." + strings.Join(field[:2], ".")
+		switch {
+		case phase == "on_request":
+			add(fmt.Sprintf("%s is only set in response templates", ref))
+		case !requestInfoFields[field[1]]:
+			add(fmt.Sprintf("%s is never set (expected .request.body, .request.headers, .request.method or .request.path)", ref))
+		}
+	}
+	return msgs
+}
+
+// templateRefs collects the $vars names and top-level fields a template reads
+type templateRefs struct {
+	vars   []string
+	fields [][]string
+}
+
+// walk visits a parse tree; atRoot is false inside range and with, where dot is rebound
+func (r *templateRefs) walk(node parse.Node, atRoot bool) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			r.walk(child, atRoot)
+		}
+	case *parse.ActionNode:
+		r.walk(n.Pipe, atRoot)
+	case *parse.TemplateNode:
+		r.walk(n.Pipe, atRoot)
+	case *parse.IfNode:
+		r.walk(n.Pipe, atRoot)
+		r.walk(n.List, atRoot)
+		r.walk(n.ElseList, atRoot)
+	case *parse.RangeNode:
+		r.walk(n.Pipe, atRoot)
+		r.walk(n.List, false)
+		r.walk(n.ElseList, atRoot)
+	case *parse.WithNode:
+		r.walk(n.Pipe, atRoot)
+		r.walk(n.List, false)
+		r.walk(n.ElseList, atRoot)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			r.walk(cmd, atRoot)
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			r.walk(arg, atRoot)
+		}
+	case *parse.ChainNode:
+		r.walk(n.Node, atRoot)
+	case *parse.FieldNode:
+		if atRoot {
+			r.fields = append(r.fields, n.Ident)
+		}
+	case *parse.VariableNode:
+		switch {
+		case len(n.Ident) > 1 && n.Ident[0] == "$vars":
+			r.vars = append(r.vars, n.Ident[1])
+		case len(n.Ident) > 1 && n.Ident[0] == "$":
+			r.fields = append(r.fields, n.Ident[1:])
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package config
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	tests := []struct {
+		name   string
+		routes string
+		want   []string
+	}{
+		{
+			name: "clean config",
+			routes: `
+    - methods: POST
+      paths: ^/models/(?P<model>[^/]+)/chat$
+      target_path: /v1/chat/completions
+      on_request:
+        - capture: {original: model}
+        - match_body: {model: ^fast$}
+          merge: {model: "${model}"}
+          stop: true
+        - merge: {later: true}
+      on_response:
+        - template: '{"model": "{{ $vars.original }}", "path": "{{ .request.path }}"}'
+    - methods: POST
+      paths: ^/api/chat$
+      translate: ollama-to-openai
+    - methods: POST
+      paths: ^/api/chat$
+      stream_format: sse
+`,
+		},
+		{
+			name: "action after unconditional stop",
+			routes: `
+    - methods: POST
+      paths: /chat
+      on_request:
+        - merge: {a: 1}
+          stop: true
+        - merge: {b: 2}
+        - merge: {c: 3}
+`,
+			want: []string{"route 0 on_request 1: unreachable, on_request 0 always stops (2 actions skipped)"},
+		},
+		{
+			name: "route shadowed by catch-all",
+			routes: `
+    - methods: [GET, POST]
+      paths: /.*
+      translate: ollama-to-openai
+    - methods: POST
+      paths: ^/api/chat$
+      translate: openai-to-ollama
+`,
+			want: []string{"route 1 never takes effect: route 0 matches every request it does and already sets its translate"},
+		},
+		{
+			name: "narrower earlier route does not shadow",
+			routes: `
+    - methods: POST
+      paths: ^/api/chat$
+      match_body: {model: ^qwen}
+      translate: ollama-to-openai
+    - methods: POST
+      paths: ^/api/chat$
+      translate: openai-to-ollama
+`,
+		},
+		{
+			name: "target_path without a matching group",
+			routes: `
+    - methods: POST
+      paths: ^/models/[^/]+/chat$
+      target_path: /v1/${model}/chat
+      on_request:
+        - merge: {a: 1}
+`,
+			want: []string{"route 0: target_path uses ${model}, but no paths or match_body group or capture defines it"},
+		},
+		{
+			name: "templates reading fields never set",
+			routes: `
+    - methods: POST
+      paths: /chat
+      on_request:
+        - template: '{"a": "{{ $vars.missing }}", "b": "{{ .request.path }}"}'
+        - set_headers:
+            X-Model: "{{ $vars.nope }}"
+      on_response:
+        - template: '{"q": "{{ .request.query }}", "m": "{{ .request.method }}"{{ range .choices }}, "x": "{{ .request.other }}"{{ end }}}'
+`,
+			want: []string{
+				"route 0 on_request 0 template: $vars.missing is never set",
+				"route 0 on_request 0 template: .request.path is only set in response templates",
+				"route 0 on_request 1 header 'X-Model': $vars.nope is never set",
+				"route 0 on_response 0 template: .request.query is never set",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := mustParseConfig(t, `
+proxy:
+  listen: localhost:8081
+  target: http://localhost:8080
+  routes:`+tt.routes)
+
+			warnings := Lint(cfg)
+			var got []string
+			for _, w := range warnings {
+				got = append(got, w.String())
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d warnings, want %d:\n%s", len(got), len(tt.want), strings.Join(got, "\n"))
+			}
+			for i, want := range tt.want {
+				if !strings.Contains(got[i], want) {
+					t.Errorf("warning %d = %q, want it to contain %q", i, got[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestLintPositions(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := writeTempConfig(t, tmpDir, "main.yml", `
+proxy:
+  listen: localhost:8081
+  target: http://localhost:8080
+  routes:
+    - methods: POST
+      paths: /chat
+      on_request:
+        - merge: {a: 1}
+          stop: true
+        - merge: {b: 2}
+`)
+
+	cfg, _, err := Load([]string{configPath}, CliOverrides{})
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+
+	warnings := Lint(cfg)
+	if len(warnings) != 1 {
+		t.Fatalf("expected one warning, got %v", warnings)
+	}
+	want := filepath.Join(tmpDir, "main.yml") + ":11:11: route 0 on_request 1: unreachable"
+	if !strings.HasPrefix(warnings[0].String(), want) {
+		t.Errorf("warning = %q, want prefix %q", warnings[0], want)
+	}
+}
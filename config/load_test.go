@@ -1,6 +1,7 @@
 package config
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -8,6 +9,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/spicyneuron/llama-matchmaker/logger"
 	"gopkg.in/yaml.v3"
 )
 
@@ -170,6 +172,40 @@ proxy:
 	}
 }
 
+func TestLoadWithTargets(t *testing.T) {
+	configContent := `
+proxy:
+  listen: "localhost:8081"
+  targets:
+    - http://localhost:8080
+    - url: http://localhost:8082
+      weight: 3
+  balance: weighted
+  health:
+    max_failures: 5
+    cooldown: 10s
+  routes:
+    - methods: POST
+      paths: /v1/chat
+      on_request:
+        - merge:
+            temperature: 0.7
+`
+	cfg := mustParseConfig(t, configContent)
+	p := cfg.Proxies[0]
+
+	want := []TargetConfig{{URL: "http://localhost:8080"}, {URL: "http://localhost:8082", Weight: 3}}
+	if len(p.TargetList()) != 2 || p.TargetList()[0] != want[0] || p.TargetList()[1] != want[1] {
+		t.Fatalf("TargetList() = %+v, want %+v", p.TargetList(), want)
+	}
+	if p.Balance != BalanceWeighted {
+		t.Errorf("Balance = %q, want weighted", p.Balance)
+	}
+	if p.Health.MaxFailures != 5 || p.Health.Cooldown != 10*time.Second {
+		t.Errorf("Health = %+v, want max_failures=5 cooldown=10s", p.Health)
+	}
+}
+
 func TestLoadInvalidFile(t *testing.T) {
 	_, _, err := Load([]string{"/nonexistent/config.yml"}, CliOverrides{})
 	if err == nil {
@@ -275,7 +311,7 @@ proxy:
 	}
 	headers := make(map[string]string)
 
-	modified, appliedValues := ProcessRequest(data, headers, cfg.Proxies[0].Routes[0].Compiled, 0, "", "")
+	modified, appliedValues := ProcessRequest(data, headers, cfg.Proxies[0].Routes[0].Compiled, 0, "", "", nil)
 
 	if !modified {
 		t.Error("Expected template to be applied")
@@ -798,6 +834,244 @@ proxy:
 	}
 }
 
+func TestLoadInterpolatesEnvAndSecrets(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("MM_UPSTREAM", "http://gpu-box:8080")
+	t.Setenv("MM_DEBUG", "true")
+	t.Setenv("MM_EMPTY", "")
+
+	secretsDir := filepath.Join(tmpDir, "rules", "secrets")
+	if err := os.MkdirAll(secretsDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTempConfig(t, secretsDir, "api_key", "sk-test-123\n")
+
+	// Secret paths in an include resolve against the include's directory
+	writeTempConfig(t, filepath.Join(tmpDir, "rules"), "auth.yml", `
+- set_headers:
+    Authorization: "Bearer ${file:secrets/api_key}"
+`)
+	configPath := writeTempConfig(t, tmpDir, "main.yml", `
+proxy:
+  listen: ${env:MM_LISTEN:-localhost:9091}
+  target: ${env:MM_UPSTREAM}
+  timeout: ${env:MM_TIMEOUT:-15s}
+  debug: ${env:MM_DEBUG}
+  routes:
+    - methods: POST
+      paths: ^/models/(?P<model>[^/]+)/chat$
+      target_path: /v1/chat/completions
+      on_request:
+        - include: rules/auth.yml
+        - merge:
+            model: "${model}"
+            note: "$${env:MM_UPSTREAM} ${env:MM_EMPTY:-fallback} [${env:MM_EMPTY}]"
+`)
+
+	cfg, watched, err := Load([]string{configPath}, CliOverrides{})
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+
+	proxy := cfg.Proxies[0]
+	if proxy.Listen != "localhost:9091" || proxy.Target != "http://gpu-box:8080" {
+		t.Errorf("listen/target = %s %s", proxy.Listen, proxy.Target)
+	}
+	if proxy.Timeout != 15*time.Second || !proxy.Debug {
+		t.Errorf("timeout/debug = %v %v, want typed values decoded from interpolated strings", proxy.Timeout, proxy.Debug)
+	}
+
+	actions := proxy.Routes[0].OnRequest
+	if got := actions[0].SetHeaders["Authorization"]; got != "Bearer sk-test-123" {
+		t.Errorf("Authorization = %q, want secret from file", got)
+	}
+	if got := actions[1].Merge["model"]; got != "${model}" {
+		t.Errorf("model = %v, want request-time reference left intact", got)
+	}
+	if got, want := actions[1].Merge["note"], "${env:MM_UPSTREAM} fallback []"; got != want {
+		t.Errorf("note = %v, want %v", got, want)
+	}
+
+	secretPath := filepath.Join(secretsDir, "api_key")
+	found := false
+	for _, p := range watched {
+		found = found || p == secretPath
+	}
+	if !found {
+		t.Errorf("expected secret file to be watched, got %v", watched)
+	}
+
+	if got := cfg.Redact("key=sk-test-123"); got != "key=[REDACTED]" {
+		t.Errorf("Redact() = %q", got)
+	}
+}
+
+func TestLoadLeavesBareReferencesForRequestTime(t *testing.T) {
+	t.Setenv("model", "from-env")
+	t.Setenv("MODEL", "from-env")
+	configPath := writeTempConfig(t, t.TempDir(), "main.yml", `
+proxy:
+  listen: localhost:9091
+  target: http://localhost:8080
+  routes:
+    - methods: POST
+      paths: ^/models/(?P<model>[^/]+)/chat$
+      on_request:
+        - merge:
+            model: ${model}
+            upper: ${MODEL}
+`)
+
+	cfg, _, err := Load([]string{configPath}, CliOverrides{})
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+	merge := cfg.Proxies[0].Routes[0].OnRequest[0].Merge
+	if merge["model"] != "${model}" || merge["upper"] != "${MODEL}" {
+		t.Errorf("merge = %v, want references left for request time", merge)
+	}
+}
+
+func TestLoadRejectsUnsetEnvReferences(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		wantErr string
+	}{
+		{"unset variable", "${env:MM_TYPO}", "line 4: environment variable MM_TYPO is not set"},
+		{"invalid name", "${env:1BAD}", "invalid environment reference ${env:1BAD}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configPath := writeTempConfig(t, t.TempDir(), "main.yml", `
+proxy:
+  listen: localhost:9091
+  target: `+tt.value+`
+`)
+			_, _, err := Load([]string{configPath}, CliOverrides{})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Load() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadRedactsEnvValuesInAddresses(t *testing.T) {
+	t.Setenv("MM_KEY", "env-key-456")
+	t.Setenv("MM_MODEL", "qwen3")
+	configPath := writeTempConfig(t, t.TempDir(), "main.yml", `
+proxy:
+  listen: localhost:9091
+  targets:
+    - url: http://gpu-box:8080?key=${env:MM_KEY}
+  routes:
+    - methods: POST
+      paths: ^/v1/chat/completions$
+      on_request:
+        - merge:
+            model: ${env:MM_MODEL}
+`)
+
+	cfg, _, err := Load([]string{configPath}, CliOverrides{})
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+	if got := cfg.Redact(cfg.Proxies[0].Targets[0].URL); got != "http://gpu-box:8080?key=[REDACTED]" {
+		t.Errorf("Redact(target) = %q", got)
+	}
+	// Values outside addresses aren't treated as secrets
+	if got := cfg.Redact("model=qwen3"); got != "model=qwen3" {
+		t.Errorf("Redact(model) = %q", got)
+	}
+}
+
+func TestLoadMissingSecretFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := writeTempConfig(t, tmpDir, "main.yml", `
+proxy:
+  listen: localhost:8081
+  target: http://localhost:8080
+  routes:
+    - methods: POST
+      paths: /v1/chat
+      on_request:
+        - set_headers:
+            Authorization: "Bearer ${file:missing_key}"
+`)
+
+	_, _, err := Load([]string{configPath}, CliOverrides{})
+	if err == nil || !strings.Contains(err.Error(), "line 10: failed to read secret file") {
+		t.Fatalf("expected missing secret error with line, got %v", err)
+	}
+}
+
+func TestLoadReportsAllErrorsWithPositions(t *testing.T) {
+	tmpDir := t.TempDir()
+	writeTempConfig(t, tmpDir, "rules.yml", `
+- methods: POST
+  paths: ^/chat$
+  on_requst:
+    - merge: {a: 1}
+  on_response:
+    - match_body:
+        model: "["
+      merge: {b: 2}
+    - template: "{{ .model "
+`)
+	configPath := writeTempConfig(t, tmpDir, "main.yml", `
+proxy:
+  listen: localhost:8081
+  target: http://localhost:8080
+  timout: 5s
+  routes:
+    - include: rules.yml
+`)
+
+	_, _, err := Load([]string{configPath}, CliOverrides{})
+	if err == nil {
+		t.Fatal("expected load to fail")
+	}
+
+	mainPath := filepath.Join(tmpDir, "main.yml")
+	rulesPath := filepath.Join(tmpDir, "rules.yml")
+	for _, want := range []string{
+		mainPath + ":5:3: unknown key 'timout' (did you mean 'timeout'?)",
+		rulesPath + ":4:3: unknown key 'on_requst' (did you mean 'on_request'?)",
+		rulesPath + ":8:9: route 0 on_response 0 match_body 'model': invalid regex pattern",
+		rulesPath + ":10:7: rule 0 response operation 1",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error missing %q, got:\n%v", want, err)
+		}
+	}
+}
+
+func TestTemplateErrorsReportColumnsInTheUsersTemplate(t *testing.T) {
+	cfg := mustParseConfig(t, `
+proxy:
+  listen: localhost:8081
+  target: http://localhost:8080
+  routes:
+    - methods: POST
+      paths: ^/chat$
+      on_request:
+        - template: '{"n": {{ .items.x.y }}}'
+`)
+
+	var logs bytes.Buffer
+	logger.SetOutput(&logs)
+	t.Cleanup(func() { logger.SetOutput(os.Stdout) })
+
+	body := map[string]any{"items": "not a map"}
+	ProcessRequest(body, nil, cfg.Proxies[0].Routes[0].Compiled, 0, "POST", "/chat", nil)
+
+	// The same column text/template reports for the template on its own, without the $vars prelude
+	want := `template: proxy_0_rule_0_request_0:1:15: executing`
+	if !strings.Contains(logs.String(), want) {
+		t.Fatalf("expected %q in logs, got:\n%s", want, logs.String())
+	}
+}
+
 func TestLoadActionIncludesAreExpanded(t *testing.T) {
 	tmpDir := t.TempDir()
 
@@ -7,6 +7,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"maps"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
 	"text/template"
 	"time"
 
@@ -19,40 +23,250 @@ type CompiledRoute struct {
 	OnResponse          []ActionExec
 	OnRequestTemplates  []*template.Template
 	OnResponseTemplates []*template.Template
+
+	// Actions for the message assembled from a stream
+	OnStreamEnd          []ActionExec
+	OnStreamEndTemplates []*template.Template
+
+	// Header value templates keyed by their source text
+	HeaderTemplates map[string]*template.Template
+}
+
+// Exchange carries per-request state between the proxy and action processing
+// A nil Exchange is allowed; outputs are then discarded.
+type Exchange struct {
+	// Upstream is the target URL selected for this request (input)
+	Upstream string
+
+	// HeaderEdits collects header rewrites from matched actions (output)
+	HeaderEdits []HeaderEdit
+
+	// Target is the upstream override from the last matched action with a target (output)
+	Target string
+
+	// Event and EventID describe the SSE event being processed when streaming (input)
+	Event   string
+	EventID string
+
+	// Vars holds values captured by earlier actions of the same request (input and output)
+	// The proxy shares one map between the request and response phases.
+	Vars map[string]any
+
+	// Request is the client request as it arrived, and Status the upstream status code
+	// once a response exists (input). Response templates read them as .request and .status.
+	Request *RequestInfo
+	Status  int
+
+	// RawBody is a non-JSON error body, for actions that reshape errors (input)
+	RawBody string
+
+	// StatusOverride is the status from the last matched action with set_status (output)
+	StatusOverride int
+
+	// Trace receives every action considered, matched or not, when set (input)
+	Trace func(TraceStep)
+}
+
+// RequestInfo describes the client request before any actions ran
+type RequestInfo struct {
+	Body    map[string]any
+	Headers map[string]string
+	Method  string
+	Path    string
+}
+
+// match_body keys that read exchange metadata instead of the body
+const (
+	matchKeyEvent   = "$event"
+	matchKeyEventID = "$event_id"
+	matchKeyVars    = "$vars."
+)
+
+func isMatchMetaKey(key string) bool {
+	return key == matchKeyEvent || key == matchKeyEventID || strings.HasPrefix(key, matchKeyVars)
 }
 
 // ActionExec represents an action during execution (converted from Action)
 type ActionExec struct {
-	MatchBody    map[string]PatternField
-	MatchHeaders map[string]PatternField
-	Template     string
-	Merge        map[string]any
-	Default      map[string]any
-	Delete       []string
-	Stop         bool
+	MatchBody     map[string]PatternField
+	MatchHeaders  map[string]PatternField
+	MatchTarget   PatternField
+	MatchStatus   StatusField
+	Template      string
+	Merge         map[string]any
+	MergeDeep     map[string]any
+	Default       map[string]any
+	Delete        []string
+	Stop          bool
+	SetHeaders    map[string]string
+	AddHeaders    map[string]string
+	RemoveHeaders []string
+	Target        string
+	ArrayMerge    string
+	ArrayMergeKey string
+	Capture       map[string]string
+	SetStatus     int
+	ErrorFormat   string
+	Source        *Source
+
+	// merge, merge_deep, default and delete paths, parsed once and ordered shortest first
+	pathsCompiled  bool
+	mergePaths     []bodyPath
+	mergeDeepPaths []bodyPath
+	defaultPaths   []bodyPath
+	deletePaths    []bodyPath
+}
+
+// bodyPath is a merge/default/delete key with its parsed path
+type bodyPath struct {
+	key  string
+	path Path
+}
+
+// compilePaths parses the action's body edit paths
+// Parents sort before their children, so `a` is written before `a.b` regardless of map order.
+func (op *ActionExec) compilePaths() {
+	op.mergePaths = parseBodyPaths("merge", slices.Collect(maps.Keys(op.Merge)))
+	op.mergeDeepPaths = parseBodyPaths("merge_deep", slices.Collect(maps.Keys(op.MergeDeep)))
+	op.defaultPaths = parseBodyPaths("default", slices.Collect(maps.Keys(op.Default)))
+	op.deletePaths = parseBodyPaths("delete", op.Delete)
+	op.pathsCompiled = true
 }
 
-// toStringMap converts map[string]any to map[string]string for pattern matching
-func toStringMap(data map[string]any) map[string]string {
-	result := make(map[string]string, len(data))
-	for key, value := range data {
-		result[key] = fmt.Sprintf("%v", value)
+func parseBodyPaths(kind string, keys []string) []bodyPath {
+	paths := make([]bodyPath, 0, len(keys))
+	for _, key := range keys {
+		path, err := ParsePath(key)
+		if err != nil {
+			logger.Error("Invalid "+kind+" path", "path", key, "err", err)
+			continue
+		}
+		paths = append(paths, bodyPath{key: key, path: path})
+	}
+	sort.SliceStable(paths, func(i, j int) bool {
+		if len(paths[i].path) != len(paths[j].path) {
+			return len(paths[i].path) < len(paths[j].path)
+		}
+		return paths[i].key < paths[j].key
+	})
+	return paths
+}
+
+// matchBody reports whether every match_body path resolves to values matching its patterns
+// Wildcard paths match when any resolved value matches; paths that resolve to nothing
+// only match conditions such as {exists: false}.
+func matchBody(data map[string]any, criteria map[string]PatternField, ex *Exchange) bool {
+	for key, pattern := range criteria {
+		if !pattern.MatchesValues(matchValues(data, key, ex)) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchHeaders reports whether every header matches its patterns
+// Missing headers only match conditions such as {exists: false}.
+func matchHeaders(headers map[string]string, criteria map[string]PatternField) bool {
+	for key, pattern := range criteria {
+		var values []any
+		if actualValue, exists := headers[key]; exists {
+			values = []any{actualValue}
+		}
+		if !pattern.MatchesValues(values) {
+			return false
+		}
 	}
-	return result
+	return true
+}
+
+// MatchesRequest reports whether a request passes the route's match_body and match_headers gates
+// Routes without gates always pass; bodies that are not JSON (nil data) fail any match_body.
+func (r *Route) MatchesRequest(data map[string]any, headers map[string]string, ex *Exchange) bool {
+	if len(r.MatchBody) > 0 && (data == nil || !matchBody(data, r.MatchBody, ex)) {
+		return false
+	}
+	return len(r.MatchHeaders) == 0 || matchHeaders(headers, r.MatchHeaders)
+}
+
+// matches reports whether the action's match criteria all hold
+func (op *ActionExec) matches(original map[string]any, headers map[string]string, ex *Exchange) bool {
+	// Check body matching
+	if len(op.MatchBody) > 0 && !matchBody(original, op.MatchBody, ex) {
+		return false
+	}
+
+	// Check headers matching
+	if len(op.MatchHeaders) > 0 && !matchHeaders(headers, op.MatchHeaders) {
+		return false
+	}
+
+	// Check response status matching; requests have no status and never match
+	if op.MatchStatus.Len() > 0 && (ex == nil || !op.MatchStatus.Matches(ex.Status)) {
+		return false
+	}
+
+	// Check upstream target matching
+	if op.MatchTarget.Len() > 0 {
+		upstream := ""
+		if ex != nil {
+			upstream = ex.Upstream
+		}
+		if !op.MatchTarget.Matches(upstream) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchValues resolves a match_body key to the values it is tested against
+// $event and $event_id read the current SSE event, $vars.<path> reads captured
+// variables; other keys are body paths.
+func matchValues(data map[string]any, key string, ex *Exchange) []any {
+	if isMatchMetaKey(key) {
+		if ex == nil {
+			return nil
+		}
+		if rest, ok := strings.CutPrefix(key, matchKeyVars); ok {
+			path, err := ParsePath(rest)
+			if err != nil {
+				return nil
+			}
+			return path.Lookup(ex.Vars)
+		}
+		value := ex.Event
+		if key == matchKeyEventID {
+			value = ex.EventID
+		}
+		if value == "" {
+			return nil
+		}
+		return []any{value}
+	}
+
+	path, err := ParsePath(key)
+	if err != nil {
+		return nil
+	}
+	return path.Lookup(data)
 }
 
 // ProcessRequest applies all request actions to data
-func ProcessRequest(data map[string]any, headers map[string]string, route *CompiledRoute, ruleIndex int, method, path string) (bool, map[string]any) {
-	return processActions("request", data, headers, ruleIndex, method, path, route.OnRequest, route.OnRequestTemplates)
+func ProcessRequest(data map[string]any, headers map[string]string, route *CompiledRoute, ruleIndex int, method, path string, ex *Exchange) (bool, map[string]any) {
+	return processActions("request", data, headers, ruleIndex, method, path, route.OnRequest, route.OnRequestTemplates, route.HeaderTemplates, ex)
 }
 
 // ProcessResponse applies all response actions to data
-func ProcessResponse(data map[string]any, headers map[string]string, route *CompiledRoute, ruleIndex int, method, path string) (bool, map[string]any) {
-	return processActions("response", data, headers, ruleIndex, method, path, route.OnResponse, route.OnResponseTemplates)
+func ProcessResponse(data map[string]any, headers map[string]string, route *CompiledRoute, ruleIndex int, method, path string, ex *Exchange) (bool, map[string]any) {
+	return processActions("response", data, headers, ruleIndex, method, path, route.OnResponse, route.OnResponseTemplates, route.HeaderTemplates, ex)
+}
+
+// ProcessStreamEnd applies on_stream_end actions to a message assembled from a stream
+func ProcessStreamEnd(data map[string]any, headers map[string]string, route *CompiledRoute, ruleIndex int, method, path string, ex *Exchange) (bool, map[string]any) {
+	return processActions("stream_end", data, headers, ruleIndex, method, path, route.OnStreamEnd, route.OnStreamEndTemplates, route.HeaderTemplates, ex)
 }
 
 // processActions applies actions to data with their compiled templates
-func processActions(phase string, data map[string]any, headers map[string]string, ruleIndex int, method, path string, operations []ActionExec, templates []*template.Template) (bool, map[string]any) {
+func processActions(phase string, data map[string]any, headers map[string]string, ruleIndex int, method, path string, operations []ActionExec, templates []*template.Template, headerTemplates map[string]*template.Template, ex *Exchange) (bool, map[string]any) {
 	appliedValues := make(map[string]any)
 	anyApplied := false
 	addedKeys := make([]string, 0)
@@ -60,49 +274,26 @@ func processActions(phase string, data map[string]any, headers map[string]string
 	deletedKeys := make([]string, 0)
 	opExecuted := 0
 
-	// Convert body data to strings for pattern matching
-	bodyStrings := toStringMap(data)
+	// Match against the body as it arrived, not as earlier actions leave it
+	original := deepCopy(data)
 
 	for i, op := range operations {
-		// Check body matching
-		bodyMatch := true
-		if len(op.MatchBody) > 0 {
-			for key, pattern := range op.MatchBody {
-				actualValue, exists := bodyStrings[key]
-				if !exists {
-					bodyMatch = false
-					break
-				}
-				if !pattern.Matches(actualValue) {
-					bodyMatch = false
-					break
-				}
+		if !op.matches(original, headers, ex) {
+			if ex.tracing() {
+				ex.trace(TraceStep{Phase: phase, Route: ruleIndex, Action: i, Reason: actionMiss(&op, original, headers, ex)})
 			}
-		}
-
-		if !bodyMatch {
 			continue
 		}
 
-		// Check headers matching
-		headersMatch := true
-		if len(op.MatchHeaders) > 0 {
-			for key, pattern := range op.MatchHeaders {
-				actualValue, exists := headers[key]
-				if !exists {
-					headersMatch = false
-					break
-				}
-				if !pattern.Matches(actualValue) {
-					headersMatch = false
-					break
-				}
-			}
+		// Regex groups from match_body are readable as ${name} from this action on
+		if len(op.MatchBody) > 0 {
+			storeCaptures(ex, bodyCaptures(original, op.MatchBody, ex), phase, i)
 		}
 
-		if !headersMatch {
-			continue
+		if len(op.Capture) > 0 {
+			captureVars(original, op.Capture, ex, phase, i)
 		}
+		vars := exchangeVars(ex)
 
 		// Capture values before for diff
 		beforeValues := make(map[string]any)
@@ -110,36 +301,89 @@ func processActions(phase string, data map[string]any, headers map[string]string
 			beforeValues[k] = v
 		}
 
-		// Track changes for this specific operation
+		// Track changes for this specific operation, keyed by full path
 		opChanges := make(map[string]any)
+		existed := make(map[string]bool)
 
 		// Execute template if present
 		if op.Template != "" && templates[i] != nil {
-			if ExecuteTemplate(templates[i], data, data, phase, ruleIndex, i, method, path) {
+			if ExecuteTemplate(exchangeTemplate(templates[i], ex), templateContext(data, ex), data, phase, ruleIndex, i, method, path) {
 				maps.Copy(appliedValues, data)
 				maps.Copy(opChanges, data)
+				for k := range data {
+					_, existed[k] = beforeValues[k]
+				}
 				anyApplied = true
 			}
 		}
 
+		if op.ErrorFormat == ErrorFormatOpenAI {
+			raw, status := "", 0
+			if ex != nil {
+				raw, status = ex.RawBody, ex.Status
+			}
+			converted := openAIErrorBody(data, raw, status)
+			for k := range data {
+				if _, kept := converted[k]; !kept {
+					opChanges[k] = "<deleted>"
+					existed[k] = true
+				}
+				delete(data, k)
+			}
+			for k, v := range converted {
+				data[k] = v
+				opChanges[k] = v
+				_, existed[k] = beforeValues[k]
+			}
+		}
+
 		// Apply other operations
+		// Actions built in code rather than by CompileTemplates parse their paths here
+		if !op.pathsCompiled {
+			op.compilePaths()
+		}
 		if len(op.Default) > 0 {
-			applyDefault(data, op.Default, opChanges)
-			for k, v := range opChanges {
-				appliedValues[k] = v
-			}
+			applyDefault(data, op.defaultPaths, expandValues(op.Default, vars), opChanges, existed)
 		}
 		if len(op.Merge) > 0 {
-			applyMerge(data, op.Merge, opChanges)
-			for k, v := range opChanges {
-				appliedValues[k] = v
-			}
+			applyMerge(data, op.mergePaths, expandValues(op.Merge, vars), opChanges, existed)
+		}
+		if len(op.MergeDeep) > 0 {
+			applyMergeDeep(data, op.mergeDeepPaths, expandValues(op.MergeDeep, vars), op.ArrayMerge, op.ArrayMergeKey, opChanges, existed)
 		}
 		if len(op.Delete) > 0 {
-			applyDelete(data, op.Delete, opChanges)
-			for k, v := range opChanges {
-				appliedValues[k] = v
+			applyDelete(data, op.deletePaths, opChanges, existed)
+		}
+		maps.Copy(appliedValues, opChanges)
+
+		if edits := headerEdits(op, data, headerTemplates, ex, phase, ruleIndex, i); len(edits) > 0 {
+			applyHeaderEdits(headers, edits)
+			if ex != nil {
+				ex.HeaderEdits = append(ex.HeaderEdits, edits...)
 			}
+			names := make([]string, 0, len(edits))
+			for _, e := range edits {
+				names = append(names, e.Op+":"+e.Name)
+			}
+			logger.Debug("Action header edits", "phase", phase, "index", i, "headers", names)
+			anyApplied = true
+		}
+
+		if op.SetStatus != 0 {
+			if ex != nil {
+				ex.StatusOverride = op.SetStatus
+			}
+			logger.Debug("Action status override", "phase", phase, "index", i, "status", op.SetStatus)
+			anyApplied = true
+		}
+
+		if op.Target != "" {
+			target := ExpandVars(op.Target, vars)
+			if ex != nil {
+				ex.Target = target
+			}
+			logger.Debug("Action target override", "phase", phase, "index", i, "target", RedactURL(target))
+			anyApplied = true
 		}
 
 		opExecuted++
@@ -150,8 +394,7 @@ func processActions(phase string, data map[string]any, headers map[string]string
 			for key, newValue := range opChanges {
 				if newValue == "<deleted>" {
 					deletedKeys = append(deletedKeys, key)
-				} else if oldValue, existed := beforeValues[key]; existed {
-					_ = oldValue
+				} else if existed[key] {
 					updatedKeys = append(updatedKeys, key)
 				} else {
 					addedKeys = append(addedKeys, key)
@@ -159,6 +402,10 @@ func processActions(phase string, data map[string]any, headers map[string]string
 			}
 		}
 
+		if ex.tracing() {
+			ex.trace(TraceStep{Phase: phase, Route: ruleIndex, Action: i, Matched: true, Reason: actionHit(&op), Stop: op.Stop, Body: deepCopy(data)})
+		}
+
 		if op.Stop {
 			logger.Debug("Action stop flag set", "index", i)
 			break
@@ -172,33 +419,238 @@ func processActions(phase string, data map[string]any, headers map[string]string
 	return anyApplied, appliedValues
 }
 
-func applyMerge(data map[string]any, mergeValues map[string]any, appliedValues map[string]any) {
-	for key, value := range mergeValues {
-		data[key] = value
-		appliedValues[key] = value
+// captureVars stores the values at each path under its variable name
+// Wildcard paths capture a list; paths that resolve to nothing leave the variable unset.
+func captureVars(data map[string]any, capture map[string]string, ex *Exchange, phase string, opIndex int) {
+	if ex == nil {
+		return
+	}
+	if ex.Vars == nil {
+		ex.Vars = make(map[string]any)
+	}
+	for name, raw := range capture {
+		path, err := ParsePath(raw)
+		if err != nil {
+			logger.Error("Invalid capture path", "path", raw, "err", err)
+			continue
+		}
+		values := path.Lookup(data)
+		switch {
+		case len(values) == 0:
+			continue
+		case path.hasWildcard():
+			ex.Vars[name] = copyValue(values)
+		default:
+			ex.Vars[name] = copyValue(values[0])
+		}
+		logger.Debug("Captured variable", "phase", phase, "index", opIndex, "name", name, "value", ex.Vars[name])
+	}
+}
+
+// deepCopy clones nested JSON maps and slices so later mutation leaves the copy intact
+func deepCopy(data map[string]any) map[string]any {
+	if data == nil {
+		return nil
+	}
+	return copyValue(data).(map[string]any)
+}
+
+func copyValue(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, val := range v {
+			out[k] = copyValue(val)
+		}
+		return out
+	case []any:
+		out := make([]any, len(v))
+		for i, val := range v {
+			out[i] = copyValue(val)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// applyMerge sets each path, creating intermediate maps as needed
+// appliedValues and existed are keyed by the concrete paths written.
+func applyMerge(data map[string]any, paths []bodyPath, mergeValues map[string]any, appliedValues map[string]any, existed map[string]bool) {
+	for _, p := range paths {
+		recordChanges(p.path.Set(data, mergeValues[p.key]), appliedValues, existed)
 	}
 }
 
-func applyDefault(data map[string]any, defaultValues map[string]any, appliedValues map[string]any) {
-	for key, value := range defaultValues {
-		if _, exists := data[key]; !exists {
-			data[key] = value
-			appliedValues[key] = value
+// applyMergeDeep recursively merges maps at each path instead of replacing them
+// Arrays follow arrayMode; union matches map items on arrayKey when set, otherwise on equality.
+func applyMergeDeep(data map[string]any, paths []bodyPath, mergeValues map[string]any, arrayMode, arrayKey string, appliedValues map[string]any, existed map[string]bool) {
+	for _, p := range paths {
+		value := mergeValues[p.key]
+		changes := p.path.write(data, true, func(current any, exists bool) (any, bool, bool) {
+			if !exists {
+				return copyValue(value), true, true
+			}
+			return deepMergeValue(current, copyValue(value), arrayMode, arrayKey), true, true
+		})
+		recordChanges(changes, appliedValues, existed)
+	}
+}
+
+// deepMergeValue merges src into dst, recursing into maps and combining arrays per arrayMode
+func deepMergeValue(dst, src any, arrayMode, arrayKey string) any {
+	switch s := src.(type) {
+	case map[string]any:
+		d, ok := dst.(map[string]any)
+		if !ok {
+			return s
 		}
+		for k, v := range s {
+			if existing, exists := d[k]; exists {
+				d[k] = deepMergeValue(existing, v, arrayMode, arrayKey)
+			} else {
+				d[k] = v
+			}
+		}
+		return d
+	case []any:
+		d, ok := dst.([]any)
+		if !ok {
+			return s
+		}
+		switch arrayMode {
+		case ArrayMergeAppend:
+			return append(d, s...)
+		case ArrayMergePrepend:
+			return append(s, d...)
+		case ArrayMergeUnion:
+			return unionArrays(d, s, arrayMode, arrayKey)
+		default:
+			return s
+		}
+	default:
+		return src
 	}
 }
 
-func applyDelete(data map[string]any, deleteKeys []string, appliedValues map[string]any) {
-	for _, key := range deleteKeys {
-		if _, exists := data[key]; exists {
-			delete(data, key)
-			appliedValues[key] = "<deleted>"
+// unionArrays appends src items missing from dst
+// With a key, map items sharing the key's value are deep-merged in place.
+func unionArrays(dst, src []any, arrayMode, arrayKey string) []any {
+	for _, item := range src {
+		matched := false
+		for i, existing := range dst {
+			if arrayKey != "" {
+				em, eok := existing.(map[string]any)
+				im, iok := item.(map[string]any)
+				if !eok || !iok {
+					continue
+				}
+				ev, eHas := em[arrayKey]
+				iv, iHas := im[arrayKey]
+				if eHas && iHas && reflect.DeepEqual(ev, iv) {
+					dst[i] = deepMergeValue(existing, item, arrayMode, arrayKey)
+					matched = true
+					break
+				}
+				continue
+			}
+			if reflect.DeepEqual(existing, item) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			dst = append(dst, item)
 		}
 	}
+	return dst
+}
+
+// applyDefault sets each path only where it is missing
+func applyDefault(data map[string]any, paths []bodyPath, defaultValues map[string]any, appliedValues map[string]any, existed map[string]bool) {
+	for _, p := range paths {
+		recordChanges(p.path.SetDefault(data, defaultValues[p.key]), appliedValues, existed)
+	}
+}
+
+// applyDelete removes every map key or array element each path addresses
+func applyDelete(data map[string]any, paths []bodyPath, appliedValues map[string]any, existed map[string]bool) {
+	for _, p := range paths {
+		recordChanges(p.path.Delete(data), appliedValues, existed)
+	}
+}
+
+func recordChanges(changes []pathChange, appliedValues map[string]any, existed map[string]bool) {
+	for _, c := range changes {
+		if c.Deleted {
+			appliedValues[c.Path] = "<deleted>"
+		} else {
+			appliedValues[c.Path] = c.Value
+		}
+		if _, seen := existed[c.Path]; !seen {
+			existed[c.Path] = c.Existed
+		}
+	}
+}
+
+// templateContext is the dot context for templates: the body's own fields at the top level,
+// plus .body, .request and .status wherever the body does not already use those names.
+// Request templates keep the bare body; the extra fields only exist once there is a response.
+func templateContext(data map[string]any, ex *Exchange) map[string]any {
+	if ex == nil || ex.Status == 0 {
+		return data
+	}
+	ctx := make(map[string]any, len(data)+3)
+	maps.Copy(ctx, data)
+
+	extra := map[string]any{"body": data}
+	if ex.Request != nil {
+		headers := make(map[string]any, len(ex.Request.Headers))
+		for k, v := range ex.Request.Headers {
+			headers[k] = v
+		}
+		extra["request"] = map[string]any{
+			"body":    ex.Request.Body,
+			"headers": headers,
+			"method":  ex.Request.Method,
+			"path":    ex.Request.Path,
+		}
+	}
+	if ex.Status != 0 {
+		extra["status"] = ex.Status
+	}
+	for k, v := range extra {
+		if _, taken := ctx[k]; !taken {
+			ctx[k] = v
+		}
+	}
+	return ctx
+}
+
+// exchangeTemplate binds the per-exchange helpers (event, eventId, vars) for one execution
+// Templates are only cloned when there is something to bind; otherwise the placeholders return empty values.
+func exchangeTemplate(tmpl *template.Template, ex *Exchange) *template.Template {
+	if ex == nil || (ex.Event == "" && ex.EventID == "" && len(ex.Vars) == 0) {
+		return tmpl
+	}
+	clone, err := tmpl.Clone()
+	if err != nil {
+		return tmpl
+	}
+	return clone.Funcs(template.FuncMap{
+		"event":   func() string { return ex.Event },
+		"eventId": func() string { return ex.EventID },
+		"vars":    func() map[string]any { return ex.Vars },
+	})
 }
 
 // TemplateFuncs provides helper functions for Go templates
 var TemplateFuncs = template.FuncMap{
+	// Current SSE event type and id, and captured variables; bound per exchange by exchangeTemplate
+	"event":   func() string { return "" },
+	"eventId": func() string { return "" },
+	"vars":    func() map[string]any { return map[string]any{} },
+
 	// JSON marshaling
 	"toJson": func(v any) string {
 		b, err := json.Marshal(v)
@@ -418,7 +870,7 @@ func checkKind(kind string, value any) bool {
 func ExecuteTemplate(tmpl *template.Template, input map[string]any, output map[string]any, phase string, ruleIndex, opIndex int, method, path string) bool {
 	var buf bytes.Buffer
 	if err := tmpl.Execute(&buf, input); err != nil {
-		logger.Error("Template execution error", "phase", phase, "rule_index", ruleIndex, "op_index", opIndex, "method", method, "path", path, "err", err)
+		logger.Error("Template execution error", "phase", phase, "rule_index", ruleIndex, "op_index", opIndex, "method", method, "path", path, "err", templateError(err))
 		return false
 	}
 
@@ -1,6 +1,10 @@
 package config
 
-import "testing"
+import (
+	"reflect"
+	"testing"
+	"text/template"
+)
 
 func TestProcessActionsMatchHeadersDeleteAndStop(t *testing.T) {
 	envPattern := PatternField{Patterns: []string{"prod"}}
@@ -39,7 +43,7 @@ func TestProcessActionsMatchHeadersDeleteAndStop(t *testing.T) {
 		"remove_me": "y",
 	}
 
-	modified, applied := processActions("test", body, headers, 0, "", "", ops, nil)
+	modified, applied := processActions("test", body, headers, 0, "", "", ops, nil, nil, nil)
 	if !modified {
 		t.Fatal("expected modifications to be applied")
 	}
@@ -85,7 +89,7 @@ func TestProcessResponseHeaderFilter(t *testing.T) {
 	headers := map[string]string{"Content-Type": "application/json"}
 	body := map[string]any{"message": "hi"}
 
-	modified, applied := ProcessResponse(body, headers, compiled, 0, "", "")
+	modified, applied := ProcessResponse(body, headers, compiled, 0, "", "", nil)
 	if !modified {
 		t.Fatal("expected response to be modified")
 	}
@@ -99,7 +103,7 @@ func TestProcessResponseHeaderFilter(t *testing.T) {
 	// Negative header match should no-op
 	headers["Content-Type"] = "text/plain"
 	body = map[string]any{"message": "hi"}
-	modified, _ = ProcessResponse(body, headers, compiled, 0, "", "")
+	modified, _ = ProcessResponse(body, headers, compiled, 0, "", "", nil)
 	if modified {
 		t.Fatal("expected no modification for non-matching headers")
 	}
@@ -110,7 +114,7 @@ func TestProcessResponseHeaderFilter(t *testing.T) {
 	// Sanity: ensure Matches ignores header casing
 	headers = map[string]string{"Content-Type": "Application/Json"}
 	body = map[string]any{"message": "hi"}
-	if modified, _ := ProcessResponse(body, headers, compiled, 0, "", ""); !modified {
+	if modified, _ := ProcessResponse(body, headers, compiled, 0, "", "", nil); !modified {
 		t.Fatal("expected case-insensitive header match to modify response")
 	}
 	if body["tag"] != "processed" {
@@ -141,3 +145,586 @@ func TestDictHelperOddArgs(t *testing.T) {
 		t.Fatalf("expected empty map on odd args, got %v", result)
 	}
 }
+
+func TestProcessActionsMatchBodyNestedPaths(t *testing.T) {
+	ops := []ActionExec{
+		{
+			MatchBody: map[string]PatternField{
+				"messages[0].role": newPatternField("^system$"),
+			},
+			Merge: map[string]any{"has_system": true},
+		},
+		{
+			MatchBody: map[string]PatternField{
+				"tools[*].function.name": newPatternField("^fetch$"),
+			},
+			Merge: map[string]any{"has_fetch": true},
+		},
+		{
+			MatchBody: map[string]PatternField{
+				"response_format.type": newPatternField("json_schema"),
+			},
+			Merge: map[string]any{"structured": true},
+		},
+	}
+
+	body := map[string]any{
+		"messages": []any{
+			map[string]any{"role": "system", "content": "be brief"},
+		},
+		"tools": []any{
+			map[string]any{"function": map[string]any{"name": "search"}},
+			map[string]any{"function": map[string]any{"name": "fetch"}},
+		},
+	}
+
+	processActions("test", body, nil, 0, "", "", ops, make([]*template.Template, len(ops)), nil, nil)
+
+	if body["has_system"] != true {
+		t.Errorf("expected messages[0].role match, body=%v", body)
+	}
+	if body["has_fetch"] != true {
+		t.Errorf("expected wildcard tool name match, body=%v", body)
+	}
+	if _, exists := body["structured"]; exists {
+		t.Errorf("missing response_format should not match, body=%v", body)
+	}
+}
+
+func TestProcessActionsDeepPaths(t *testing.T) {
+	ops := []ActionExec{
+		{
+			Merge:   map[string]any{"options.temperature": 0.7},
+			Default: map[string]any{"options.num_ctx": 4096},
+			Delete:  []string{"stream_options.include_usage", "messages[*].name"},
+		},
+	}
+
+	body := map[string]any{
+		"options":        map[string]any{"num_ctx": 8192.0, "num_predict": 64.0},
+		"stream_options": map[string]any{"include_usage": true},
+		"messages": []any{
+			map[string]any{"role": "user", "name": "bob"},
+		},
+	}
+
+	modified, applied := processActions("test", body, nil, 0, "", "", ops, make([]*template.Template, len(ops)), nil, nil)
+	if !modified {
+		t.Fatal("expected modifications to be applied")
+	}
+
+	options := body["options"].(map[string]any)
+	if options["temperature"] != 0.7 || options["num_ctx"] != 8192.0 || options["num_predict"] != 64.0 {
+		t.Fatalf("unexpected options after deep merge/default: %v", options)
+	}
+	if _, exists := body["stream_options"].(map[string]any)["include_usage"]; exists {
+		t.Fatalf("expected include_usage deleted, got %v", body["stream_options"])
+	}
+
+	if applied["options.temperature"] != 0.7 {
+		t.Errorf("expected applied to report full merge path, got %v", applied)
+	}
+	if applied["stream_options.include_usage"] != "<deleted>" || applied["messages[0].name"] != "<deleted>" {
+		t.Errorf("expected applied to report full delete paths, got %v", applied)
+	}
+	if _, exists := applied["options.num_ctx"]; exists {
+		t.Errorf("default should not report existing path, got %v", applied)
+	}
+}
+
+func TestProcessActionsOverlappingPaths(t *testing.T) {
+	op := convertAction(Action{
+		Merge:   map[string]any{"a.b": 2, "a": map[string]any{"x": 1}},
+		Default: map[string]any{"opts.k": 1, "opts": map[string]any{}},
+	})
+
+	// Map iteration order varies, so repeat to catch order-dependent results
+	for range 20 {
+		body := map[string]any{}
+		processActions("test", body, nil, 0, "", "", []ActionExec{op}, []*template.Template{nil}, nil, nil)
+
+		if a := body["a"].(map[string]any); a["x"] != 1 || a["b"] != 2 {
+			t.Fatalf("expected a to be merged before a.b, got %v", body["a"])
+		}
+		if opts := body["opts"].(map[string]any); opts["k"] != 1 {
+			t.Fatalf("expected opts to be defaulted before opts.k, got %v", body["opts"])
+		}
+	}
+}
+
+func TestProcessActionsMergeDeep(t *testing.T) {
+	tests := []struct {
+		name     string
+		op       ActionExec
+		body     map[string]any
+		wantPath string
+		want     any
+	}{
+		{
+			name: "nested maps keep client values",
+			op: ActionExec{
+				MergeDeep: map[string]any{"options": map[string]any{"top_k": 20}},
+			},
+			body:     map[string]any{"options": map[string]any{"num_predict": 64.0}},
+			wantPath: "options",
+			want:     map[string]any{"num_predict": 64.0, "top_k": 20},
+		},
+		{
+			name: "missing target is created",
+			op: ActionExec{
+				MergeDeep: map[string]any{"chat_template_kwargs": map[string]any{"enable_thinking": false}},
+			},
+			body:     map[string]any{},
+			wantPath: "chat_template_kwargs",
+			want:     map[string]any{"enable_thinking": false},
+		},
+		{
+			name: "arrays replace by default",
+			op: ActionExec{
+				MergeDeep: map[string]any{"stop": []any{"</s>"}},
+			},
+			body:     map[string]any{"stop": []any{"\n"}},
+			wantPath: "stop",
+			want:     []any{"</s>"},
+		},
+		{
+			name: "arrays append",
+			op: ActionExec{
+				MergeDeep:  map[string]any{"stop": []any{"</s>"}},
+				ArrayMerge: ArrayMergeAppend,
+			},
+			body:     map[string]any{"stop": []any{"\n"}},
+			wantPath: "stop",
+			want:     []any{"\n", "</s>"},
+		},
+		{
+			name: "arrays prepend",
+			op: ActionExec{
+				MergeDeep:  map[string]any{"stop": []any{"</s>"}},
+				ArrayMerge: ArrayMergePrepend,
+			},
+			body:     map[string]any{"stop": []any{"\n"}},
+			wantPath: "stop",
+			want:     []any{"</s>", "\n"},
+		},
+		{
+			name: "arrays union by equality",
+			op: ActionExec{
+				MergeDeep:  map[string]any{"stop": []any{"\n", "</s>"}},
+				ArrayMerge: ArrayMergeUnion,
+			},
+			body:     map[string]any{"stop": []any{"\n"}},
+			wantPath: "stop",
+			want:     []any{"\n", "</s>"},
+		},
+		{
+			name: "arrays union by key",
+			op: ActionExec{
+				MergeDeep: map[string]any{"tools": []any{
+					map[string]any{"name": "search", "strict": true},
+					map[string]any{"name": "fetch"},
+				}},
+				ArrayMerge:    ArrayMergeUnion,
+				ArrayMergeKey: "name",
+			},
+			body: map[string]any{"tools": []any{
+				map[string]any{"name": "search", "description": "web"},
+			}},
+			wantPath: "tools",
+			want: []any{
+				map[string]any{"name": "search", "description": "web", "strict": true},
+				map[string]any{"name": "fetch"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ops := []ActionExec{tt.op}
+			modified, applied := processActions("test", tt.body, nil, 0, "", "", ops, make([]*template.Template, len(ops)), nil, nil)
+			if !modified {
+				t.Fatal("expected modifications to be applied")
+			}
+			if !reflect.DeepEqual(tt.body[tt.wantPath], tt.want) {
+				t.Fatalf("body[%s] = %v, want %v", tt.wantPath, tt.body[tt.wantPath], tt.want)
+			}
+			if _, ok := applied[tt.wantPath]; !ok {
+				t.Fatalf("expected applied to record %s, got %v", tt.wantPath, applied)
+			}
+		})
+	}
+
+	// Config values must not be mutated by merging into them
+	op := ActionExec{MergeDeep: map[string]any{"options": map[string]any{"top_k": 20}}}
+	body := map[string]any{}
+	processActions("test", body, nil, 0, "", "", []ActionExec{op}, []*template.Template{nil}, nil, nil)
+	body["options"].(map[string]any)["leak"] = true
+	if _, leaked := op.MergeDeep["options"].(map[string]any)["leak"]; leaked {
+		t.Fatal("merge_deep should copy config values into the body")
+	}
+}
+
+func TestProcessActionsHeaderEdits(t *testing.T) {
+	cfg := mustParseConfig(t, `
+proxy:
+  listen: "localhost:8081"
+  target: "http://localhost:8080"
+  routes:
+    - methods: POST
+      paths: /v1/chat
+      on_request:
+        - remove_headers: [X-Client-Secret]
+          set_headers:
+            authorization: "Bearer {{ .model }}-key"
+          add_headers:
+            X-Trace: one
+        - match_headers:
+            Authorization: ^Bearer qwen
+          add_headers:
+            X-Trace: two
+`)
+
+	headers := map[string]string{"X-Client-Secret": "s3cret"}
+	body := map[string]any{"model": "qwen"}
+	var ex Exchange
+
+	modified, _ := ProcessRequest(body, headers, cfg.Proxies[0].Routes[0].Compiled, 0, "", "", &ex)
+	if !modified {
+		t.Fatal("expected header edits to count as modifications")
+	}
+
+	want := []HeaderEdit{
+		{Op: HeaderRemove, Name: "X-Client-Secret"},
+		{Op: HeaderSet, Name: "Authorization", Value: "Bearer qwen-key"},
+		{Op: HeaderAdd, Name: "X-Trace", Value: "one"},
+		{Op: HeaderAdd, Name: "X-Trace", Value: "two"},
+	}
+	if !reflect.DeepEqual(ex.HeaderEdits, want) {
+		t.Fatalf("HeaderEdits = %+v, want %+v", ex.HeaderEdits, want)
+	}
+
+	// Later actions see earlier header edits when matching
+	if _, exists := headers["X-Client-Secret"]; exists {
+		t.Fatalf("expected removed header to be dropped from match headers, got %v", headers)
+	}
+}
+
+func TestProcessActionsMatchTarget(t *testing.T) {
+	cfg := mustParseConfig(t, `
+proxy:
+  listen: "localhost:8081"
+  targets: [http://localhost:8080, http://localhost:8082]
+  routes:
+    - methods: POST
+      paths: /v1/chat
+      on_request:
+        - match_target: ^http://localhost:8082$
+          merge:
+            num_ctx: 8192
+`)
+
+	tests := []struct {
+		upstream string
+		want     bool
+	}{
+		{upstream: "http://localhost:8082", want: true},
+		{upstream: "http://localhost:8080", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.upstream, func(t *testing.T) {
+			body := map[string]any{"model": "qwen"}
+			ex := Exchange{Upstream: tt.upstream}
+			ProcessRequest(body, nil, cfg.Proxies[0].Routes[0].Compiled, 0, "", "", &ex)
+			if _, applied := body["num_ctx"]; applied != tt.want {
+				t.Fatalf("match_target applied = %v, want %v (body %v)", applied, tt.want, body)
+			}
+		})
+	}
+}
+
+func TestProcessActionsCaptureVars(t *testing.T) {
+	cfg := mustParseConfig(t, `
+proxy:
+  listen: "localhost:8081"
+  target: "http://localhost:8080"
+  routes:
+    - methods: POST
+      paths: /v1/chat
+      on_request:
+        - capture:
+            original_model: model
+            roles: messages[*].role
+          merge:
+            model: qwen3-30b
+      on_response:
+        - match_body:
+            $vars.original_model: ^fast$
+          template: '{"model": "{{ $vars.original_model }}", "roles": {{ toJson $vars.roles }}}'
+          set_headers:
+            X-Alias: "{{ $vars.original_model }}"
+`)
+	compiled := cfg.Proxies[0].Routes[0].Compiled
+
+	request := map[string]any{
+		"model":    "fast",
+		"messages": []any{map[string]any{"role": "system"}, map[string]any{"role": "user"}},
+	}
+	ex := Exchange{Vars: map[string]any{}}
+	ProcessRequest(request, map[string]string{}, compiled, 0, "POST", "/v1/chat", &ex)
+	if request["model"] != "qwen3-30b" {
+		t.Fatalf("expected model alias to be rewritten, got %v", request["model"])
+	}
+	wantVars := map[string]any{"original_model": "fast", "roles": []any{"system", "user"}}
+	if !reflect.DeepEqual(ex.Vars, wantVars) {
+		t.Fatalf("Vars = %v, want %v", ex.Vars, wantVars)
+	}
+
+	response := map[string]any{"model": "qwen3-30b"}
+	respEx := Exchange{Vars: ex.Vars}
+	if modified, _ := ProcessResponse(response, map[string]string{}, compiled, 0, "POST", "/v1/chat", &respEx); !modified {
+		t.Fatal("expected $vars match_body to select the response action")
+	}
+	if response["model"] != "fast" || !reflect.DeepEqual(response["roles"], []any{"system", "user"}) {
+		t.Errorf("unexpected response body: %v", response)
+	}
+	if want := []HeaderEdit{{Op: HeaderSet, Name: "X-Alias", Value: "fast"}}; !reflect.DeepEqual(respEx.HeaderEdits, want) {
+		t.Errorf("HeaderEdits = %+v, want %+v", respEx.HeaderEdits, want)
+	}
+
+	// Without captured variables the $vars match fails and templates see empty values
+	other := map[string]any{"model": "qwen3-30b"}
+	if modified, _ := ProcessResponse(other, map[string]string{}, compiled, 0, "POST", "/v1/chat", &Exchange{}); modified {
+		t.Errorf("expected no match without vars, got %v", other)
+	}
+}
+
+func TestProcessActionsHeaderCapturesStayOnOneLine(t *testing.T) {
+	cfg := mustParseConfig(t, `
+proxy:
+  listen: "localhost:8081"
+  target: "http://localhost:8080"
+  routes:
+    - methods: POST
+      paths: /v1/chat
+      on_request:
+        - match_body:
+            prompt: '(?s)^(?P<prompt>.*)$'
+          set_headers:
+            X-Prompt: "${prompt}"
+            X-Templated: "{{ $vars.prompt }}"
+`)
+
+	body := map[string]any{"prompt": " hello\r\nX-Injected: yes\n"}
+	ex := Exchange{Vars: map[string]any{}}
+	ProcessRequest(body, map[string]string{}, cfg.Proxies[0].Routes[0].Compiled, 0, "POST", "/v1/chat", &ex)
+
+	want := []HeaderEdit{
+		{Op: HeaderSet, Name: "X-Prompt", Value: "hello X-Injected: yes"},
+		{Op: HeaderSet, Name: "X-Templated", Value: "hello X-Injected: yes"},
+	}
+	if !reflect.DeepEqual(ex.HeaderEdits, want) {
+		t.Fatalf("HeaderEdits = %+v, want %+v", ex.HeaderEdits, want)
+	}
+}
+
+func TestProcessActionsRegexGroups(t *testing.T) {
+	cfg := mustParseConfig(t, `
+proxy:
+  listen: "localhost:8081"
+  target: "http://localhost:8080"
+  routes:
+    - methods: POST
+      paths: ^/models/(?P<model>[^/]+)/chat$
+      on_request:
+        - match_body:
+            messages[0].content: '^/(\w+) (?P<rest>.*)$'
+          merge:
+            model: "${model}"
+            command: "${1}"
+            messages[0].content: "${rest}"
+            tags: ["${model}", "${unknown}"]
+          set_headers:
+            X-Model: "${model}-${1}"
+`)
+	route := &cfg.Proxies[0].Routes[0]
+
+	request := map[string]any{"messages": []any{map[string]any{"content": "/think why?"}}}
+	ex := Exchange{Vars: map[string]any{}}
+	route.CaptureGroups("/models/qwen3-8b/chat", request, &ex, 0)
+	if ex.Vars["model"] != "qwen3-8b" || ex.Vars["1"] != "qwen3-8b" {
+		t.Fatalf("path groups not captured: %v", ex.Vars)
+	}
+
+	headers := map[string]string{}
+	ProcessRequest(request, headers, route.Compiled, 0, "POST", "/models/qwen3-8b/chat", &ex)
+	want := map[string]any{
+		"model":    "qwen3-8b",
+		"command":  "think",
+		"messages": []any{map[string]any{"content": "why?"}},
+		"tags":     []any{"qwen3-8b", "${unknown}"},
+	}
+	if !reflect.DeepEqual(request, want) {
+		t.Errorf("request = %v, want %v", request, want)
+	}
+	if headers["X-Model"] != "qwen3-8b-think" {
+		t.Errorf("X-Model = %q, want qwen3-8b-think", headers["X-Model"])
+	}
+
+	// The configured values are left intact for the next request
+	if got := route.Compiled.OnRequest[0].Merge["model"]; got != "${model}" {
+		t.Errorf("merge value mutated: %v", got)
+	}
+}
+
+func TestProcessActionsMatchConditions(t *testing.T) {
+	cfg := mustParseConfig(t, `
+proxy:
+  listen: "localhost:8081"
+  target: "http://localhost:8080"
+  routes:
+    - methods: POST
+      paths: /v1/chat
+      on_request:
+        - match_body:
+            tools: {exists: false}
+          default:
+            tool_choice: none
+        - match_body:
+            max_tokens: {gt: 8192}
+          merge:
+            max_tokens: 8192
+        - match_body:
+            model: {not: ^qwen}
+            stream: {eq: true}
+          match_headers:
+            X-Trace: {absent: true}
+          merge:
+            stream_options: {include_usage: true}
+`)
+	compiled := cfg.Proxies[0].Routes[0].Compiled
+
+	tests := []struct {
+		name    string
+		request map[string]any
+		headers map[string]string
+		want    map[string]any
+	}{
+		{
+			name:    "no tools, large max_tokens, streamed llama",
+			request: map[string]any{"model": "llama3", "max_tokens": 32768.0, "stream": true},
+			want: map[string]any{
+				"model": "llama3", "max_tokens": 8192, "stream": true,
+				"tool_choice": "none", "stream_options": map[string]any{"include_usage": true},
+			},
+		},
+		{
+			name:    "tools present, max_tokens in range, stream as string",
+			request: map[string]any{"model": "llama3", "tools": []any{}, "max_tokens": 8192.0, "stream": "true"},
+			want:    map[string]any{"model": "llama3", "tools": []any{}, "max_tokens": 8192.0, "stream": "true"},
+		},
+		{
+			name:    "traced requests skip the header-gated action",
+			request: map[string]any{"model": "llama3", "tools": []any{}, "stream": true},
+			headers: map[string]string{"X-Trace": "1"},
+			want:    map[string]any{"model": "llama3", "tools": []any{}, "stream": true},
+		},
+		{
+			name:    "qwen skips the negated action",
+			request: map[string]any{"model": "qwen3", "tools": []any{}, "stream": true},
+			want:    map[string]any{"model": "qwen3", "tools": []any{}, "stream": true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := tt.headers
+			if headers == nil {
+				headers = map[string]string{}
+			}
+			ProcessRequest(tt.request, headers, compiled, 0, "POST", "/v1/chat", nil)
+			if !reflect.DeepEqual(tt.request, tt.want) {
+				t.Errorf("request = %v, want %v", tt.request, tt.want)
+			}
+		})
+	}
+}
+
+func TestPatternFieldCaptures(t *testing.T) {
+	p := PatternField{Patterns: []string{`^/v1/(\w+)$`, `^/models/(?P<model>[^/]+)(/chat)?$`}}
+	if err := p.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		input string
+		want  map[string]string
+	}{
+		{"/v1/models", map[string]string{"1": "models"}},
+		{"/models/llama3", map[string]string{"1": "llama3", "model": "llama3"}},
+		{"/models/llama3/chat", map[string]string{"1": "llama3", "model": "llama3", "2": "/chat"}},
+		{"/other", nil},
+	}
+	for _, tt := range tests {
+		if got := p.Captures(tt.input); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Captures(%q) = %v, want %v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestProcessResponseTemplateContext(t *testing.T) {
+	cfg := mustParseConfig(t, `
+proxy:
+  listen: "localhost:8081"
+  target: "http://localhost:8080"
+  routes:
+    - methods: POST
+      paths: /v1/chat
+      on_response:
+        - template: |
+            {
+              "model": "{{ .model }}",
+              "same_model": {{ eq .body.model .request.body.model }},
+              "stream": {{ .request.body.stream }},
+              "turns": {{ len .request.body.messages }},
+              "client": "{{ index .request.headers "User-Agent" }}",
+              "call": "{{ .request.method }} {{ .request.path }}",
+              "status": {{ .status }}
+            }
+`)
+	compiled := cfg.Proxies[0].Routes[0].Compiled
+
+	ex := Exchange{
+		Request: &RequestInfo{
+			Body:    map[string]any{"model": "qwen", "stream": false, "messages": []any{"a", "b"}},
+			Headers: map[string]string{"User-Agent": "curl"},
+			Method:  "POST",
+			Path:    "/v1/chat",
+		},
+		Status: 200,
+	}
+	body := map[string]any{"model": "qwen"}
+	ProcessResponse(body, map[string]string{}, compiled, 0, "POST", "/v1/chat", &ex)
+
+	want := map[string]any{
+		"model":      "qwen",
+		"same_model": true,
+		"stream":     false,
+		"turns":      2.0,
+		"client":     "curl",
+		"call":       "POST /v1/chat",
+		"status":     200.0,
+	}
+	if !reflect.DeepEqual(body, want) {
+		t.Errorf("body = %v, want %v", body, want)
+	}
+
+	// Body fields keep their names when they collide with the structured context
+	if ctx := templateContext(map[string]any{"status": "pulling"}, &ex); ctx["status"] != "pulling" || ctx["request"] == nil {
+		t.Errorf("unexpected context for colliding body: %v", ctx)
+	}
+	// Request templates see the bare body
+	if ctx := templateContext(map[string]any{"model": "qwen"}, &Exchange{}); len(ctx) != 1 {
+		t.Errorf("request context gained fields: %v", ctx)
+	}
+}
@@ -0,0 +1,339 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PathSegment is a single step in a body path: a map key, an array index, or a wildcard
+type PathSegment struct {
+	Key      string
+	Index    int
+	IsIndex  bool
+	Wildcard bool
+}
+
+// Path addresses nested values in a JSON body (ex: messages[-1].content, tools[*].function.name)
+type Path []PathSegment
+
+// ParsePath parses dot/bracket path syntax
+// Keys are separated by dots; brackets hold an index ([0], [-1] from the end),
+// a wildcard ([*]) or a quoted key (["key.with.dots"]). A bare * segment is also a wildcard.
+func ParsePath(raw string) (Path, error) {
+	if raw == "" {
+		return nil, fmt.Errorf("path is empty")
+	}
+
+	var path Path
+	i := 0
+	expectKey := true
+
+	for i < len(raw) {
+		switch raw[i] {
+		case '.':
+			if expectKey {
+				return nil, fmt.Errorf("path '%s': empty key at offset %d", raw, i)
+			}
+			expectKey = true
+			i++
+			if i == len(raw) {
+				return nil, fmt.Errorf("path '%s': trailing dot", raw)
+			}
+		case '[':
+			end := strings.IndexByte(raw[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("path '%s': unclosed bracket at offset %d", raw, i)
+			}
+			segment, err := parseBracket(raw[i+1 : i+end])
+			if err != nil {
+				return nil, fmt.Errorf("path '%s': %w", raw, err)
+			}
+			path = append(path, segment)
+			expectKey = false
+			i += end + 1
+		default:
+			if !expectKey {
+				return nil, fmt.Errorf("path '%s': expected '.' or '[' at offset %d", raw, i)
+			}
+			end := i
+			for end < len(raw) && raw[end] != '.' && raw[end] != '[' {
+				if raw[end] == ']' {
+					return nil, fmt.Errorf("path '%s': unexpected ']' at offset %d", raw, end)
+				}
+				end++
+			}
+			key := raw[i:end]
+			if key == "*" {
+				path = append(path, PathSegment{Wildcard: true})
+			} else {
+				path = append(path, PathSegment{Key: key})
+			}
+			expectKey = false
+			i = end
+		}
+	}
+
+	return path, nil
+}
+
+func parseBracket(inner string) (PathSegment, error) {
+	inner = strings.TrimSpace(inner)
+	switch {
+	case inner == "":
+		return PathSegment{}, fmt.Errorf("empty brackets")
+	case inner == "*":
+		return PathSegment{Wildcard: true}, nil
+	case len(inner) >= 2 && (inner[0] == '"' || inner[0] == '\'') && inner[len(inner)-1] == inner[0]:
+		return PathSegment{Key: inner[1 : len(inner)-1]}, nil
+	}
+
+	index, err := strconv.Atoi(inner)
+	if err != nil {
+		return PathSegment{}, fmt.Errorf("invalid index '%s'", inner)
+	}
+	return PathSegment{Index: index, IsIndex: true}, nil
+}
+
+// String renders the path back to its canonical syntax
+func (p Path) String() string {
+	var b strings.Builder
+	for i, seg := range p {
+		switch {
+		case seg.Wildcard:
+			b.WriteString("[*]")
+		case seg.IsIndex:
+			fmt.Fprintf(&b, "[%d]", seg.Index)
+		case strings.ContainsAny(seg.Key, ".[]") || seg.Key == "*" || seg.Key == "":
+			fmt.Fprintf(&b, "[%q]", seg.Key)
+		default:
+			if i > 0 {
+				b.WriteByte('.')
+			}
+			b.WriteString(seg.Key)
+		}
+	}
+	return b.String()
+}
+
+// hasWildcard reports whether the path can address more than one value
+func (p Path) hasWildcard() bool {
+	for _, seg := range p {
+		if seg.Wildcard {
+			return true
+		}
+	}
+	return false
+}
+
+// Lookup returns every value addressed by the path
+// Missing keys, out-of-range indices and type mismatches yield no values rather than an error.
+// Wildcards expand to all array elements or all map values (in key order).
+func (p Path) Lookup(data any) []any {
+	current := []any{data}
+	for _, seg := range p {
+		var next []any
+		for _, node := range current {
+			next = append(next, seg.children(node)...)
+		}
+		if len(next) == 0 {
+			return nil
+		}
+		current = next
+	}
+	return current
+}
+
+// children returns the values this segment selects from node
+func (seg PathSegment) children(node any) []any {
+	switch v := node.(type) {
+	case map[string]any:
+		if seg.Wildcard {
+			keys := sortedKeys(v)
+			values := make([]any, 0, len(keys))
+			for _, k := range keys {
+				values = append(values, v[k])
+			}
+			return values
+		}
+		if seg.IsIndex {
+			return nil
+		}
+		if val, ok := v[seg.Key]; ok {
+			return []any{val}
+		}
+	case []any:
+		if seg.Wildcard {
+			return append([]any(nil), v...)
+		}
+		if !seg.IsIndex {
+			return nil
+		}
+		if i, ok := resolveIndex(seg.Index, len(v)); ok {
+			return []any{v[i]}
+		}
+	}
+	return nil
+}
+
+// resolveIndex maps a possibly negative index onto [0, length)
+func resolveIndex(index, length int) (int, bool) {
+	if index < 0 {
+		index += length
+	}
+	if index < 0 || index >= length {
+		return 0, false
+	}
+	return index, true
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// pathWrite decides the new value for a leaf given its current value
+// It returns the value to store, whether the leaf should be kept, and whether anything changed.
+type pathWrite func(current any, exists bool) (value any, keep bool, changed bool)
+
+// pathChange records a concrete location touched by a path write
+type pathChange struct {
+	Path    string
+	Value   any
+	Existed bool
+	Deleted bool
+}
+
+// Set assigns value everywhere the path addresses, creating intermediate maps for missing keys
+// Returns the concrete paths written (wildcards and negative indices resolved).
+// Each write stores its own copy of value so later writes never alias config data.
+func (p Path) Set(data map[string]any, value any) []pathChange {
+	return p.write(data, true, func(any, bool) (any, bool, bool) {
+		return copyValue(value), true, true
+	})
+}
+
+// SetDefault assigns value only where the addressed leaf is missing
+func (p Path) SetDefault(data map[string]any, value any) []pathChange {
+	return p.write(data, true, func(current any, exists bool) (any, bool, bool) {
+		if exists {
+			return current, true, false
+		}
+		return copyValue(value), true, true
+	})
+}
+
+// Delete removes every addressed map key or array element
+func (p Path) Delete(data map[string]any) []pathChange {
+	return p.write(data, false, func(current any, exists bool) (any, bool, bool) {
+		return nil, false, exists
+	})
+}
+
+func (p Path) write(data map[string]any, create bool, fn pathWrite) []pathChange {
+	if len(p) == 0 || data == nil {
+		return nil
+	}
+	var changes []pathChange
+	writePath(data, p, nil, create, fn, &changes)
+	return changes
+}
+
+// writePath applies fn at the leaves of segs below node and returns the (possibly replaced) node
+// Slices are returned rather than mutated so element deletion can shrink them.
+func writePath(node any, segs Path, prefix Path, create bool, fn pathWrite, changes *[]pathChange) any {
+	seg := segs[0]
+	last := len(segs) == 1
+
+	switch v := node.(type) {
+	case map[string]any:
+		if seg.IsIndex {
+			return node
+		}
+		keys := []string{seg.Key}
+		if seg.Wildcard {
+			keys = sortedKeys(v)
+		}
+		for _, key := range keys {
+			child, exists := v[key]
+			childPath := append(prefix[:len(prefix):len(prefix)], PathSegment{Key: key})
+
+			if last {
+				value, keep, changed := fn(child, exists)
+				if !changed {
+					continue
+				}
+				if keep {
+					v[key] = value
+				} else {
+					delete(v, key)
+				}
+				*changes = append(*changes, pathChange{Path: childPath.String(), Value: value, Existed: exists, Deleted: !keep})
+				continue
+			}
+
+			if !exists {
+				// Only plain keys can be created; indices need an existing array
+				if !create || seg.Wildcard || segs[1].IsIndex {
+					continue
+				}
+				created := map[string]any{}
+				before := len(*changes)
+				writePath(created, segs[1:], childPath, create, fn, changes)
+				if len(*changes) > before {
+					v[key] = created
+				}
+				continue
+			}
+			v[key] = writePath(child, segs[1:], childPath, create, fn, changes)
+		}
+	case []any:
+		if !seg.IsIndex && !seg.Wildcard {
+			return node
+		}
+		var indices []int
+		if seg.Wildcard {
+			for i := range v {
+				indices = append(indices, i)
+			}
+		} else if i, ok := resolveIndex(seg.Index, len(v)); ok {
+			indices = []int{i}
+		}
+
+		removed := make(map[int]bool)
+		for _, i := range indices {
+			childPath := append(prefix[:len(prefix):len(prefix)], PathSegment{Index: i, IsIndex: true})
+
+			if last {
+				value, keep, changed := fn(v[i], true)
+				if !changed {
+					continue
+				}
+				if keep {
+					v[i] = value
+				} else {
+					removed[i] = true
+				}
+				*changes = append(*changes, pathChange{Path: childPath.String(), Value: value, Existed: true, Deleted: !keep})
+				continue
+			}
+			v[i] = writePath(v[i], segs[1:], childPath, create, fn, changes)
+		}
+
+		if len(removed) > 0 {
+			kept := make([]any, 0, len(v)-len(removed))
+			for i, item := range v {
+				if !removed[i] {
+					kept = append(kept, item)
+				}
+			}
+			return kept
+		}
+	}
+	return node
+}
@@ -0,0 +1,185 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParsePath(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    Path
+		wantErr string
+	}{
+		{
+			name: "single key",
+			raw:  "model",
+			want: Path{{Key: "model"}},
+		},
+		{
+			name: "nested keys",
+			raw:  "options.num_ctx",
+			want: Path{{Key: "options"}, {Key: "num_ctx"}},
+		},
+		{
+			name: "negative index",
+			raw:  "messages[-1].content",
+			want: Path{{Key: "messages"}, {Index: -1, IsIndex: true}, {Key: "content"}},
+		},
+		{
+			name: "bracket wildcard",
+			raw:  "tools[*].function.name",
+			want: Path{{Key: "tools"}, {Wildcard: true}, {Key: "function"}, {Key: "name"}},
+		},
+		{
+			name: "dot wildcard",
+			raw:  "options.*",
+			want: Path{{Key: "options"}, {Wildcard: true}},
+		},
+		{
+			name: "quoted key",
+			raw:  `meta["x.y"]`,
+			want: Path{{Key: "meta"}, {Key: "x.y"}},
+		},
+		{name: "empty", raw: "", wantErr: "empty"},
+		{name: "leading dot", raw: ".model", wantErr: "empty key"},
+		{name: "double dot", raw: "a..b", wantErr: "empty key"},
+		{name: "trailing dot", raw: "a.", wantErr: "trailing dot"},
+		{name: "unclosed bracket", raw: "messages[0", wantErr: "unclosed bracket"},
+		{name: "bad index", raw: "messages[first]", wantErr: "invalid index"},
+		{name: "missing separator", raw: "messages[0]role", wantErr: "expected '.'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParsePath(tt.raw)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ParsePath(%q) error = %v, want error containing %q", tt.raw, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParsePath(%q) unexpected error: %v", tt.raw, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("ParsePath(%q) = %+v, want %+v", tt.raw, got, tt.want)
+			}
+			if reparsed, err := ParsePath(got.String()); err != nil || !reflect.DeepEqual(reparsed, got) {
+				t.Fatalf("String() round trip failed: %q -> %+v (%v)", got.String(), reparsed, err)
+			}
+		})
+	}
+}
+
+func TestPathLookup(t *testing.T) {
+	body := map[string]any{
+		"model": "qwen3",
+		"messages": []any{
+			map[string]any{"role": "system", "content": "be brief"},
+			map[string]any{"role": "user", "content": "hi"},
+		},
+		"tools": []any{
+			map[string]any{"function": map[string]any{"name": "search"}},
+			map[string]any{"function": map[string]any{"name": "fetch"}},
+		},
+		"options": map[string]any{"num_ctx": 8192.0},
+	}
+
+	tests := []struct {
+		path string
+		want []any
+	}{
+		{"model", []any{"qwen3"}},
+		{"messages[0].role", []any{"system"}},
+		{"messages[-1].content", []any{"hi"}},
+		{"messages[5].content", nil},
+		{"tools[*].function.name", []any{"search", "fetch"}},
+		{"options.num_ctx", []any{8192.0}},
+		{"options.missing", nil},
+		{"model.nested", nil},
+		{"options[0]", nil},
+		{"response_format.type", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			path, err := ParsePath(tt.path)
+			if err != nil {
+				t.Fatalf("ParsePath(%q): %v", tt.path, err)
+			}
+			if got := path.Lookup(body); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Lookup(%q) = %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPathSetDefaultDelete(t *testing.T) {
+	body := map[string]any{
+		"messages": []any{
+			map[string]any{"role": "system", "name": "a"},
+			map[string]any{"role": "user", "name": "b"},
+		},
+		"options":        map[string]any{"num_predict": 128.0},
+		"stream_options": map[string]any{"include_usage": true},
+	}
+
+	changes := mustParsePath(t, "options.temperature").Set(body, 0.7)
+	if len(changes) != 1 || changes[0].Path != "options.temperature" || changes[0].Existed {
+		t.Fatalf("unexpected set changes: %+v", changes)
+	}
+	if body["options"].(map[string]any)["num_predict"] != 128.0 {
+		t.Fatalf("set should preserve sibling keys, got %v", body["options"])
+	}
+
+	// Intermediate maps are created for missing keys
+	mustParsePath(t, "chat_template_kwargs.enable_thinking").Set(body, false)
+	if got := body["chat_template_kwargs"].(map[string]any)["enable_thinking"]; got != false {
+		t.Fatalf("expected created intermediate map, got %v", body["chat_template_kwargs"])
+	}
+
+	// Defaults leave existing leaves alone
+	if changes := mustParsePath(t, "options.num_predict").SetDefault(body, 1.0); len(changes) != 0 {
+		t.Fatalf("default should not overwrite existing value, got %+v", changes)
+	}
+
+	// Wildcards expand to concrete paths
+	changes = mustParsePath(t, "messages[*].name").Delete(body)
+	if len(changes) != 2 || changes[0].Path != "messages[0].name" || changes[1].Path != "messages[1].name" {
+		t.Fatalf("unexpected wildcard delete changes: %+v", changes)
+	}
+	for _, msg := range body["messages"].([]any) {
+		if _, exists := msg.(map[string]any)["name"]; exists {
+			t.Fatalf("expected name deleted from %v", msg)
+		}
+	}
+
+	// Deleting array elements shrinks the slice; negative indices resolve
+	changes = mustParsePath(t, "messages[-1]").Delete(body)
+	if len(changes) != 1 || changes[0].Path != "messages[1]" {
+		t.Fatalf("unexpected element delete changes: %+v", changes)
+	}
+	if len(body["messages"].([]any)) != 1 {
+		t.Fatalf("expected one message left, got %v", body["messages"])
+	}
+
+	// Indices never create arrays, and missing paths are no-ops
+	if changes := mustParsePath(t, "missing[0].x").Set(body, 1); len(changes) != 0 {
+		t.Fatalf("expected no changes for missing array, got %+v", changes)
+	}
+	if changes := mustParsePath(t, "stream_options.missing").Delete(body); len(changes) != 0 {
+		t.Fatalf("expected no changes deleting missing key, got %+v", changes)
+	}
+}
+
+func mustParsePath(t *testing.T, raw string) Path {
+	t.Helper()
+	path, err := ParsePath(raw)
+	if err != nil {
+		t.Fatalf("ParsePath(%q): %v", raw, err)
+	}
+	return path
+}
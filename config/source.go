@@ -0,0 +1,217 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Position is a place in a config file
+type Position struct {
+	File   string
+	Line   int
+	Column int
+}
+
+func (p Position) String() string {
+	if p.File == "" {
+		return fmt.Sprintf("line %d:%d", p.Line, p.Column)
+	}
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
+}
+
+// Source records where a proxy, route or action was defined, after includes are expanded
+// Keys holds the position of each key in its mapping, and of the keys one level below
+// (ex: "match_body" and "match_body.model").
+type Source struct {
+	Position
+	Keys map[string]Position
+}
+
+// At returns the position of key, falling back to its parent key and then the item itself
+// key may be empty, a key ("target") or a key and a child ("match_body.model").
+func (s *Source) At(key string) Position {
+	if s == nil {
+		return Position{}
+	}
+	if pos, ok := s.Keys[key]; ok {
+		return pos
+	}
+	if parent, _, nested := strings.Cut(key, "."); nested {
+		if pos, ok := s.Keys[parent]; ok {
+			return pos
+		}
+	}
+	return s.Position
+}
+
+// errorf formats an error prefixed with the position of key
+// Items built in code have no source, so their errors read as before.
+func (s *Source) errorf(key, format string, args ...any) error {
+	err := fmt.Errorf(format, args...)
+	if pos := s.At(key); pos.Line > 0 {
+		return fmt.Errorf("%s: %w", pos, err)
+	}
+	return err
+}
+
+// markSource records file as the origin of node and everything below it
+func (s *loadState) markSource(node *yaml.Node, file string) {
+	s.sources[node] = file
+	for _, child := range node.Content {
+		s.markSource(child, file)
+	}
+}
+
+func (s *loadState) position(node *yaml.Node) Position {
+	return Position{File: s.sources[node], Line: node.Line, Column: node.Column}
+}
+
+var (
+	sourceType       = reflect.TypeOf((*Source)(nil))
+	proxyEntriesType = reflect.TypeOf(ProxyEntries{})
+	patternFieldType = reflect.TypeOf(PatternField{})
+	statusFieldType  = reflect.TypeOf(StatusField{})
+)
+
+// annotate walks the decoded config alongside its YAML, filling Source fields and
+// reporting keys that no field takes (typos like on_requst are otherwise ignored)
+func (s *loadState) annotate(node *yaml.Node, v reflect.Value) []error {
+	for node.Kind == yaml.AliasNode && node.Alias != nil {
+		node = node.Alias
+	}
+	if node.Kind == yaml.DocumentNode {
+		if len(node.Content) == 0 {
+			return nil
+		}
+		return s.annotate(node.Content[0], v)
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			return nil
+		}
+		return s.annotate(node, v.Elem())
+	case reflect.Slice:
+		if node.Kind == yaml.MappingNode && v.Type() == proxyEntriesType && v.Len() == 1 {
+			// A single proxy may be written as a map
+			return s.annotate(node, v.Index(0))
+		}
+		if node.Kind != yaml.SequenceNode {
+			return nil
+		}
+		var errs []error
+		for i, item := range node.Content {
+			if i < v.Len() {
+				errs = append(errs, s.annotate(item, v.Index(i))...)
+			}
+		}
+		return errs
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode || v.Type() == patternFieldType || v.Type() == statusFieldType {
+			return nil
+		}
+		return s.annotateStruct(node, v)
+	}
+	return nil
+}
+
+func (s *loadState) annotateStruct(node *yaml.Node, v reflect.Value) []error {
+	fields := yamlFields(v.Type())
+	source := &Source{Position: s.position(node), Keys: make(map[string]Position)}
+
+	var errs []error
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		keyNode, valNode := node.Content[i], node.Content[i+1]
+		key := keyNode.Value
+		source.Keys[key] = s.position(keyNode)
+
+		// Merge keys pull in anchored maps, which are checked where they are defined
+		if key == "<<" {
+			continue
+		}
+		index, ok := fields[key]
+		if !ok {
+			msg := fmt.Sprintf("%s: unknown key '%s'", s.position(keyNode), key)
+			if suggestion := closestKey(key, fields); suggestion != "" {
+				msg += fmt.Sprintf(" (did you mean '%s'?)", suggestion)
+			}
+			errs = append(errs, fmt.Errorf("%s", msg))
+			continue
+		}
+
+		if valNode.Kind == yaml.MappingNode {
+			for j := 0; j+1 < len(valNode.Content); j += 2 {
+				source.Keys[key+"."+valNode.Content[j].Value] = s.position(valNode.Content[j])
+			}
+		}
+		errs = append(errs, s.annotate(valNode, v.Field(index))...)
+	}
+
+	if f := v.FieldByName("Source"); f.IsValid() && f.Type() == sourceType && f.CanSet() {
+		f.Set(reflect.ValueOf(source))
+	}
+	return errs
+}
+
+// yamlFields maps each YAML key of a struct to its field index
+func yamlFields(t reflect.Type) map[string]int {
+	fields := make(map[string]int)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		switch name {
+		case "-":
+			continue
+		case "":
+			name = strings.ToLower(f.Name)
+		}
+		fields[name] = i
+	}
+	return fields
+}
+
+// closestKey suggests a known key within two edits of key, or ""
+func closestKey(key string, fields map[string]int) string {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	best, bestDistance := "", 3
+	for _, name := range names {
+		if d := editDistance(key, name); d < bestDistance {
+			best, bestDistance = name, d
+		}
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr := make([]int, len(b)+1)
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev = curr
+	}
+	return prev[len(b)]
+}
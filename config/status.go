@@ -0,0 +1,160 @@
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Error body formats for error_format
+const (
+	ErrorFormatOpenAI = "openai"
+)
+
+// StatusField can be a single status match or array of them
+// Each entry is an exact code (404), a class (5xx) or an inclusive range (500-504).
+type StatusField struct {
+	Values   []string
+	Compiled [][2]int
+}
+
+// UnmarshalYAML allows both a scalar and a list for status fields
+func (s *StatusField) UnmarshalYAML(unmarshal func(any) error) error {
+	var single string
+	if err := unmarshal(&single); err == nil {
+		s.Values = []string{single}
+		return nil
+	}
+
+	var multiple []string
+	if err := unmarshal(&multiple); err == nil {
+		s.Values = multiple
+		return nil
+	}
+
+	return fmt.Errorf("status must be a code, class or range, or a list of them")
+}
+
+// Validate parses every entry into an inclusive range of codes
+func (s *StatusField) Validate() error {
+	s.Compiled = make([][2]int, 0, len(s.Values))
+	for _, raw := range s.Values {
+		r, err := parseStatusRange(raw)
+		if err != nil {
+			return err
+		}
+		s.Compiled = append(s.Compiled, r)
+	}
+	return nil
+}
+
+func parseStatusRange(raw string) ([2]int, error) {
+	value := strings.ToLower(strings.TrimSpace(raw))
+
+	if len(value) == 3 && value[0] >= '1' && value[0] <= '5' && value[1:] == "xx" {
+		class := int(value[0]-'0') * 100
+		return [2]int{class, class + 99}, nil
+	}
+
+	lo, hi, isRange := strings.Cut(value, "-")
+	if !isRange {
+		hi = lo
+	}
+	from, errLo := strconv.Atoi(strings.TrimSpace(lo))
+	to, errHi := strconv.Atoi(strings.TrimSpace(hi))
+	if errLo != nil || errHi != nil {
+		return [2]int{}, fmt.Errorf("invalid status '%s' (expected a code like 404, a class like 5xx, or a range like 500-504)", raw)
+	}
+	if from < 100 || to > 599 || from > to {
+		return [2]int{}, fmt.Errorf("invalid status '%s': codes must be between 100 and 599", raw)
+	}
+	return [2]int{from, to}, nil
+}
+
+// Matches reports whether code falls in any entry
+func (s StatusField) Matches(code int) bool {
+	for _, r := range s.Compiled {
+		if code >= r[0] && code <= r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// Len returns the number of entries
+func (s StatusField) Len() int {
+	return len(s.Values)
+}
+
+// openAIErrorBody reshapes an upstream error into OpenAI's {"error": {...}} schema
+// The message comes from the usual fields servers use (error, error.message, message, detail),
+// then from raw for non-JSON bodies, then from the status text.
+func openAIErrorBody(data map[string]any, raw string, status int) map[string]any {
+	var nested map[string]any
+	message := ""
+	switch e := data["error"].(type) {
+	case string:
+		message = e
+	case map[string]any:
+		nested = e
+		message, _ = e["message"].(string)
+	}
+	for _, key := range []string{"message", "detail", "error_message"} {
+		if message != "" {
+			break
+		}
+		message, _ = data[key].(string)
+	}
+	if message == "" {
+		message = raw
+	}
+	if message == "" {
+		message = http.StatusText(status)
+	}
+
+	errType, _ := nested["type"].(string)
+	if errType == "" {
+		errType = openAIErrorType(status)
+	}
+
+	// OpenAI codes are strings; numeric codes (llama.cpp echoes the status) are dropped
+	var code any
+	if c, ok := nested["code"].(string); ok {
+		code = c
+	}
+
+	return map[string]any{"error": map[string]any{
+		"message": message,
+		"type":    errType,
+		"param":   nested["param"],
+		"code":    code,
+	}}
+}
+
+func openAIErrorType(status int) string {
+	switch {
+	case status == http.StatusUnauthorized:
+		return "authentication_error"
+	case status == http.StatusForbidden:
+		return "permission_error"
+	case status == http.StatusNotFound:
+		return "not_found_error"
+	case status == http.StatusTooManyRequests:
+		return "rate_limit_error"
+	case status >= 500:
+		return "server_error"
+	default:
+		return "invalid_request_error"
+	}
+}
+
+// HasStatusActions reports whether any of the actions rewrite the response status
+func HasStatusActions(actions []Action) bool {
+	for _, op := range actions {
+		if op.SetStatus != 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,83 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestStatusFieldMatches(t *testing.T) {
+	var action Action
+	if err := yaml.Unmarshal([]byte("match_status: [404, 5xx, 420-429]"), &action); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if err := action.MatchStatus.Validate(); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+
+	for code, want := range map[int]bool{404: true, 500: true, 599: true, 425: true, 200: false, 403: false, 430: false} {
+		if got := action.MatchStatus.Matches(code); got != want {
+			t.Errorf("Matches(%d) = %v, want %v", code, got, want)
+		}
+	}
+
+	var single Action
+	if err := yaml.Unmarshal([]byte("match_status: 4XX"), &single); err != nil {
+		t.Fatalf("unmarshal scalar: %v", err)
+	}
+	if err := single.MatchStatus.Validate(); err != nil || !single.MatchStatus.Matches(418) {
+		t.Errorf("expected 4XX to match 418, err=%v", err)
+	}
+
+	for _, bad := range []string{"6xx", "abc", "500-400", "99"} {
+		field := StatusField{Values: []string{bad}}
+		if err := field.Validate(); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestOpenAIErrorBody(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   map[string]any
+		raw    string
+		status int
+		want   map[string]any
+	}{
+		{
+			name:   "ollama string error",
+			data:   map[string]any{"error": "model 'x' not found"},
+			status: 404,
+			want:   map[string]any{"message": "model 'x' not found", "type": "not_found_error", "param": nil, "code": nil},
+		},
+		{
+			name:   "openai error keeps code and param",
+			data:   map[string]any{"error": map[string]any{"message": "bad", "type": "invalid_request_error", "param": "model", "code": "model_not_found"}},
+			status: 400,
+			want:   map[string]any{"message": "bad", "type": "invalid_request_error", "param": "model", "code": "model_not_found"},
+		},
+		{
+			name:   "fastapi detail",
+			data:   map[string]any{"detail": "Unauthorized"},
+			status: 401,
+			want:   map[string]any{"message": "Unauthorized", "type": "authentication_error", "param": nil, "code": nil},
+		},
+		{
+			name:   "empty body falls back to status text",
+			data:   map[string]any{},
+			status: 502,
+			want:   map[string]any{"message": "Bad Gateway", "type": "server_error", "param": nil, "code": nil},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := openAIErrorBody(tt.data, tt.raw, tt.status)
+			if !reflect.DeepEqual(got, map[string]any{"error": tt.want}) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
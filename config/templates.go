@@ -1,34 +1,41 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"regexp"
+	"strconv"
 	"text/template"
 
 	"github.com/spicyneuron/llama-matchmaker/logger"
 )
 
 // CompileTemplates compiles all template strings in routes
+// Every failing template is reported, with its position when the config was loaded from YAML.
 func CompileTemplates(cfg *Config) error {
+	var errs []error
 	for i := range cfg.Proxies {
 		if len(cfg.Proxies[i].Routes) == 0 {
 			continue
 		}
 		if err := compileRouteTemplates(cfg.Proxies[i].Routes, fmt.Sprintf("proxy_%d", i)); err != nil {
-			return err
+			errs = append(errs, err)
 		}
 	}
 
-	return nil
+	return errors.Join(errs...)
 }
 
 func compileRouteTemplates(routes []Route, prefix string) error {
+	var errs []error
 	for i := range routes {
 		route := &routes[i]
 
 		// Convert config operations to execution types
 		compiled := &CompiledRoute{
-			OnRequest:  make([]ActionExec, len(route.OnRequest)),
-			OnResponse: make([]ActionExec, len(route.OnResponse)),
+			OnRequest:   make([]ActionExec, len(route.OnRequest)),
+			OnResponse:  make([]ActionExec, len(route.OnResponse)),
+			OnStreamEnd: make([]ActionExec, len(route.OnStreamEnd)),
 		}
 
 		// Convert OnRequest operations
@@ -36,13 +43,12 @@ func compileRouteTemplates(routes []Route, prefix string) error {
 			compiled.OnRequest[j] = convertAction(op)
 
 			if op.Template != "" {
-				tmpl, err := template.New(fmt.Sprintf("%s_rule_%d_request_%d", prefix, i, j)).
-					Funcs(TemplateFuncs).
-					Parse(op.Template)
+				tmpl, err := parseTemplate(fmt.Sprintf("%s_rule_%d_request_%d", prefix, i, j), op.Template)
 				if err != nil {
-					return fmt.Errorf("rule %d request operation %d: %w", i, j, err)
+					errs = append(errs, op.Source.errorf("template", "rule %d request operation %d: %w", i, j, err))
+				} else {
+					logger.Debug("Compiled request template", "scope", prefix, "rule_index", i, "operation_index", j)
 				}
-				logger.Debug("Compiled request template", "scope", prefix, "rule_index", i, "operation_index", j)
 				compiled.OnRequestTemplates = append(compiled.OnRequestTemplates, tmpl)
 			} else {
 				compiled.OnRequestTemplates = append(compiled.OnRequestTemplates, nil)
@@ -54,24 +60,103 @@ func compileRouteTemplates(routes []Route, prefix string) error {
 			compiled.OnResponse[j] = convertAction(op)
 
 			if op.Template != "" {
-				tmpl, err := template.New(fmt.Sprintf("%s_rule_%d_response_%d", prefix, i, j)).
-					Funcs(TemplateFuncs).
-					Parse(op.Template)
+				tmpl, err := parseTemplate(fmt.Sprintf("%s_rule_%d_response_%d", prefix, i, j), op.Template)
 				if err != nil {
-					return fmt.Errorf("rule %d response operation %d: %w", i, j, err)
+					errs = append(errs, op.Source.errorf("template", "rule %d response operation %d: %w", i, j, err))
+				} else {
+					logger.Debug("Compiled response template", "scope", prefix, "rule_index", i, "operation_index", j)
 				}
-				logger.Debug("Compiled response template", "scope", prefix, "rule_index", i, "operation_index", j)
 				compiled.OnResponseTemplates = append(compiled.OnResponseTemplates, tmpl)
 			} else {
 				compiled.OnResponseTemplates = append(compiled.OnResponseTemplates, nil)
 			}
 		}
 
+		// Convert OnStreamEnd operations
+		for j, op := range route.OnStreamEnd {
+			compiled.OnStreamEnd[j] = convertAction(op)
+
+			if op.Template != "" {
+				tmpl, err := parseTemplate(fmt.Sprintf("%s_rule_%d_stream_end_%d", prefix, i, j), op.Template)
+				if err != nil {
+					errs = append(errs, op.Source.errorf("template", "rule %d stream end operation %d: %w", i, j, err))
+				} else {
+					logger.Debug("Compiled stream end template", "scope", prefix, "rule_index", i, "operation_index", j)
+				}
+				compiled.OnStreamEndTemplates = append(compiled.OnStreamEndTemplates, tmpl)
+			} else {
+				compiled.OnStreamEndTemplates = append(compiled.OnStreamEndTemplates, nil)
+			}
+		}
+
+		// Header values may carry their own templates
+		compiled.HeaderTemplates = make(map[string]*template.Template)
+		if err := compileHeaderTemplates(route.OnRequest, compiled.HeaderTemplates, fmt.Sprintf("%s_rule_%d_request", prefix, i), fmt.Sprintf("rule %d request", i)); err != nil {
+			errs = append(errs, err)
+		}
+		if err := compileHeaderTemplates(route.OnResponse, compiled.HeaderTemplates, fmt.Sprintf("%s_rule_%d_response", prefix, i), fmt.Sprintf("rule %d response", i)); err != nil {
+			errs = append(errs, err)
+		}
+
 		route.Compiled = compiled
 	}
-	return nil
+	return errors.Join(errs...)
+}
+
+// varsPrelude exposes captured variables to every template as $vars
+// It renders nothing and stays on the first line, so errors keep their line numbers;
+// templateError shifts first-line columns back to the user's text.
+const varsPrelude = "{{ $vars := vars }}"
+
+// firstLineColumn finds a line 1 column in a template error, ex: "template: name:1:34: executing"
+var firstLineColumn = regexp.MustCompile(`(template: [^:\s]+:1:)(\d+):`)
+
+// parseTemplate compiles an action or header template with the shared helpers
+func parseTemplate(name, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Funcs(TemplateFuncs).Parse(varsPrelude + text)
+	if err != nil {
+		return nil, templateError(err)
+	}
+	return tmpl, nil
 }
 
+// templateError reports a parse or execution error at its position in the user's template
+func templateError(err error) error {
+	if err == nil || !firstLineColumn.MatchString(err.Error()) {
+		return err
+	}
+	msg := firstLineColumn.ReplaceAllStringFunc(err.Error(), func(match string) string {
+		m := firstLineColumn.FindStringSubmatch(match)
+		col, _ := strconv.Atoi(m[2])
+		return m[1] + strconv.Itoa(max(col-len(varsPrelude), 1)) + ":"
+	})
+	return errors.New(msg)
+}
+
+// convertAction copies an action into its execution form and parses its body edit paths
 func convertAction(op Action) ActionExec {
-	return ActionExec(op)
+	exec := ActionExec{
+		MatchBody:     op.MatchBody,
+		MatchHeaders:  op.MatchHeaders,
+		MatchTarget:   op.MatchTarget,
+		MatchStatus:   op.MatchStatus,
+		Template:      op.Template,
+		Merge:         op.Merge,
+		MergeDeep:     op.MergeDeep,
+		Default:       op.Default,
+		Delete:        op.Delete,
+		Stop:          op.Stop,
+		SetHeaders:    op.SetHeaders,
+		AddHeaders:    op.AddHeaders,
+		RemoveHeaders: op.RemoveHeaders,
+		Target:        op.Target,
+		ArrayMerge:    op.ArrayMerge,
+		ArrayMergeKey: op.ArrayMergeKey,
+		Capture:       op.Capture,
+		SetStatus:     op.SetStatus,
+		ErrorFormat:   op.ErrorFormat,
+		Source:        op.Source,
+	}
+	exec.compilePaths()
+	return exec
 }
@@ -0,0 +1,163 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// TraceStep describes one route or action considered while handling an exchange
+// Steps are only built when Exchange.Trace is set, so the proxy pays nothing for them.
+type TraceStep struct {
+	// Phase is route, request, response or stream_end (route steps come from the proxy)
+	Phase  string
+	Route  int
+	Action int
+
+	// Matched reports whether the step applied; Reason says why or why not
+	Matched bool
+	Reason  string
+
+	// Stop is set when a matched action ended its route's remaining actions
+	Stop bool
+
+	// Body is a copy of the body after a matched step (nil when skipped)
+	Body map[string]any
+}
+
+// trace reports a step when the exchange is being traced
+func (ex *Exchange) trace(step TraceStep) {
+	if ex != nil && ex.Trace != nil {
+		ex.Trace(step)
+	}
+}
+
+// tracing reports whether steps should be described
+func (ex *Exchange) tracing() bool {
+	return ex != nil && ex.Trace != nil
+}
+
+// actionMiss returns why an action's criteria don't match, or "" when they all do
+// Criteria are checked in the order processActions applies them.
+func actionMiss(op *ActionExec, original map[string]any, headers map[string]string, ex *Exchange) string {
+	for _, key := range sortedKeys(op.MatchBody) {
+		values := matchValues(original, key, ex)
+		if !op.MatchBody[key].MatchesValues(values) {
+			return fmt.Sprintf("match_body '%s': %s", key, describeMiss(values, op.MatchBody[key]))
+		}
+	}
+	for _, key := range sortedKeys(op.MatchHeaders) {
+		var values []any
+		if value, exists := headers[key]; exists {
+			values = []any{value}
+		}
+		if !op.MatchHeaders[key].MatchesValues(values) {
+			return fmt.Sprintf("match_headers '%s': %s", key, describeMiss(values, op.MatchHeaders[key]))
+		}
+	}
+	if op.MatchStatus.Len() > 0 {
+		if ex == nil || ex.Status == 0 {
+			return "match_status: requests have no status"
+		}
+		if !op.MatchStatus.Matches(ex.Status) {
+			return fmt.Sprintf("match_status: %d is not %s", ex.Status, strings.Join(op.MatchStatus.Values, ", "))
+		}
+	}
+	if op.MatchTarget.Len() > 0 {
+		upstream := ""
+		if ex != nil {
+			upstream = ex.Upstream
+		}
+		if !op.MatchTarget.Matches(upstream) {
+			return fmt.Sprintf("match_target: %s", describeMiss([]any{upstream}, op.MatchTarget))
+		}
+	}
+	return ""
+}
+
+// actionHit names the criteria a matched action passed
+func actionHit(op *ActionExec) string {
+	var criteria []string
+	for _, key := range sortedKeys(op.MatchBody) {
+		criteria = append(criteria, fmt.Sprintf("match_body '%s'", key))
+	}
+	for _, key := range sortedKeys(op.MatchHeaders) {
+		criteria = append(criteria, fmt.Sprintf("match_headers '%s'", key))
+	}
+	if op.MatchStatus.Len() > 0 {
+		criteria = append(criteria, "match_status")
+	}
+	if op.MatchTarget.Len() > 0 {
+		criteria = append(criteria, "match_target")
+	}
+	if len(criteria) == 0 {
+		return "no match criteria"
+	}
+	return strings.Join(criteria, ", ") + " matched"
+}
+
+// describeMiss explains why values failed a pattern field
+func describeMiss(values []any, p PatternField) string {
+	if len(values) == 0 {
+		return fmt.Sprintf("missing, want %s", p)
+	}
+	shown := make([]string, len(values))
+	for i, value := range values {
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			encoded = []byte(fmt.Sprint(value))
+		}
+		shown[i] = string(encoded)
+	}
+	return fmt.Sprintf("%s does not match %s", strings.Join(shown, ", "), p)
+}
+
+// String renders the patterns or condition as written in YAML
+func (p PatternField) String() string {
+	if p.Condition != nil {
+		return p.Condition.String()
+	}
+	if len(p.Patterns) == 1 {
+		return p.Patterns[0]
+	}
+	return "[" + strings.Join(p.Patterns, ", ") + "]"
+}
+
+// String renders the condition as a YAML flow map
+func (c *MatchCondition) String() string {
+	var parts []string
+	if c.Not != nil {
+		parts = append(parts, "not: "+c.Not.String())
+	}
+	if c.Exists != nil {
+		parts = append(parts, fmt.Sprintf("exists: %t", *c.Exists))
+	}
+	if c.Absent {
+		parts = append(parts, "absent: true")
+	}
+	if c.hasEq {
+		encoded, _ := json.Marshal(c.Eq)
+		parts = append(parts, "eq: "+string(encoded))
+	}
+	if c.Type != "" {
+		parts = append(parts, "type: "+c.Type)
+	}
+	for _, cmp := range []struct {
+		name  string
+		value *float64
+	}{{"gt", c.GT}, {"gte", c.GTE}, {"lt", c.LT}, {"lte", c.LTE}} {
+		if cmp.value != nil {
+			parts = append(parts, fmt.Sprintf("%s: %g", cmp.name, *cmp.value))
+		}
+	}
+	return "{" + strings.Join(parts, ", ") + "}"
+}
+
+// GateMiss returns why a request fails the route's match_body and match_headers gates, or ""
+func (r *Route) GateMiss(data map[string]any, headers map[string]string, ex *Exchange) string {
+	if len(r.MatchBody) > 0 && data == nil {
+		return "match_body: body is not JSON"
+	}
+	gate := ActionExec{MatchBody: r.MatchBody, MatchHeaders: r.MatchHeaders}
+	return actionMiss(&gate, data, headers, ex)
+}
@@ -1,120 +1,383 @@
 package config
 
 import (
+	"errors"
 	"fmt"
 	"net/url"
 	"strings"
 )
 
 // Validate checks the entire configuration for errors
+// Every problem is reported, each prefixed with file:line:col when the config was loaded from YAML.
 func Validate(config *Config) error {
 	if len(config.Proxies) == 0 {
 		return fmt.Errorf("proxy configuration is required")
 	}
 
+	var errs []error
 	seenListeners := make(map[string]struct{})
-	for i, proxy := range config.Proxies {
+	for i := range config.Proxies {
+		proxy := &config.Proxies[i]
+		fail := func(key, format string, args ...any) {
+			errs = append(errs, proxy.Source.errorf(key, format, args...))
+		}
+
 		if proxy.Listen == "" {
-			return fmt.Errorf("proxy[%d].listen is required", i)
+			fail("listen", "proxy[%d].listen is required", i)
+		}
+		if proxy.Target == "" && len(proxy.Targets) == 0 {
+			fail("target", "proxy[%d].target is required", i)
 		}
-		if proxy.Target == "" {
-			return fmt.Errorf("proxy[%d].target is required", i)
+		if proxy.Target != "" && len(proxy.Targets) > 0 {
+			fail("targets", "proxy[%d]: target and targets are mutually exclusive", i)
 		}
 
 		if _, err := url.Parse(proxy.Target); err != nil {
-			return fmt.Errorf("proxy[%d].target URL is invalid: %w", i, err)
+			fail("target", "proxy[%d].target URL is invalid: %w", i, err)
+		}
+		for j, target := range proxy.Targets {
+			if target.URL == "" {
+				fail("targets", "proxy[%d].targets[%d].url is required", i, j)
+				continue
+			}
+			if _, err := url.Parse(target.URL); err != nil {
+				fail("targets", "proxy[%d].targets[%d] URL is invalid: %w", i, j, err)
+			}
+			if target.Weight < 0 {
+				fail("targets", "proxy[%d].targets[%d].weight must not be negative", i, j)
+			}
+		}
+
+		switch proxy.Balance {
+		case "", BalanceRoundRobin, BalanceLeastRequests, BalanceWeighted:
+		default:
+			fail("balance", "proxy[%d].balance must be round_robin, least_requests, or weighted", i)
+		}
+		if proxy.Health.MaxFailures < 0 || proxy.Health.Cooldown < 0 {
+			fail("health", "proxy[%d].health values must not be negative", i)
+		}
+
+		if proxy.Retry.Attempts < 0 || proxy.Retry.Backoff < 0 || proxy.Retry.MaxBackoff < 0 {
+			fail("retry", "proxy[%d].retry values must not be negative", i)
+		}
+		for _, status := range proxy.Retry.OnStatus {
+			if status < 100 || status > 599 {
+				fail("retry", "proxy[%d].retry.on_status: invalid status code %d", i, status)
+			}
+		}
+		for j, fallback := range proxy.Fallbacks {
+			if err := validateTargetURL(fallback); err != nil {
+				fail("fallbacks", "proxy[%d].fallbacks[%d]: %w", i, j, err)
+			}
 		}
 
 		if (proxy.SSLCert != "" && proxy.SSLKey == "") ||
 			(proxy.SSLCert == "" && proxy.SSLKey != "") {
-			return fmt.Errorf("proxy[%d]: both ssl_cert and ssl_key must be provided together", i)
+			fail("ssl_cert", "proxy[%d]: both ssl_cert and ssl_key must be provided together", i)
 		}
 
-		if _, exists := seenListeners[proxy.Listen]; exists {
-			return fmt.Errorf("proxy listeners must be unique; %s is duplicated", proxy.Listen)
+		if proxy.Listen != "" {
+			if _, exists := seenListeners[proxy.Listen]; exists {
+				fail("listen", "proxy listeners must be unique; %s is duplicated", proxy.Listen)
+			}
+			seenListeners[proxy.Listen] = struct{}{}
 		}
-		seenListeners[proxy.Listen] = struct{}{}
 
 		if len(proxy.Routes) == 0 {
-			return fmt.Errorf("proxy[%d].routes is required", i)
+			fail("routes", "proxy[%d].routes is required", i)
 		}
 		for j := range proxy.Routes {
 			if err := validateRoute(&proxy.Routes[j], j); err != nil {
-				return err
+				errs = append(errs, err)
 			}
 		}
 	}
 
-	return nil
+	return errors.Join(errs...)
 }
 
 func validateRoute(route *Route, index int) error {
+	var errs []error
+	fail := func(key, format string, args ...any) {
+		errs = append(errs, route.Source.errorf(key, format, args...))
+	}
+
 	if route.Methods.Len() == 0 {
-		return fmt.Errorf("route %d: methods required", index)
+		fail("", "route %d: methods required", index)
 	}
 	if route.Paths.Len() == 0 {
-		return fmt.Errorf("route %d: paths required", index)
+		fail("", "route %d: paths required", index)
+	}
+
+	if len(route.OnRequest) == 0 && len(route.OnResponse) == 0 && len(route.OnStreamEnd) == 0 && route.Target == "" && route.Translate == "" && route.StreamFormat == "" && route.UpstreamStream == nil && route.TargetQuery.Len() == 0 {
+		fail("", "route %d: at least one action required (on_request, on_response, on_stream_end, target, target_query, translate, stream_format, or upstream_stream)", index)
+	}
+
+	switch route.Translate {
+	case "", TranslateOllamaToOpenAI, TranslateOpenAIToOllama:
+	default:
+		fail("translate", "route %d: translate must be %s or %s", index, TranslateOllamaToOpenAI, TranslateOpenAIToOllama)
 	}
 
-	if len(route.OnRequest) == 0 && len(route.OnResponse) == 0 {
-		return fmt.Errorf("route %d: at least one action required (on_request or on_response)", index)
+	switch route.StreamFormat {
+	case "", StreamFormatSSE, StreamFormatNDJSON, StreamFormatPassthrough:
+	default:
+		fail("stream_format", "route %d: stream_format must be sse, ndjson, or passthrough", index)
+	}
+
+	if route.Target != "" {
+		if hostHasVarRefs(route.Target) {
+			fail("target", "route %d target: ${name} references are only allowed in the path, not the scheme or host", index)
+		} else if err := validateTargetURL(withoutVarRefs(route.Target)); err != nil {
+			fail("target", "route %d target: %w", index, err)
+		}
 	}
 
 	if route.TargetPath != "" && !strings.HasPrefix(route.TargetPath, "/") {
-		return fmt.Errorf("route %d: target_path must be absolute", index)
+		fail("target_path", "route %d: target_path must be absolute", index)
 	}
 
 	if err := route.Methods.Validate(); err != nil {
-		return fmt.Errorf("route %d methods: %w", index, err)
+		fail("methods", "route %d methods: %w", index, err)
 	}
 	if err := route.Paths.Validate(); err != nil {
-		return fmt.Errorf("route %d paths: %w", index, err)
+		fail("paths", "route %d paths: %w", index, err)
+	}
+	if err := route.Hosts.Validate(); err != nil {
+		fail("hosts", "route %d hosts: %w", index, err)
+	}
+	for _, key := range sortedKeys(route.MatchBody) {
+		if strings.HasPrefix(key, "$") && !strings.HasPrefix(key, matchKeyVars) {
+			fail("match_body."+key, "route %d match_body: unknown key '%s' (expected a body path or %s<name>)", index, key, matchKeyVars)
+			continue
+		}
+		if _, err := ParsePath(strings.TrimPrefix(key, matchKeyVars)); err != nil {
+			fail("match_body."+key, "route %d match_body: %w", index, err)
+			continue
+		}
+		patterns := route.MatchBody[key]
+		if err := patterns.Validate(); err != nil {
+			fail("match_body."+key, "route %d match_body '%s': %w", index, key, err)
+		}
+		route.MatchBody[key] = patterns
+	}
+	for _, key := range sortedKeys(route.MatchHeaders) {
+		patterns := route.MatchHeaders[key]
+		if err := patterns.Validate(); err != nil {
+			fail("match_headers."+key, "route %d match_headers '%s': %w", index, key, err)
+		}
+		route.MatchHeaders[key] = patterns
+	}
+	for _, name := range sortedKeys(route.Query) {
+		if name == "" {
+			fail("query", "route %d query: parameter name is empty", index)
+			continue
+		}
+		patterns := route.Query[name]
+		if err := patterns.Validate(); err != nil {
+			fail("query."+name, "route %d query '%s': %w", index, name, err)
+		}
+		route.Query[name] = patterns
+	}
+	for _, names := range [][]string{sortedStringKeys(route.TargetQuery.Set), sortedStringKeys(route.TargetQuery.Add), route.TargetQuery.Remove} {
+		for _, name := range names {
+			if name == "" {
+				fail("target_query", "route %d target_query: parameter name is empty", index)
+			}
+		}
 	}
 
-	// Validate on_request actions
-	for opIdx, op := range route.OnRequest {
-		if err := validateAction(&op, index, opIdx, "on_request"); err != nil {
-			return err
+	// Validate on_request actions in place so compiled patterns are kept
+	for opIdx := range route.OnRequest {
+		if err := validateAction(&route.OnRequest[opIdx], index, opIdx, "on_request"); err != nil {
+			errs = append(errs, err)
 		}
 	}
 
 	// Validate on_response actions
-	for opIdx, op := range route.OnResponse {
-		if err := validateAction(&op, index, opIdx, "on_response"); err != nil {
-			return err
+	for opIdx := range route.OnResponse {
+		if err := validateAction(&route.OnResponse[opIdx], index, opIdx, "on_response"); err != nil {
+			errs = append(errs, err)
 		}
 	}
 
-	return nil
+	// Validate on_stream_end actions; headers are already sent by then
+	for opIdx := range route.OnStreamEnd {
+		op := &route.OnStreamEnd[opIdx]
+		if err := validateAction(op, index, opIdx, "on_stream_end"); err != nil {
+			errs = append(errs, err)
+		}
+		if op.hasHeaderActions() {
+			errs = append(errs, op.Source.errorf("", "route %d on_stream_end %d: header rewrites are not supported after streaming", index, opIdx))
+		}
+	}
+
+	return errors.Join(errs...)
 }
 
 func validateAction(op *Action, ruleIndex, opIndex int, opType string) error {
+	var errs []error
+	fail := func(key, format string, args ...any) {
+		prefix := fmt.Sprintf("route %d %s %d", ruleIndex, opType, opIndex)
+		errs = append(errs, op.Source.errorf(key, prefix+format, args...))
+	}
+
 	// Validate match_body patterns
-	for key := range op.MatchBody {
+	for _, key := range sortedKeys(op.MatchBody) {
+		if strings.HasPrefix(key, "$") && !isMatchMetaKey(key) {
+			fail("match_body."+key, " match_body: unknown key '%s' (expected %s, %s or %s<name>)", key, matchKeyEvent, matchKeyEventID, matchKeyVars)
+			continue
+		}
+		if _, err := ParsePath(strings.TrimPrefix(key, matchKeyVars)); err != nil {
+			fail("match_body."+key, " match_body: %w", err)
+			continue
+		}
 		patterns := op.MatchBody[key]
 		if err := patterns.Validate(); err != nil {
-			return fmt.Errorf("route %d %s %d match_body '%s': %w", ruleIndex, opType, opIndex, key, err)
+			fail("match_body."+key, " match_body '%s': %w", key, err)
 		}
 		op.MatchBody[key] = patterns
 	}
 
 	// Validate match_headers patterns
-	for key := range op.MatchHeaders {
+	for _, key := range sortedKeys(op.MatchHeaders) {
 		patterns := op.MatchHeaders[key]
 		if err := patterns.Validate(); err != nil {
-			return fmt.Errorf("route %d %s %d match_headers '%s': %w", ruleIndex, opType, opIndex, key, err)
+			fail("match_headers."+key, " match_headers '%s': %w", key, err)
 		}
 		op.MatchHeaders[key] = patterns
 	}
 
-	// Template is a valid standalone action
-	if op.Template != "" {
-		return nil
+	if err := op.MatchTarget.Validate(); err != nil {
+		fail("match_target", " match_target: %w", err)
+	}
+
+	// Status only exists once there is a response
+	if op.MatchStatus.Len() > 0 && opType == "on_request" {
+		fail("match_status", ": match_status is not supported in on_request")
+	}
+	if err := op.MatchStatus.Validate(); err != nil {
+		fail("match_status", " match_status: %w", err)
+	}
+	if op.SetStatus != 0 {
+		if opType != "on_response" {
+			fail("set_status", ": set_status is only supported in on_response")
+		} else if op.SetStatus < 100 || op.SetStatus > 599 {
+			fail("set_status", ": invalid set_status %d", op.SetStatus)
+		}
+	}
+	if op.ErrorFormat != "" {
+		if opType != "on_response" {
+			fail("error_format", ": error_format is only supported in on_response")
+		} else if op.ErrorFormat != ErrorFormatOpenAI {
+			fail("error_format", ": error_format must be %s", ErrorFormatOpenAI)
+		}
 	}
 
-	if len(op.Merge) == 0 && len(op.Default) == 0 && len(op.Delete) == 0 {
-		return fmt.Errorf("route %d %s %d: must have at least one action (template, merge, default, or delete)", ruleIndex, opType, opIndex)
+	// Validate merge/default/delete paths
+	for _, key := range sortedKeys(op.Merge) {
+		if _, err := ParsePath(key); err != nil {
+			fail("merge."+key, " merge: %w", err)
+		}
+	}
+	for _, key := range sortedKeys(op.MergeDeep) {
+		if _, err := ParsePath(key); err != nil {
+			fail("merge_deep."+key, " merge_deep: %w", err)
+		}
+	}
+	switch op.ArrayMerge {
+	case "", ArrayMergeReplace, ArrayMergeAppend, ArrayMergePrepend, ArrayMergeUnion:
+	default:
+		fail("array_merge", ": array_merge must be replace, append, prepend, or union")
+	}
+	if op.ArrayMerge != "" && len(op.MergeDeep) == 0 {
+		fail("array_merge", ": array_merge requires merge_deep")
+	}
+	if op.ArrayMergeKey != "" && op.ArrayMerge != ArrayMergeUnion {
+		fail("array_merge_key", ": array_merge_key requires array_merge: union")
+	}
+	for _, key := range sortedKeys(op.Default) {
+		if _, err := ParsePath(key); err != nil {
+			fail("default."+key, " default: %w", err)
+		}
+	}
+	for _, key := range op.Delete {
+		if _, err := ParsePath(key); err != nil {
+			fail("delete", " delete: %w", err)
+		}
 	}
 
+	// Validate header rewrites
+	for _, name := range op.RemoveHeaders {
+		if err := validateHeaderName(name); err != nil {
+			fail("remove_headers", " remove_headers: %w", err)
+		}
+	}
+	for _, name := range sortedStringKeys(op.SetHeaders) {
+		if err := validateHeaderName(name); err != nil {
+			fail("set_headers."+name, " set_headers: %w", err)
+		}
+	}
+	for _, name := range sortedStringKeys(op.AddHeaders) {
+		if err := validateHeaderName(name); err != nil {
+			fail("add_headers."+name, " add_headers: %w", err)
+		}
+	}
+
+	for _, name := range sortedStringKeys(op.Capture) {
+		if name == "" || strings.ContainsAny(name, ".[] ") {
+			fail("capture."+name, " capture: invalid variable name '%s'", name)
+			continue
+		}
+		if _, err := ParsePath(op.Capture[name]); err != nil {
+			fail("capture."+name, " capture '%s': %w", name, err)
+		}
+	}
+
+	if op.Target != "" {
+		if opType != "on_request" {
+			fail("target", ": target is only supported in on_request")
+		} else if hostHasVarRefs(op.Target) {
+			fail("target", " target: ${name} references are only allowed in the path, not the scheme or host")
+		} else if err := validateTargetURL(withoutVarRefs(op.Target)); err != nil {
+			fail("target", " target: %w", err)
+		}
+	}
+
+	// Template, header rewrites, targets, captures and status rewrites are valid standalone actions
+	standalone := op.Template != "" || op.hasHeaderActions() || op.Target != "" || len(op.Capture) > 0 || op.SetStatus != 0 || op.ErrorFormat != ""
+	if !standalone && len(op.Merge) == 0 && len(op.MergeDeep) == 0 && len(op.Default) == 0 && len(op.Delete) == 0 {
+		fail("", ": must have at least one action (template, merge, merge_deep, default, delete, capture, set_status, error_format, or header rewrite)")
+	}
+
+	return errors.Join(errs...)
+}
+
+// withoutVarRefs stands in for ${name} references, which only resolve per request
+func withoutVarRefs(s string) string {
+	return varRefPattern.ReplaceAllString(s, "x")
+}
+
+// hostHasVarRefs reports whether ${name} references appear before a target's path
+// Variables come from the client's path and body, so they must never pick the upstream host.
+func hostHasVarRefs(target string) bool {
+	end := len(target)
+	if i := strings.Index(target, "://"); i >= 0 {
+		if j := strings.IndexAny(target[i+3:], "/?#"); j >= 0 {
+			end = i + 3 + j
+		}
+	}
+	return varRefPattern.MatchString(target[:end])
+}
+
+// validateTargetURL checks that a route or action target is an absolute URL
+func validateTargetURL(target string) error {
+	u, err := url.Parse(target)
+	if err != nil {
+		return fmt.Errorf("URL is invalid: %w", err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("URL '%s' must include scheme and host", target)
+	}
 	return nil
 }
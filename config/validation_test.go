@@ -121,6 +121,100 @@ func TestValidateConfig(t *testing.T) {
 			wantErr: true,
 			errMsg:  "both ssl_cert and ssl_key must be provided together",
 		},
+		{
+			name: "multiple targets",
+			config: &Config{
+				Proxies: ProxyEntries{{
+					Listen:  "localhost:8081",
+					Targets: []TargetConfig{{URL: "http://localhost:8080"}, {URL: "http://localhost:8082", Weight: 2}},
+					Balance: BalanceWeighted,
+					Routes: []Route{
+						{
+							Methods:   newPatternField("POST"),
+							Paths:     newPatternField("/v1/chat"),
+							OnRequest: []Action{{Merge: map[string]any{"temp": 0.7}}},
+						},
+					},
+				}},
+			},
+			wantErr: false,
+		},
+		{
+			name: "target and targets together",
+			config: &Config{
+				Proxies: ProxyEntries{{
+					Listen:  "localhost:8081",
+					Target:  "http://localhost:8080",
+					Targets: []TargetConfig{{URL: "http://localhost:8082"}},
+					Routes: []Route{
+						{
+							Methods:   newPatternField("POST"),
+							Paths:     newPatternField("/v1/chat"),
+							OnRequest: []Action{{Merge: map[string]any{"temp": 0.7}}},
+						},
+					},
+				}},
+			},
+			wantErr: true,
+			errMsg:  "mutually exclusive",
+		},
+		{
+			name: "unknown balance strategy",
+			config: &Config{
+				Proxies: ProxyEntries{{
+					Listen:  "localhost:8081",
+					Targets: []TargetConfig{{URL: "http://localhost:8080"}},
+					Balance: "random",
+					Routes: []Route{
+						{
+							Methods:   newPatternField("POST"),
+							Paths:     newPatternField("/v1/chat"),
+							OnRequest: []Action{{Merge: map[string]any{"temp": 0.7}}},
+						},
+					},
+				}},
+			},
+			wantErr: true,
+			errMsg:  "balance must be",
+		},
+		{
+			name: "invalid retry status",
+			config: &Config{
+				Proxies: ProxyEntries{{
+					Listen: "localhost:8081",
+					Target: "http://localhost:8080",
+					Retry:  RetryConfig{Attempts: 2, OnStatus: []int{503, 42}},
+					Routes: []Route{
+						{
+							Methods:   newPatternField("POST"),
+							Paths:     newPatternField("/v1/chat"),
+							OnRequest: []Action{{Merge: map[string]any{"temp": 0.7}}},
+						},
+					},
+				}},
+			},
+			wantErr: true,
+			errMsg:  "invalid status code 42",
+		},
+		{
+			name: "fallback without scheme",
+			config: &Config{
+				Proxies: ProxyEntries{{
+					Listen:    "localhost:8081",
+					Target:    "http://localhost:8080",
+					Fallbacks: []string{"localhost:9090"},
+					Routes: []Route{
+						{
+							Methods:   newPatternField("POST"),
+							Paths:     newPatternField("/v1/chat"),
+							OnRequest: []Action{{Merge: map[string]any{"temp": 0.7}}},
+						},
+					},
+				}},
+			},
+			wantErr: true,
+			errMsg:  "fallbacks[0]",
+		},
 	}
 
 	for _, tt := range tests {
@@ -206,6 +300,54 @@ func TestValidateRoute(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "target-only rule",
+			rule: Route{
+				Methods: newPatternField("POST"),
+				Paths:   newPatternField("/v1/chat"),
+				Target:  "http://localhost:1234",
+			},
+			wantErr: false,
+		},
+		{
+			name: "route target with a variable in the path",
+			rule: Route{
+				Methods: newPatternField("POST"),
+				Paths:   newPatternField("^/models/(?P<model>[^/]+)$"),
+				Target:  "http://localhost:1234/${model}",
+			},
+			wantErr: false,
+		},
+		{
+			name: "route target with a variable host",
+			rule: Route{
+				Methods: newPatternField("POST"),
+				Paths:   newPatternField("^/(?P<host>[^/]+)/chat$"),
+				Target:  "http://${host}:8080",
+			},
+			wantErr: true,
+			errMsg:  "only allowed in the path",
+		},
+		{
+			name: "route target that is one variable",
+			rule: Route{
+				Methods: newPatternField("POST"),
+				Paths:   newPatternField("^/(?P<upstream>.+)$"),
+				Target:  "${upstream}",
+			},
+			wantErr: true,
+			errMsg:  "only allowed in the path",
+		},
+		{
+			name: "invalid route target",
+			rule: Route{
+				Methods: newPatternField("POST"),
+				Paths:   newPatternField("/v1/chat"),
+				Target:  "/relative",
+			},
+			wantErr: true,
+			errMsg:  "route 0 target",
+		},
 		{
 			name: "missing methods",
 			rule: Route{
@@ -233,6 +375,119 @@ func TestValidateRoute(t *testing.T) {
 			wantErr: true,
 			errMsg:  "at least one action required",
 		},
+		{
+			name: "translate only",
+			rule: Route{
+				Methods:   newPatternField("POST"),
+				Paths:     newPatternField("/api/chat"),
+				Translate: TranslateOllamaToOpenAI,
+			},
+			wantErr: false,
+		},
+		{
+			name: "unknown translate mode",
+			rule: Route{
+				Methods:   newPatternField("POST"),
+				Paths:     newPatternField("/api/chat"),
+				Translate: "ollama-to-anthropic",
+			},
+			wantErr: true,
+			errMsg:  "translate must be",
+		},
+		{
+			name: "unknown stream format",
+			rule: Route{
+				Methods:      newPatternField("POST"),
+				Paths:        newPatternField("/api/chat"),
+				StreamFormat: "websocket",
+			},
+			wantErr: true,
+			errMsg:  "stream_format must be",
+		},
+		{
+			name: "on_stream_end alone is an action",
+			rule: Route{
+				Methods:     newPatternField("POST"),
+				Paths:       newPatternField("/v1/chat/completions"),
+				OnStreamEnd: []Action{{Template: `{"done": true}`}},
+			},
+			wantErr: false,
+		},
+		{
+			name: "upstream_stream alone is an action",
+			rule: Route{
+				Methods:        newPatternField("POST"),
+				Paths:          newPatternField("/v1/chat/completions"),
+				UpstreamStream: new(bool),
+			},
+			wantErr: false,
+		},
+		{
+			name: "header rewrite in on_stream_end",
+			rule: Route{
+				Methods:     newPatternField("POST"),
+				Paths:       newPatternField("/v1/chat/completions"),
+				OnStreamEnd: []Action{{SetHeaders: map[string]string{"X-Done": "1"}}},
+			},
+			wantErr: true,
+			errMsg:  "header rewrites are not supported after streaming",
+		},
+		{
+			name: "target_query alone with host and query patterns",
+			rule: Route{
+				Methods:     newPatternField("GET"),
+				Paths:       newPatternField("/v1/models"),
+				Hosts:       newPatternField(`^llm\.internal$`),
+				Query:       map[string]PatternField{"beta": {Patterns: []string{"^true$"}}},
+				TargetQuery: QueryEdits{Remove: []string{"beta"}},
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid query pattern",
+			rule: Route{
+				Methods:     newPatternField("GET"),
+				Paths:       newPatternField("/v1/models"),
+				Query:       map[string]PatternField{"beta": {Patterns: []string{"("}}},
+				TargetQuery: QueryEdits{Remove: []string{"beta"}},
+			},
+			wantErr: true,
+			errMsg:  "route 0 query 'beta'",
+		},
+		{
+			name: "route match_body and match_headers gates",
+			rule: Route{
+				Methods:      newPatternField("POST"),
+				Paths:        newPatternField("/v1/chat/completions"),
+				MatchBody:    map[string]PatternField{"model": {Patterns: []string{"^qwen"}}, "$vars.alias": {Patterns: []string{"fast"}}},
+				MatchHeaders: map[string]PatternField{"X-Tier": {Patterns: []string{"^pro$"}}},
+				TargetPath:   "/qwen/v1/chat/completions",
+				OnRequest:    []Action{{Merge: map[string]any{"top_k": 20}}},
+			},
+			wantErr: false,
+		},
+		{
+			name: "route match_body rejects event metadata",
+			rule: Route{
+				Methods:   newPatternField("POST"),
+				Paths:     newPatternField("/v1/chat/completions"),
+				MatchBody: map[string]PatternField{"$event": {Patterns: []string{"message"}}},
+				OnRequest: []Action{{Merge: map[string]any{"temp": 0.7}}},
+			},
+			wantErr: true,
+			errMsg:  "route 0 match_body: unknown key '$event'",
+		},
+		{
+			name: "invalid route match_headers pattern",
+			rule: Route{
+				Methods:      newPatternField("POST"),
+				Paths:        newPatternField("/v1/chat/completions"),
+				MatchHeaders: map[string]PatternField{"X-Tier": {Patterns: []string{"("}}},
+				OnRequest:    []Action{{Merge: map[string]any{"temp": 0.7}}},
+			},
+			wantErr: true,
+			errMsg:  "route 0 match_headers 'X-Tier'",
+		},
 		{
 			name: "invalid target path (not absolute)",
 			rule: Route{
@@ -348,12 +603,162 @@ func TestValidateAction(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "valid nested match_body path",
+			op: Action{
+				MatchBody: map[string]PatternField{
+					"messages[-1].role": newPatternField("user"),
+				},
+				Merge: map[string]any{"temperature": 0.7},
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid match_body path",
+			op: Action{
+				MatchBody: map[string]PatternField{
+					"messages[last].role": newPatternField("user"),
+				},
+				Merge: map[string]any{"temperature": 0.7},
+			},
+			wantErr: true,
+			errMsg:  "invalid index",
+		},
+		{
+			name: "event metadata match_body keys",
+			op: Action{
+				MatchBody: map[string]PatternField{
+					"$event":    newPatternField("content_block_delta"),
+					"$event_id": newPatternField(".*"),
+				},
+				Merge: map[string]any{"seen": true},
+			},
+			wantErr: false,
+		},
+		{
+			name: "unknown metadata match_body key",
+			op: Action{
+				MatchBody: map[string]PatternField{
+					"$status": newPatternField("200"),
+				},
+				Merge: map[string]any{"seen": true},
+			},
+			wantErr: true,
+			errMsg:  "unknown key '$status'",
+		},
+		{
+			name: "capture alone with $vars match",
+			op: Action{
+				MatchBody: map[string]PatternField{
+					"$vars.original_model": newPatternField("^fast$"),
+				},
+				Capture: map[string]string{"first_role": "messages[0].role"},
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid capture variable name",
+			op: Action{
+				Capture: map[string]string{"a.b": "model"},
+			},
+			wantErr: true,
+			errMsg:  "invalid variable name 'a.b'",
+		},
+		{
+			name: "invalid merge path",
+			op: Action{
+				Merge: map[string]any{"options..temperature": 0.7},
+			},
+			wantErr: true,
+			errMsg:  "merge: path",
+		},
+		{
+			name: "invalid delete path",
+			op: Action{
+				Delete: []string{"messages[*"},
+			},
+			wantErr: true,
+			errMsg:  "delete: path",
+		},
+		{
+			name: "valid merge_deep with union key",
+			op: Action{
+				MergeDeep:     map[string]any{"tools": []any{}},
+				ArrayMerge:    ArrayMergeUnion,
+				ArrayMergeKey: "name",
+			},
+			wantErr: false,
+		},
+		{
+			name: "unknown array_merge",
+			op: Action{
+				MergeDeep:  map[string]any{"stop": []any{}},
+				ArrayMerge: "zip",
+			},
+			wantErr: true,
+			errMsg:  "array_merge must be",
+		},
+		{
+			name: "array_merge without merge_deep",
+			op: Action{
+				Merge:      map[string]any{"stop": []any{}},
+				ArrayMerge: ArrayMergeAppend,
+			},
+			wantErr: true,
+			errMsg:  "array_merge requires merge_deep",
+		},
+		{
+			name: "array_merge_key without union",
+			op: Action{
+				MergeDeep:     map[string]any{"tools": []any{}},
+				ArrayMerge:    ArrayMergeAppend,
+				ArrayMergeKey: "name",
+			},
+			wantErr: true,
+			errMsg:  "array_merge_key requires",
+		},
+		{
+			name: "valid target override",
+			op: Action{
+				MatchBody: map[string]PatternField{"model": newPatternField("qwen")},
+				Target:    "http://localhost:8081",
+			},
+			wantErr: false,
+		},
+		{
+			name: "target with a variable scheme",
+			op: Action{
+				MatchBody: map[string]PatternField{"scheme": newPatternField("^(?P<scheme>https?)$")},
+				Target:    "${scheme}://localhost:8081",
+			},
+			wantErr: true,
+			errMsg:  "only allowed in the path",
+		},
+		{
+			name: "target without host",
+			op: Action{
+				Target: "localhost:8081",
+			},
+			wantErr: true,
+			errMsg:  "must include scheme and host",
+		},
 		{
 			name:    "no actions",
 			op:      Action{},
 			wantErr: true,
 			errMsg:  "must have at least one action",
 		},
+		{
+			name: "invalid match_body condition",
+			op: Action{
+				MatchBody: map[string]PatternField{
+					"tools": {Condition: &MatchCondition{Type: "list"}},
+				},
+				Merge: map[string]any{"temp": 0.7},
+			},
+			wantErr: true,
+			errMsg:  "match_body 'tools': unknown type 'list'",
+		},
 		{
 			name: "invalid regex in match_body",
 			op: Action{
@@ -0,0 +1,122 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/spicyneuron/llama-matchmaker/logger"
+)
+
+// varRefPattern finds ${name} references to captured variables and regex groups
+var varRefPattern = regexp.MustCompile(`\$\{(\w+)\}`)
+
+// ExpandVars replaces ${name} references in s with request-scoped variables
+// References to unknown names are left as written.
+func ExpandVars(s string, vars map[string]any) string {
+	if len(vars) == 0 || !strings.Contains(s, "${") {
+		return s
+	}
+	return varRefPattern.ReplaceAllStringFunc(s, func(ref string) string {
+		value, ok := vars[ref[2:len(ref)-1]]
+		if !ok {
+			return ref
+		}
+		return fmt.Sprintf("%v", value)
+	})
+}
+
+// expandValue expands references in every string of a config value, copying as it goes
+// A string that is exactly one reference takes the variable's value with its type.
+func expandValue(value any, vars map[string]any) any {
+	switch v := value.(type) {
+	case string:
+		if m := varRefPattern.FindStringSubmatch(v); m != nil && m[0] == v {
+			if resolved, ok := vars[m[1]]; ok {
+				return copyValue(resolved)
+			}
+		}
+		return ExpandVars(v, vars)
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, val := range v {
+			out[k] = expandValue(val, vars)
+		}
+		return out
+	case []any:
+		out := make([]any, len(v))
+		for i, val := range v {
+			out[i] = expandValue(val, vars)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// expandValues expands the values of merge, merge_deep and default maps
+func expandValues(values map[string]any, vars map[string]any) map[string]any {
+	if len(vars) == 0 {
+		return values
+	}
+	return expandValue(values, vars).(map[string]any)
+}
+
+// exchangeVars returns the request-scoped variables, or nil without an exchange
+func exchangeVars(ex *Exchange) map[string]any {
+	if ex == nil {
+		return nil
+	}
+	return ex.Vars
+}
+
+// bodyCaptures collects regex groups from match_body patterns
+// Each key contributes the groups of the first value its patterns match.
+func bodyCaptures(data map[string]any, criteria map[string]PatternField, ex *Exchange) map[string]string {
+	keys := make([]string, 0, len(criteria))
+	for key := range criteria {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	groups := make(map[string]string)
+	for _, key := range keys {
+		for _, value := range matchValues(data, key, ex) {
+			if found := criteria[key].Captures(fmt.Sprintf("%v", value)); found != nil {
+				for name, group := range found {
+					groups[name] = group
+				}
+				break
+			}
+		}
+	}
+	return groups
+}
+
+// storeCaptures adds regex groups to the exchange's variables
+func storeCaptures(ex *Exchange, groups map[string]string, scope string, index int) {
+	if ex == nil || len(groups) == 0 {
+		return
+	}
+	if ex.Vars == nil {
+		ex.Vars = make(map[string]any)
+	}
+	for name, value := range groups {
+		ex.Vars[name] = value
+	}
+	logger.Debug("Captured regex groups", "scope", scope, "index", index, "groups", groups)
+}
+
+// CaptureGroups stores the regex groups of the route's paths and match_body patterns in ex.Vars
+// Body groups are taken after path groups, so they win when names collide.
+func (r *Route) CaptureGroups(path string, data map[string]any, ex *Exchange, routeIndex int) {
+	groups := r.Paths.Captures(path)
+	if groups == nil {
+		groups = make(map[string]string)
+	}
+	for name, value := range bodyCaptures(data, r.MatchBody, ex) {
+		groups[name] = value
+	}
+	storeCaptures(ex, groups, "route", routeIndex)
+}
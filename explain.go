@@ -0,0 +1,381 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/spicyneuron/llama-matchmaker/config"
+	"github.com/spicyneuron/llama-matchmaker/logger"
+	"github.com/spicyneuron/llama-matchmaker/proxy"
+)
+
+// headerFlags collects repeated -header k=v flags
+type headerFlags []string
+
+func (h *headerFlags) String() string {
+	return fmt.Sprint(*h)
+}
+
+func (h *headerFlags) Set(value string) error {
+	if !strings.Contains(value, "=") {
+		return fmt.Errorf("header must be name=value, got %q", value)
+	}
+	*h = append(*h, value)
+	return nil
+}
+
+// runExplain traces one request (and optionally its response) through a proxy's routes offline
+// Nothing is sent upstream: the request is rewritten as the proxy would, and the response is read from a file.
+func runExplain(args []string, stdout, stderr io.Writer) int {
+	var (
+		paths   configFiles
+		headers headerFlags
+	)
+	fs := flag.NewFlagSet("explain", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Var(&paths, "config", "Path to YAML configuration (can be specified multiple times)")
+	fs.Var(&paths, "c", "Alias for -config")
+	method := fs.String("method", "POST", "Request method")
+	path := fs.String("path", "", "Request path, optionally with a query string (ex: /v1/chat/completions)")
+	bodyFile := fs.String("body", "", "File holding the request body (- for stdin)")
+	fs.Var(&headers, "header", "Request header as name=value (can be specified multiple times)")
+	listen := fs.String("listen", "", "Listen address of the proxy to use (default: the first proxy)")
+	responseFile := fs.String("response", "", "File holding an upstream response to run on_response against")
+	status := fs.Int("status", http.StatusOK, "Status code of the upstream response")
+	debug := fs.Bool("debug", false, "Print debug logs to stderr")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: llama-matchmaker explain -config <config.yml> -path <path> [-method POST] [-body req.json] [-header name=value ...] [-response resp.json]")
+		fmt.Fprintln(stderr)
+		fmt.Fprintln(stderr, "Shows how a request would be matched and rewritten, without a network or upstream.")
+		fmt.Fprintln(stderr)
+		fmt.Fprintln(stderr, "Options:")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if len(paths) == 0 || *path == "" {
+		fs.Usage()
+		return 2
+	}
+
+	// Logs would interleave with the report
+	logger.SetOutput(stderr)
+	defer logger.SetOutput(os.Stdout)
+	logger.EnableDebug(*debug)
+
+	cfg, _, err := config.Load(paths, config.CliOverrides{})
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	proxyCfg, ok := selectProxy(cfg, *listen)
+	if !ok {
+		fmt.Fprintf(stderr, "no proxy listens on %s\n", *listen)
+		return 1
+	}
+
+	var body []byte
+	if *bodyFile != "" {
+		if body, err = readInput(*bodyFile); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+	}
+
+	requestHeaders := make(http.Header)
+	for _, header := range headers {
+		name, value, _ := strings.Cut(header, "=")
+		requestHeaders.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	ex := &explainer{out: stdout}
+	json.Unmarshal(body, &ex.body)
+	ctx := proxy.WithTrace(context.Background(), ex.step)
+
+	fmt.Fprintf(stdout, "Request: %s %s\n", strings.ToUpper(*method), *path)
+	req, err := offlineRequest(ctx, proxyCfg, *method, *path, requestHeaders, body)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	outbound, _ := io.ReadAll(req.Body)
+	fmt.Fprintf(stdout, "\nOutbound request: %s %s\n", req.Method, req.URL)
+	printHeaders(stdout, req.Header)
+	printBody(stdout, outbound)
+
+	if *responseFile == "" {
+		return 0
+	}
+
+	respBody, err := readInput(*responseFile)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	contentType := responseContentType(respBody)
+	resp := offlineResponse(req, *status, http.Header{"Content-Type": {contentType}}, respBody)
+
+	ex.mu.Lock()
+	ex.body = nil
+	json.Unmarshal(respBody, &ex.body)
+	// Streamed chunks are traced one at a time, so there is no single body to diff against
+	ex.streaming = strings.Contains(contentType, "text/event-stream") || strings.Contains(contentType, "application/x-ndjson")
+	ex.mu.Unlock()
+
+	fmt.Fprintf(stdout, "\nResponse: %d (%s)\n", *status, contentType)
+	if err := proxy.ModifyResponse(resp, proxyCfg.Routes); err != nil {
+		fmt.Fprintf(stderr, "response processing failed: %v\n", err)
+		return 1
+	}
+	clientBody, _ := io.ReadAll(resp.Body)
+
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	fmt.Fprintf(stdout, "\nClient response: %d\n", resp.StatusCode)
+	printHeaders(stdout, resp.Header)
+	printBody(stdout, clientBody)
+	return 0
+}
+
+// offlineRequest builds a client request and rewrites it the way the proxy's director would
+// The upstream is chosen by the proxy's balancer but never contacted.
+func offlineRequest(ctx context.Context, proxyCfg config.ProxyConfig, method, path string, headers http.Header, body []byte) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, strings.ToUpper(method), "http://"+proxyCfg.Listen+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("invalid request: %w", err)
+	}
+	for name, values := range headers {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+	if len(body) > 0 && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	balancer, err := proxy.NewBalancer(proxyCfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid target URL: %w", err)
+	}
+	balancer.Direct(req)
+	proxy.ModifyRequest(req, proxyCfg.Routes)
+	return req, nil
+}
+
+// offlineResponse builds an upstream response to req for proxy.ModifyResponse
+func offlineResponse(req *http.Request, status int, headers http.Header, body []byte) *http.Response {
+	return &http.Response{
+		StatusCode:    status,
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		Header:        headers,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+// selectProxy picks the proxy listening on listen, or the first one
+func selectProxy(cfg *config.Config, listen string) (config.ProxyConfig, bool) {
+	for _, p := range cfg.Proxies {
+		if listen == "" || p.Listen == listen {
+			return p, true
+		}
+	}
+	return config.ProxyConfig{}, false
+}
+
+// readInput reads a file, or stdin for "-"
+func readInput(name string) ([]byte, error) {
+	if name == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	return data, nil
+}
+
+// responseContentType guesses how an upstream would label a saved response
+// SSE transcripts have data: lines, NDJSON has one JSON object per line.
+func responseContentType(body []byte) string {
+	trimmed := bytes.TrimSpace(body)
+	if json.Valid(trimmed) {
+		return "application/json"
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(trimmed))
+	ndjson := len(trimmed) > 0
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "data:") || strings.HasPrefix(line, "event:") {
+			return "text/event-stream"
+		}
+		if line != "" && !json.Valid([]byte(line)) {
+			ndjson = false
+		}
+	}
+	if ndjson {
+		return "application/x-ndjson"
+	}
+	return "text/plain"
+}
+
+// explainer prints trace steps with a diff of the body each step changed
+type explainer struct {
+	out       io.Writer
+	mu        sync.Mutex
+	body      map[string]any
+	streaming bool
+}
+
+func (e *explainer) step(step config.TraceStep) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	outcome := "skipped"
+	if step.Matched {
+		outcome = "matched"
+	}
+	if step.Stop {
+		outcome += ", stop"
+	}
+	fmt.Fprintf(e.out, "  %s: %s (%s)\n", stepLabel(step), outcome, step.Reason)
+
+	if step.Body == nil {
+		return
+	}
+	if e.streaming && step.Phase != "route" {
+		chunk, _ := json.Marshal(step.Body)
+		fmt.Fprintf(e.out, "      = %s\n", chunk)
+		return
+	}
+	for _, line := range diffBodies(e.body, step.Body) {
+		fmt.Fprintf(e.out, "      %s\n", line)
+	}
+	e.body = step.Body
+}
+
+// stepLabel names a step the way validation errors name routes and actions
+func stepLabel(step config.TraceStep) string {
+	switch step.Phase {
+	case "route":
+		return fmt.Sprintf("route %d", step.Route)
+	case "request", "response", "stream_end":
+		return fmt.Sprintf("route %d on_%s %d", step.Route, step.Phase, step.Action)
+	default:
+		return step.Phase
+	}
+}
+
+// diffBodies lists the leaf values added (+), removed (-) and changed (~) between two bodies
+func diffBodies(before, after map[string]any) []string {
+	old := make(map[string]string)
+	flattenJSON("", before, old)
+	updated := make(map[string]string)
+	flattenJSON("", after, updated)
+
+	keys := make([]string, 0, len(old)+len(updated))
+	for k := range old {
+		keys = append(keys, k)
+	}
+	for k := range updated {
+		if _, ok := old[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var lines []string
+	for _, k := range keys {
+		was, hadOld := old[k]
+		now, hasNew := updated[k]
+		switch {
+		case !hadOld:
+			lines = append(lines, fmt.Sprintf("+ %s: %s", k, now))
+		case !hasNew:
+			lines = append(lines, fmt.Sprintf("- %s: %s", k, was))
+		case was != now:
+			lines = append(lines, fmt.Sprintf("~ %s: %s -> %s", k, was, now))
+		}
+	}
+	return lines
+}
+
+// flattenJSON records each leaf of a decoded JSON value under its match_body style path
+func flattenJSON(prefix string, value any, out map[string]string) {
+	switch v := value.(type) {
+	case map[string]any:
+		if len(v) == 0 && prefix != "" {
+			out[prefix] = "{}"
+		}
+		for k, child := range v {
+			key := k
+			if prefix != "" {
+				key = prefix + "." + k
+			}
+			flattenJSON(key, child, out)
+		}
+	case []any:
+		if len(v) == 0 {
+			out[prefix] = "[]"
+		}
+		for i, child := range v {
+			flattenJSON(prefix+"["+strconv.Itoa(i)+"]", child, out)
+		}
+	default:
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			encoded = []byte(fmt.Sprint(v))
+		}
+		out[prefix] = string(encoded)
+	}
+}
+
+func printHeaders(w io.Writer, headers http.Header) {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range headers[name] {
+			if value != "" {
+				fmt.Fprintf(w, "  %s: %s\n", name, value)
+			}
+		}
+	}
+}
+
+// printBody indents JSON bodies and prints anything else as it is
+func printBody(w io.Writer, body []byte) {
+	if len(body) == 0 {
+		return
+	}
+	var indented bytes.Buffer
+	if json.Indent(&indented, body, "  ", "  ") == nil {
+		fmt.Fprintf(w, "  %s\n", indented.String())
+		return
+	}
+	for _, line := range strings.Split(strings.TrimRight(string(body), "\n"), "\n") {
+		if line == "" {
+			fmt.Fprintln(w)
+			continue
+		}
+		fmt.Fprintf(w, "  %s\n", line)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRunExplain(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yml")
+	if err := writeFile(configPath, `
+proxy:
+  listen: localhost:8081
+  target: http://localhost:8080
+  routes:
+    - methods: POST
+      paths: ^/v1/chat/completions$
+      on_request:
+        - default: {temperature: 0.7}
+        - match_body: {model: ^qwen}
+          merge: {temperature: 0.6}
+        - match_body: {model: ^llama}
+          merge: {top_k: 40}
+      on_response:
+        - match_status: 5xx
+          merge: {failed: true}
+        - merge: {served_by: matchmaker}
+    - methods: GET
+      paths: ^/models$
+      target_path: /v1/models
+      target_query: {remove: [debug]}
+`); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	bodyPath := filepath.Join(tmpDir, "req.json")
+	if err := writeFile(bodyPath, `{"model":"qwen3","debug":true}`); err != nil {
+		t.Fatalf("failed to write body: %v", err)
+	}
+	responsePath := filepath.Join(tmpDir, "resp.json")
+	if err := writeFile(responsePath, `{"id":"chatcmpl-1"}`); err != nil {
+		t.Fatalf("failed to write response: %v", err)
+	}
+	ssePath := filepath.Join(tmpDir, "resp.sse")
+	if err := writeFile(ssePath, "data: {\"id\":\"chunk\"}\n\ndata: [DONE]\n\n"); err != nil {
+		t.Fatalf("failed to write response: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{
+			name: "request trace",
+			args: []string{"-config", configPath, "-path", "/v1/chat/completions", "-body", bodyPath, "-header", "X-Tier=pro"},
+			want: []string{
+				"route 0: matched (POST /v1/chat/completions matched methods POST and paths ^/v1/chat/completions$)",
+				"route 1: skipped (method POST does not match GET)",
+				"route 0 on_request 0: matched (no match criteria)\n      + temperature: 0.7\n",
+				"route 0 on_request 1: matched (match_body 'model' matched)\n      ~ temperature: 0.7 -> 0.6\n",
+				`route 0 on_request 2: skipped (match_body 'model': "qwen3" does not match ^llama)`,
+				"Outbound request: POST http://localhost:8080/v1/chat/completions",
+				"X-Tier: pro",
+				`"temperature": 0.6`,
+			},
+		},
+		{
+			name: "path rewrite",
+			args: []string{"-c", configPath, "-method", "get", "-path", "/models?debug=1"},
+			want: []string{
+				"route 1: matched (GET /models matched methods GET and paths ^/models$; rewrote request to http://localhost:8080/v1/models)",
+				"Outbound request: GET http://localhost:8080/v1/models",
+			},
+		},
+		{
+			name: "response",
+			args: []string{"-c", configPath, "-path", "/v1/chat/completions", "-body", bodyPath, "-response", responsePath},
+			want: []string{
+				"Response: 200 (application/json)",
+				"route 0 on_response 0: skipped (match_status: 200 is not 5xx)",
+				"route 0 on_response 1: matched (no match criteria)\n      + served_by: \"matchmaker\"\n",
+				"Client response: 200",
+			},
+		},
+		{
+			name: "streamed response",
+			args: []string{"-c", configPath, "-path", "/v1/chat/completions", "-body", bodyPath, "-response", ssePath},
+			want: []string{
+				"Response: 200 (text/event-stream)",
+				`= {"id":"chunk","served_by":"matchmaker"}`,
+				`data: {"id":"chunk","served_by":"matchmaker"}`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			if code := runExplain(tt.args, &stdout, &stderr); code != 0 {
+				t.Fatalf("exit code = %d, stderr: %s", code, stderr.String())
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(stdout.String(), want) {
+					t.Errorf("output missing %q:\n%s", want, stdout.String())
+				}
+			}
+		})
+	}
+}
+
+func TestRunExplainRequiresPath(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if code := runExplain([]string{"-config", "config.yml"}, &stdout, &stderr); code != 2 {
+		t.Errorf("exit code = %d, want 2", code)
+	}
+	if !strings.Contains(stderr.String(), "Usage: llama-matchmaker explain") {
+		t.Errorf("expected usage, got %q", stderr.String())
+	}
+}
+
+func TestDiffBodies(t *testing.T) {
+	before := map[string]any{
+		"model":    "qwen3",
+		"debug":    true,
+		"options":  map[string]any{"num_ctx": 2048.0},
+		"messages": []any{map[string]any{"role": "user"}},
+	}
+	after := map[string]any{
+		"model":    "qwen3",
+		"options":  map[string]any{"num_ctx": 8192.0, "top_k": 40.0},
+		"messages": []any{map[string]any{"role": "user"}, map[string]any{"role": "assistant"}},
+	}
+
+	got := diffBodies(before, after)
+	want := []string{
+		"- debug: true",
+		"+ messages[1].role: \"assistant\"",
+		"~ options.num_ctx: 2048 -> 8192",
+		"+ options.top_k: 40",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("diffBodies() = %q, want %q", got, want)
+	}
+}
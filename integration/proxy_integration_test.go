@@ -3,15 +3,18 @@ package integration
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"net/http/httputil"
 	"net/url"
+	"strings"
 	"testing"
 
 	"github.com/spicyneuron/llama-matchmaker/config"
 	"github.com/spicyneuron/llama-matchmaker/proxy"
+	"gopkg.in/yaml.v3"
 )
 
 func TestEndToEndRequestModification(t *testing.T) {
@@ -276,3 +279,69 @@ func TestBodySizeLimit(t *testing.T) {
 	// If we get here without panic, the size limit is working
 	t.Log("Body size limit test passed (no panic on large body)")
 }
+
+func TestEndToEndCapturedModelAlias(t *testing.T) {
+	var upstreamModels []string
+	backend, closeBackend := newSafeTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		json.NewDecoder(r.Body).Decode(&body)
+		upstreamModels = append(upstreamModels, body["model"].(string))
+		if body["stream"] == true {
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprintf(w, "data: {\"model\":%q,\"choices\":[]}\n\ndata: [DONE]\n\n", body["model"])
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"model":%q,"choices":[]}`, body["model"])
+	})
+	if backend == nil {
+		return
+	}
+	defer closeBackend()
+
+	var route config.Route
+	if err := yaml.Unmarshal([]byte(`
+methods: POST
+paths: /v1/chat/completions
+on_request:
+  - match_body:
+      model: ^fast$
+    capture:
+      alias: model
+    merge:
+      model: qwen3-30b-a3b
+on_response:
+  - match_body:
+      $vars.alias: .+
+    template: '{"model": {{ toJson $vars.alias }}, "choices": {{ toJson .choices }}}'
+`), &route); err != nil {
+		t.Fatalf("Failed to parse route: %v", err)
+	}
+	proxyServer := newRouteProxy(t, backend.URL, route)
+	defer proxyServer.Close()
+
+	resp, err := http.Post(proxyServer.URL+"/v1/chat/completions", "application/json", strings.NewReader(`{"model":"fast"}`))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	var got map[string]any
+	json.NewDecoder(resp.Body).Decode(&got)
+	resp.Body.Close()
+	if got["model"] != "fast" {
+		t.Errorf("response model = %v, want the client's alias", got["model"])
+	}
+
+	resp, err = http.Post(proxyServer.URL+"/v1/chat/completions", "application/json", strings.NewReader(`{"model":"fast","stream":true}`))
+	if err != nil {
+		t.Fatalf("Streaming request failed: %v", err)
+	}
+	stream, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(stream), `"model":"fast"`) {
+		t.Errorf("streamed chunk kept the upstream model: %s", stream)
+	}
+
+	if len(upstreamModels) != 2 || upstreamModels[0] != "qwen3-30b-a3b" || upstreamModels[1] != "qwen3-30b-a3b" {
+		t.Errorf("upstream saw models %v, want the alias target", upstreamModels)
+	}
+}
@@ -0,0 +1,135 @@
+package integration
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/spicyneuron/llama-matchmaker/config"
+)
+
+func TestUpstreamStreamOffSynthesizesClientStream(t *testing.T) {
+	var upstreamBody map[string]any
+	backend, closeBackend := newSafeTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&upstreamBody)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(readFixture(t, "openai-chat-response.json"))
+	})
+	if backend == nil {
+		return
+	}
+	defer closeBackend()
+
+	off := false
+	proxyServer := newRouteProxy(t, backend.URL, config.Route{
+		Methods:        newPatternField("POST"),
+		Paths:          newPatternField(".*"),
+		UpstreamStream: &off,
+	})
+	defer proxyServer.Close()
+
+	reqBody := `{"model":"gpt-4.1","stream":true,"stream_options":{"include_usage":true},"messages":[{"role":"user","content":"Why is the sky blue?"}]}`
+	resp, err := http.Post(proxyServer.URL+"/v1/chat/completions", "application/json", strings.NewReader(reqBody))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if upstreamBody["stream"] != false || upstreamBody["stream_options"] != nil {
+		t.Errorf("unexpected upstream body: %v", upstreamBody)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %s, want text/event-stream", ct)
+	}
+
+	var content strings.Builder
+	var chunks []map[string]any
+	sawDone := false
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		payload, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		if payload == "[DONE]" {
+			sawDone = true
+			continue
+		}
+		var chunk map[string]any
+		if err := json.Unmarshal([]byte(payload), &chunk); err != nil {
+			t.Fatalf("invalid chunk %q: %v", payload, err)
+		}
+		chunks = append(chunks, chunk)
+		delta := chunk["choices"].([]any)[0].(map[string]any)["delta"].(map[string]any)
+		if s, ok := delta["content"].(string); ok {
+			content.WriteString(s)
+		}
+	}
+
+	if !sawDone || len(chunks) != 2 {
+		t.Fatalf("expected two chunks and [DONE], got %d chunks (done=%v)", len(chunks), sawDone)
+	}
+	if chunks[0]["object"] != "chat.completion.chunk" || !strings.HasPrefix(content.String(), "The sky appears blue") {
+		t.Errorf("unexpected content chunk: %v", chunks[0])
+	}
+	final := chunks[1]
+	if final["choices"].([]any)[0].(map[string]any)["finish_reason"] != "stop" || final["usage"] == nil {
+		t.Errorf("unexpected final chunk: %v", final)
+	}
+}
+
+func TestUpstreamStreamOnBuffersClientResponse(t *testing.T) {
+	var upstreamBody map[string]any
+	backend, closeBackend := newSafeTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&upstreamBody)
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		fmt.Fprintln(w, compactFixture(t, "ollama-chat-streaming-chunk.json"))
+		fmt.Fprintln(w, compactFixture(t, "ollama-chat-streaming-chunk.json"))
+		fmt.Fprintln(w, compactFixture(t, "ollama-chat-streaming-final.json"))
+	})
+	if backend == nil {
+		return
+	}
+	defer closeBackend()
+
+	on := true
+	proxyServer := newRouteProxy(t, backend.URL, config.Route{
+		Methods:        newPatternField("POST"),
+		Paths:          newPatternField(".*"),
+		UpstreamStream: &on,
+	})
+	defer proxyServer.Close()
+
+	resp, err := http.Post(proxyServer.URL+"/api/chat", "application/json", strings.NewReader(string(readFixture(t, "ollama-chat-request.json"))))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if upstreamBody["stream"] != true || upstreamBody["stream_options"] != nil {
+		t.Errorf("unexpected upstream body: %v", upstreamBody)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %s, want application/json", ct)
+	}
+
+	var got map[string]any
+	if err := json.Unmarshal(body, &got); err != nil {
+		t.Fatalf("client received non-JSON body %q: %v", body, err)
+	}
+
+	var chunk map[string]any
+	json.Unmarshal(readFixture(t, "ollama-chat-streaming-chunk.json"), &chunk)
+	piece := chunk["message"].(map[string]any)["content"].(string)
+	if msg := got["message"].(map[string]any); msg["content"] != piece+piece {
+		t.Errorf("content = %q, want %q", msg["content"], piece+piece)
+	}
+	if got["done"] != true || got["eval_count"] == nil {
+		t.Errorf("unexpected buffered response: %v", got)
+	}
+}
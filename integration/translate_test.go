@@ -0,0 +1,195 @@
+package integration
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spicyneuron/llama-matchmaker/config"
+	"github.com/spicyneuron/llama-matchmaker/proxy"
+)
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "fixtures", name))
+	if err != nil {
+		t.Fatalf("Failed to read fixture %s: %v", name, err)
+	}
+	return data
+}
+
+// compactFixture returns a fixture as a single JSON line for streaming bodies
+func compactFixture(t *testing.T, name string) string {
+	t.Helper()
+	var v any
+	if err := json.Unmarshal(readFixture(t, name), &v); err != nil {
+		t.Fatalf("Invalid fixture %s: %v", name, err)
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+func newTranslatingProxy(t *testing.T, backendURL, mode string) *httptest.Server {
+	t.Helper()
+	return newRouteProxy(t, backendURL, config.Route{
+		Methods:   newPatternField(".*"),
+		Paths:     newPatternField(".*"),
+		Translate: mode,
+	})
+}
+
+// newRouteProxy serves a single route in front of backendURL
+func newRouteProxy(t *testing.T, backendURL string, route config.Route) *httptest.Server {
+	t.Helper()
+	cfg := newTestConfig(backendURL, []config.Route{route})
+	if err := config.Validate(cfg); err != nil {
+		t.Fatalf("Config validation failed: %v", err)
+	}
+	if err := config.CompileTemplates(cfg); err != nil {
+		t.Fatalf("Template compilation failed: %v", err)
+	}
+
+	targetURL, _ := url.Parse(backendURL)
+	rp := httputil.NewSingleHostReverseProxy(targetURL)
+	director := rp.Director
+	rp.Director = func(req *http.Request) {
+		director(req)
+		proxy.ModifyRequest(req, cfg.Proxies[0].Routes)
+	}
+	rp.ModifyResponse = func(resp *http.Response) error {
+		return proxy.ModifyResponse(resp, cfg.Proxies[0].Routes)
+	}
+	return httptest.NewServer(rp)
+}
+
+func TestTranslateOllamaClientToOpenAIStreaming(t *testing.T) {
+	var upstreamBody map[string]any
+	var upstreamPath string
+	backend, closeBackend := newSafeTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		upstreamPath = r.URL.Path
+		json.NewDecoder(r.Body).Decode(&upstreamBody)
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, name := range []string{"openai-chat-streaming-first.json", "openai-chat-streaming-chunk.json", "openai-chat-streaming-final.json"} {
+			fmt.Fprintf(w, "data: %s\n\n", compactFixture(t, name))
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	})
+	if backend == nil {
+		return
+	}
+	defer closeBackend()
+
+	proxyServer := newTranslatingProxy(t, backend.URL, config.TranslateOllamaToOpenAI)
+	defer proxyServer.Close()
+
+	// Ollama streams unless stream is false
+	reqBody := strings.Replace(string(readFixture(t, "ollama-chat-request.json")), `"stream": false,`, "", 1)
+	resp, err := http.Post(proxyServer.URL+"/api/chat", "application/json", strings.NewReader(reqBody))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if upstreamPath != "/v1/chat/completions" {
+		t.Errorf("upstream path = %s, want /v1/chat/completions", upstreamPath)
+	}
+	if upstreamBody["stream"] != true || upstreamBody["temperature"] != 0.7 || upstreamBody["options"] != nil {
+		t.Errorf("unexpected upstream body: %v", upstreamBody)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("Content-Type = %s, want application/x-ndjson", ct)
+	}
+
+	var chunks []map[string]any
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var chunk map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &chunk); err != nil {
+			t.Fatalf("client received non-NDJSON line %q", scanner.Text())
+		}
+		chunks = append(chunks, chunk)
+	}
+
+	if len(chunks) != 2 {
+		t.Fatalf("expected content chunk and done chunk, got %d: %v", len(chunks), chunks)
+	}
+	if msg := chunks[0]["message"].(map[string]any); msg["content"] != "Hello" || chunks[0]["done"] != false {
+		t.Errorf("unexpected content chunk: %v", chunks[0])
+	}
+	if chunks[1]["done"] != true || chunks[1]["done_reason"] != "stop" {
+		t.Errorf("unexpected final chunk: %v", chunks[1])
+	}
+}
+
+func TestTranslateOpenAIClientToOllama(t *testing.T) {
+	var upstreamBody map[string]any
+	backend, closeBackend := newSafeTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api/chat":
+			json.NewDecoder(r.Body).Decode(&upstreamBody)
+			w.Write(readFixture(t, "ollama-chat-response-tools.json"))
+		case "/api/tags":
+			w.Write(readFixture(t, "ollama-tags-response.json"))
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	if backend == nil {
+		return
+	}
+	defer closeBackend()
+
+	proxyServer := newTranslatingProxy(t, backend.URL, config.TranslateOpenAIToOllama)
+	defer proxyServer.Close()
+
+	resp, err := http.Post(proxyServer.URL+"/v1/chat/completions", "application/json", strings.NewReader(string(readFixture(t, "openai-chat-request-tools.json"))))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if upstreamBody["stream"] != false || upstreamBody["tools"] == nil {
+		t.Errorf("unexpected upstream body: %v", upstreamBody)
+	}
+
+	var completion map[string]any
+	if err := json.Unmarshal(body, &completion); err != nil {
+		t.Fatalf("Failed to decode response %s: %v", body, err)
+	}
+	choice := completion["choices"].([]any)[0].(map[string]any)
+	if choice["finish_reason"] != "tool_calls" {
+		t.Errorf("finish_reason = %v, want tool_calls", choice["finish_reason"])
+	}
+	call := choice["message"].(map[string]any)["tool_calls"].([]any)[0].(map[string]any)
+	fn := call["function"].(map[string]any)
+	if fn["name"] != "get_current_weather" || fn["arguments"] != `{"format":"celsius","location":"Paris, FR"}` {
+		t.Errorf("unexpected tool call: %v", call)
+	}
+	if usage := completion["usage"].(map[string]any); usage["total_tokens"] != 155.0 {
+		t.Errorf("unexpected usage: %v", usage)
+	}
+
+	resp, err = http.Get(proxyServer.URL + "/v1/models")
+	if err != nil {
+		t.Fatalf("Models request failed: %v", err)
+	}
+	var models map[string]any
+	json.NewDecoder(resp.Body).Decode(&models)
+	resp.Body.Close()
+
+	data := models["data"].([]any)
+	if models["object"] != "list" || len(data) != 2 || data[0].(map[string]any)["id"] != "codellama:13b" {
+		t.Errorf("unexpected models response: %v", models)
+	}
+}
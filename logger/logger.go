@@ -2,6 +2,7 @@ package logger
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"strings"
@@ -47,6 +48,11 @@ func IsDebug() bool {
 	return currentLevel >= LevelDebug
 }
 
+// SetOutput redirects log lines, ex: to stderr for commands that print a report on stdout.
+func SetOutput(w io.Writer) {
+	stdLogger.SetOutput(w)
+}
+
 // Info logs informational messages.
 func Info(msg string, kv ...any) {
 	logWithLevel("INFO", msg, kv...)
@@ -1,17 +1,21 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"crypto/tls"
 	"flag"
 	"fmt"
 	"net"
 	"net/http"
 	"net/http/httputil"
-	"net/url"
 	"os"
 	"os/signal"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -35,8 +39,27 @@ func (c *configFiles) Set(value string) error {
 
 // ProxyServer tracks a running proxy server
 type ProxyServer struct {
-	server *http.Server
-	config config.ProxyConfig
+	server    *http.Server
+	config    config.ProxyConfig
+	handler   *proxyHandler
+	tlsDigest []byte
+}
+
+// proxyHandler serves requests with whichever route table is current
+// Each request loads the state once, so in-flight responses finish on the config they started with.
+type proxyHandler struct {
+	state atomic.Pointer[proxyState]
+}
+
+// proxyState is everything a reload can swap without rebinding the listener
+type proxyState struct {
+	proxy     *httputil.ReverseProxy
+	transport *http.Transport
+	balancer  *proxy.Balancer
+}
+
+func (h *proxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.state.Load().proxy.ServeHTTP(w, r)
 }
 
 type fileWatcher interface {
@@ -76,9 +99,9 @@ var (
 		}
 		return &realWatcher{Watcher: w}, nil
 	}
-	startAllProxiesFn func(cfg *config.Config) error = startAllProxies
-	stopAllProxiesFn  func()                         = stopAllProxies
-	reloadConfigFn    func()
+	startAllProxiesFn  func(cfg *config.Config) error = startAllProxies
+	updateAllProxiesFn func(cfg *config.Config) error = updateAllProxies
+	reloadConfigFn     func()
 )
 
 func init() {
@@ -86,6 +109,17 @@ func init() {
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "check":
+			os.Exit(runCheck(os.Args[2:], os.Stdout, os.Stderr))
+		case "explain":
+			os.Exit(runExplain(os.Args[2:], os.Stdout, os.Stderr))
+		case "test":
+			os.Exit(runTests(os.Args[2:], os.Stdout, os.Stderr))
+		}
+	}
+
 	var (
 		listenAddr = flag.String("listen", "", "Address to listen on (ex: localhost:8081)")
 		targetURL  = flag.String("target", "", "Target URL to proxy to (ex: http://localhost:8080)")
@@ -108,6 +142,9 @@ func main() {
 		fmt.Println("llama-matchmaker: Match LLM requests to transform settings / responses")
 		fmt.Println()
 		fmt.Println("Usage: llama-matchmaker -config <config.yml> [-config <routes.yml> ...]")
+		fmt.Println("       llama-matchmaker check -config <config.yml> [-strict]")
+		fmt.Println("       llama-matchmaker explain -config <config.yml> -path <path> [-body req.json] [-response resp.json]")
+		fmt.Println("       llama-matchmaker test [-config <config.yml>] <tests.yml> [<tests.yml> ...]")
 		fmt.Println()
 		fmt.Println("Options:")
 		fmt.Println("  -config, -c string")
@@ -195,17 +232,22 @@ func CreateServer(cfg config.ProxyConfig, handler http.Handler) *http.Server {
 	return server
 }
 
-func startProxy(proxyCfg config.ProxyConfig) (*ProxyServer, error) {
-	targetURLParsed, err := url.Parse(proxyCfg.Target)
-	if err != nil {
-		return nil, fmt.Errorf("invalid target URL: %w", err)
+// newProxyState builds the handler state for a proxy config
+// A non-nil balancer is reused so upstream health and in-flight counts survive reloads.
+func newProxyState(proxyCfg config.ProxyConfig, balancer *proxy.Balancer) (*proxyState, error) {
+	if balancer == nil {
+		var err error
+		if balancer, err = proxy.NewBalancer(proxyCfg); err != nil {
+			return nil, fmt.Errorf("invalid target URL: %w", err)
+		}
 	}
 
-	reverseProxy := httputil.NewSingleHostReverseProxy(targetURLParsed)
+	reverseProxy := &httputil.ReverseProxy{}
 	reverseProxy.ErrorHandler = func(rw http.ResponseWriter, req *http.Request, err error) {
+		balancer.Fail(req)
 		logger.Error("Reverse proxy error",
 			"listen", proxyCfg.Listen,
-			"target_host", targetURLParsed.Host,
+			"target", proxy.RedactedUpstreamFor(req),
 			"method", req.Method,
 			"path", req.URL.Path,
 			"err", err)
@@ -227,32 +269,49 @@ func startProxy(proxyCfg config.ProxyConfig) (*ProxyServer, error) {
 		transport.ResponseHeaderTimeout = proxyCfg.Timeout
 	}
 
-	reverseProxy.Transport = transport
+	reverseProxy.Transport = proxy.NewRetryTransport(transport, proxyCfg)
 
-	originalDirector := reverseProxy.Director
 	reverseProxy.Director = func(req *http.Request) {
-		originalDirector(req)
+		balancer.Direct(req)
 		proxy.ModifyRequest(req, proxyCfg.Routes)
 	}
 
 	reverseProxy.ModifyResponse = func(resp *http.Response) error {
+		balancer.Track(resp)
 		return proxy.ModifyResponse(resp, proxyCfg.Routes)
 	}
 
-	server := CreateServer(proxyCfg, reverseProxy)
+	return &proxyState{proxy: reverseProxy, transport: transport, balancer: balancer}, nil
+}
+
+func startProxy(proxyCfg config.ProxyConfig) (*ProxyServer, error) {
+	state, err := newProxyState(proxyCfg, nil)
+	if err != nil {
+		return nil, err
+	}
+	return serveProxy(proxyCfg, state), nil
+}
+
+// serveProxy starts a listener for a proxy config with already built handler state
+func serveProxy(proxyCfg config.ProxyConfig, state *proxyState) *ProxyServer {
+	handler := &proxyHandler{}
+	handler.state.Store(state)
+	server := CreateServer(proxyCfg, handler)
 
 	ps := &ProxyServer{
-		server: server,
-		config: proxyCfg,
+		server:    server,
+		config:    proxyCfg,
+		handler:   handler,
+		tlsDigest: tlsDigest(proxyCfg),
 	}
 
 	logListen := proxyCfg.Listen
 	if proxyCfg.SSLCert != "" && proxyCfg.SSLKey != "" {
 		logListen = "https://" + logListen
-		logger.Info("Starting HTTPS proxy", "listen", logListen, "target", proxyCfg.Target)
+		logger.Info("Starting HTTPS proxy", "listen", logListen, "target", targetsString(proxyCfg))
 	} else {
 		logListen = "http://" + logListen
-		logger.Info("Starting HTTP proxy", "listen", logListen, "target", proxyCfg.Target)
+		logger.Info("Starting HTTP proxy", "listen", logListen, "target", targetsString(proxyCfg))
 	}
 
 	go func() {
@@ -267,7 +326,62 @@ func startProxy(proxyCfg config.ProxyConfig) (*ProxyServer, error) {
 		}
 	}()
 
-	return ps, nil
+	return ps
+}
+
+// swapState builds the state a running listener will switch to
+// The listener's balancer is kept when the new config balances over the same targets.
+func swapState(ps *ProxyServer, proxyCfg config.ProxyConfig) (*proxyState, error) {
+	var balancer *proxy.Balancer
+	if sameUpstreams(ps.config, proxyCfg) {
+		balancer = ps.handler.state.Load().balancer
+	}
+	return newProxyState(proxyCfg, balancer)
+}
+
+// swapProxy points a running listener at a new route table and upstreams
+// Requests already in flight keep the state they loaded.
+func swapProxy(ps *ProxyServer, proxyCfg config.ProxyConfig, state *proxyState) {
+	old := ps.handler.state.Swap(state)
+	ps.config = proxyCfg
+	// Only idle connections are closed; active ones finish on the old transport
+	old.transport.CloseIdleConnections()
+
+	logger.Info("Swapped proxy routes", "listen", proxyCfg.Listen, "target", targetsString(proxyCfg), "routes", len(proxyCfg.Routes))
+}
+
+// sameUpstreams reports whether a new config balances over the same targets the same way
+func sameUpstreams(old, updated config.ProxyConfig) bool {
+	return slices.Equal(old.TargetList(), updated.TargetList()) &&
+		old.Balance == updated.Balance &&
+		old.Health == updated.Health
+}
+
+// sameListener reports whether a running server can keep its socket for a new config
+// Timeout is included because it sets the server's idle timeout.
+func sameListener(ps *ProxyServer, proxyCfg config.ProxyConfig) bool {
+	return ps.config.Listen == proxyCfg.Listen &&
+		ps.config.SSLCert == proxyCfg.SSLCert &&
+		ps.config.SSLKey == proxyCfg.SSLKey &&
+		ps.config.Timeout == proxyCfg.Timeout &&
+		bytes.Equal(ps.tlsDigest, tlsDigest(proxyCfg))
+}
+
+// tlsDigest hashes a proxy's certificate and key files so rotated certs restart the listener
+func tlsDigest(proxyCfg config.ProxyConfig) []byte {
+	if proxyCfg.SSLCert == "" || proxyCfg.SSLKey == "" {
+		return nil
+	}
+	h := sha256.New()
+	for _, file := range []string{proxyCfg.SSLCert, proxyCfg.SSLKey} {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			// Unreadable files never match, forcing a restart that reports the error
+			return []byte(err.Error())
+		}
+		h.Write(data)
+	}
+	return h.Sum(nil)
 }
 
 func stopProxy(ps *ProxyServer) {
@@ -304,15 +418,7 @@ func startAllProxies(cfg *config.Config) error {
 	serversMutex.Lock()
 	defer serversMutex.Unlock()
 
-	debugEnabled := false
-	for _, proxyCfg := range cfg.Proxies {
-		if proxyCfg.Debug {
-			debugEnabled = true
-			break
-		}
-	}
-	logger.EnableDebug(debugEnabled)
-
+	applyDebug(cfg)
 	logResolvedConfig(cfg)
 
 	for i, proxyCfg := range cfg.Proxies {
@@ -328,6 +434,88 @@ func startAllProxies(cfg *config.Config) error {
 	return nil
 }
 
+// updateAllProxies applies a reloaded config to the running proxies
+// Listeners with unchanged listen/TLS settings keep their sockets and swap routes in place;
+// only changed or removed listeners are shut down, and new ones are started.
+func updateAllProxies(cfg *config.Config) error {
+	serversMutex.Lock()
+	defer serversMutex.Unlock()
+
+	applyDebug(cfg)
+	logResolvedConfig(cfg)
+
+	running := make(map[string]*ProxyServer, len(runningServers))
+	for _, ps := range runningServers {
+		running[ps.config.Listen] = ps
+	}
+
+	// Build every new state before touching a listener, so a failed reload changes none of them
+	type proxyUpdate struct {
+		ps    *ProxyServer // nil for listeners to start
+		cfg   config.ProxyConfig
+		state *proxyState
+	}
+	var swaps, pending []proxyUpdate
+	for _, proxyCfg := range cfg.Proxies {
+		ps := running[proxyCfg.Listen]
+		if ps == nil || !sameListener(ps, proxyCfg) {
+			state, err := newProxyState(proxyCfg, nil)
+			if err != nil {
+				return err
+			}
+			pending = append(pending, proxyUpdate{cfg: proxyCfg, state: state})
+			continue
+		}
+		state, err := swapState(ps, proxyCfg)
+		if err != nil {
+			return err
+		}
+		swaps = append(swaps, proxyUpdate{ps: ps, cfg: proxyCfg, state: state})
+		delete(running, proxyCfg.Listen)
+	}
+
+	kept := make([]*ProxyServer, 0, len(swaps))
+	for _, u := range swaps {
+		swapProxy(u.ps, u.cfg, u.state)
+		kept = append(kept, u.ps)
+	}
+
+	// Stop changed and removed listeners before rebinding, since a changed listener may reuse its address
+	if len(running) > 0 {
+		var wg sync.WaitGroup
+		for _, ps := range running {
+			wg.Add(1)
+			go func(p *ProxyServer) {
+				defer wg.Done()
+				stopProxy(p)
+			}(ps)
+		}
+		wg.Wait()
+
+		// Give OS time to fully release the ports
+		time.Sleep(100 * time.Millisecond)
+	}
+	runningServers = kept
+
+	for _, u := range pending {
+		runningServers = append(runningServers, serveProxy(u.cfg, u.state))
+	}
+
+	logger.Debug("Proxies updated", "kept", len(kept), "restarted", len(pending))
+	return nil
+}
+
+func applyDebug(cfg *config.Config) {
+	debugEnabled := false
+	for _, proxyCfg := range cfg.Proxies {
+		if proxyCfg.Debug {
+			debugEnabled = true
+			break
+		}
+	}
+	logger.EnableDebug(debugEnabled)
+}
+
 func logResolvedConfig(cfg *config.Config) {
 	if !logger.IsDebug() {
 		return
@@ -351,9 +539,11 @@ func logResolvedConfig(cfg *config.Config) {
 			respOps += len(r.OnResponse)
 		}
 
+		// Values may come from secret files; keep them out of the logs
 		logger.Debug(fmt.Sprintf("Proxy %d configured", i+1),
-			"listen", logListen,
-			"target", p.Target,
+			"listen", cfg.Redact(logListen),
+			"target", cfg.Redact(targetsString(p)),
+			"balance", p.Balance,
 			"timeout", p.Timeout,
 			"routes", len(p.Routes),
 			"request_actions", reqOps,
@@ -367,6 +557,21 @@ func logResolvedConfig(cfg *config.Config) {
 
 }
 
+// targetsString renders a proxy's upstream URLs for logging, masking passwords and query values
+func targetsString(p config.ProxyConfig) string {
+	targets := p.TargetList()
+	urls := make([]string, 0, len(targets))
+	for _, t := range targets {
+		target := config.RedactURL(t.URL)
+		if t.Weight > 0 {
+			urls = append(urls, fmt.Sprintf("%s (weight %d)", target, t.Weight))
+		} else {
+			urls = append(urls, target)
+		}
+	}
+	return strings.Join(urls, ", ")
+}
+
 func setupFileWatcher(watchedFiles []string) (fileWatcher, error) {
 	watcher, err := watchFactory()
 	if err != nil {
@@ -456,11 +661,9 @@ func reloadConfig() {
 
 	logger.Info("Successfully loaded new config")
 
-	stopAllProxiesFn()
-
-	if err := startAllProxiesFn(newCfg); err != nil {
-		logger.Error("Failed to start proxies with new config, attempting to restore previous config", "err", err)
-		if err := startAllProxiesFn(currentConfig); err != nil {
+	if err := updateAllProxiesFn(newCfg); err != nil {
+		logger.Error("Failed to apply new config, attempting to restore previous config", "err", err)
+		if err := updateAllProxiesFn(currentConfig); err != nil {
 			logger.Fatal("Failed to restore previous config", "err", err)
 		}
 		logger.Info("Restored previous config")
@@ -1,9 +1,16 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
 	"testing"
 	"time"
 
@@ -128,8 +135,7 @@ proxy:
 	origWatcher := configWatcher
 	origPaths := configPaths
 	origOverrides := overrides
-	origStart := startAllProxiesFn
-	origStop := stopAllProxiesFn
+	origUpdate := updateAllProxiesFn
 	origReload := reloadConfigFn
 
 	defer func() {
@@ -137,12 +143,13 @@ proxy:
 		configWatcher = origWatcher
 		configPaths = origPaths
 		overrides = origOverrides
-		startAllProxiesFn = origStart
-		stopAllProxiesFn = origStop
+		updateAllProxiesFn = origUpdate
 		reloadConfigFn = origReload
+		reloadMutex.Lock()
 		if reloadTimer != nil {
 			reloadTimer.Stop()
 		}
+		reloadMutex.Unlock()
 		closeWatcher()
 	}()
 
@@ -153,8 +160,7 @@ proxy:
 		return fw, nil
 	}
 
-	startAllProxiesFn = func(*config.Config) error { return nil }
-	stopAllProxiesFn = func() {}
+	updateAllProxiesFn = func(*config.Config) error { return nil }
 	trigger := make(chan struct{})
 	reloadConfigFn = func() {
 		select {
@@ -197,6 +203,208 @@ proxy:
 	}
 }
 
+func TestUpdateAllProxiesSwapsRoutesInPlace(t *testing.T) {
+	release := make(chan struct{})
+	arrived := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.URL.Path == "/slow" {
+			close(arrived)
+			<-release
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}))
+	defer backend.Close()
+
+	listen := freeAddr(t)
+	configFor := func(version int, listen string) *config.Config {
+		cfg := &config.Config{Proxies: config.ProxyEntries{{
+			Listen: listen,
+			Target: backend.URL,
+			Routes: []config.Route{{
+				Methods:   newPatternField("POST"),
+				Paths:     newPatternField(".*"),
+				OnRequest: []config.Action{{Merge: map[string]any{"version": version}}},
+			}},
+		}}}
+		if err := config.CompileTemplates(cfg); err != nil {
+			t.Fatalf("CompileTemplates: %v", err)
+		}
+		return cfg
+	}
+
+	origServers := runningServers
+	runningServers = nil
+	defer func() {
+		stopAllProxies()
+		runningServers = origServers
+	}()
+
+	if err := startAllProxies(configFor(1, listen)); err != nil {
+		t.Fatalf("startAllProxies: %v", err)
+	}
+	server := runningServers[0].server
+	waitForListener(t, listen)
+
+	// A request started on the old routes finishes on them
+	slow := make(chan string, 1)
+	go func() { slow <- postVersion(t, "http://"+listen+"/slow") }()
+	select {
+	case <-arrived:
+	case <-time.After(2 * time.Second):
+		t.Fatal("slow request never reached the backend")
+	}
+
+	balancer := runningServers[0].handler.state.Load().balancer
+	if err := updateAllProxies(configFor(2, listen)); err != nil {
+		t.Fatalf("updateAllProxies: %v", err)
+	}
+	if runningServers[0].server != server {
+		t.Fatal("expected unchanged listener to keep its server")
+	}
+	// Unchanged targets keep their health and in-flight counts
+	if runningServers[0].handler.state.Load().balancer != balancer {
+		t.Fatal("expected unchanged targets to keep their balancer")
+	}
+	if got := postVersion(t, "http://"+listen+"/fast"); got != "2" {
+		t.Fatalf("new request version = %s, want 2", got)
+	}
+
+	close(release)
+	if got := <-slow; got != "1" {
+		t.Fatalf("in-flight request version = %s, want 1", got)
+	}
+
+	// A changed listen address restarts the listener
+	moved := freeAddr(t)
+	if err := updateAllProxies(configFor(3, moved)); err != nil {
+		t.Fatalf("updateAllProxies: %v", err)
+	}
+	if len(runningServers) != 1 || runningServers[0].server == server {
+		t.Fatal("expected changed listener to be restarted")
+	}
+	waitForListener(t, moved)
+	if got := postVersion(t, "http://"+moved+"/fast"); got != "3" {
+		t.Fatalf("moved listener version = %s, want 3", got)
+	}
+}
+
+func TestUpdateAllProxiesKeepsEveryListenerOnFailedReload(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}))
+	defer backend.Close()
+
+	first, second := freeAddr(t), freeAddr(t)
+	configFor := func(version int, secondTarget string) *config.Config {
+		cfg := &config.Config{}
+		for _, p := range []struct{ listen, target string }{{first, backend.URL}, {second, secondTarget}} {
+			cfg.Proxies = append(cfg.Proxies, config.ProxyConfig{
+				Listen: p.listen,
+				Target: p.target,
+				Routes: []config.Route{{
+					Methods:   newPatternField("POST"),
+					Paths:     newPatternField(".*"),
+					OnRequest: []config.Action{{Merge: map[string]any{"version": version}}},
+				}},
+			})
+		}
+		if err := config.CompileTemplates(cfg); err != nil {
+			t.Fatalf("CompileTemplates: %v", err)
+		}
+		return cfg
+	}
+
+	origServers := runningServers
+	runningServers = nil
+	defer func() {
+		stopAllProxies()
+		runningServers = origServers
+	}()
+
+	if err := startAllProxies(configFor(1, backend.URL)); err != nil {
+		t.Fatalf("startAllProxies: %v", err)
+	}
+	waitForListener(t, first)
+
+	// The second proxy's target can't be parsed, so the first must not swap either
+	if err := updateAllProxies(configFor(2, "http://[::1")); err == nil {
+		t.Fatal("expected reload with an invalid target to fail")
+	}
+	if got := postVersion(t, "http://"+first+"/fast"); got != "1" {
+		t.Fatalf("first listener version = %s, want 1 after failed reload", got)
+	}
+	if len(runningServers) != 2 || runningServers[1].config.Target != backend.URL {
+		t.Fatalf("expected both listeners to keep their config, got %d servers", len(runningServers))
+	}
+}
+
+func TestSameUpstreams(t *testing.T) {
+	base := config.ProxyConfig{Target: "http://a:1"}
+	tests := []struct {
+		name    string
+		updated config.ProxyConfig
+		want    bool
+	}{
+		{name: "same target", updated: config.ProxyConfig{Target: "http://a:1", Routes: []config.Route{{}}}, want: true},
+		{name: "target as list", updated: config.ProxyConfig{Targets: []config.TargetConfig{{URL: "http://a:1"}}}, want: true},
+		{name: "new target", updated: config.ProxyConfig{Target: "http://b:1"}, want: false},
+		{name: "new strategy", updated: config.ProxyConfig{Target: "http://a:1", Balance: config.BalanceLeastRequests}, want: false},
+		{name: "new health", updated: config.ProxyConfig{Target: "http://a:1", Health: config.HealthConfig{MaxFailures: 5}}, want: false},
+	}
+	for _, tt := range tests {
+		if got := sameUpstreams(base, tt.updated); got != tt.want {
+			t.Errorf("%s: sameUpstreams = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("Skipping test: unable to bind (%v)", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	return addr
+}
+
+func waitForListener(t *testing.T, addr string) {
+	t.Helper()
+	for range 50 {
+		if conn, err := net.Dial("tcp", addr); err == nil {
+			conn.Close()
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("listener %s did not start", addr)
+}
+
+func postVersion(t *testing.T, url string) string {
+	resp, err := http.Post(url, "application/json", strings.NewReader(`{}`))
+	if err != nil {
+		t.Errorf("POST %s: %v", url, err)
+		return ""
+	}
+	defer resp.Body.Close()
+	var body map[string]any
+	json.NewDecoder(resp.Body).Decode(&body)
+	return fmt.Sprint(body["version"])
+}
+
+func newPatternField(patterns ...string) config.PatternField {
+	pf := config.PatternField{Patterns: patterns}
+	for _, pattern := range patterns {
+		pf.Compiled = append(pf.Compiled, regexp.MustCompile("(?i)"+pattern))
+	}
+	return pf
+}
+
 func TestCreateServerTimeouts(t *testing.T) {
 	tests := []struct {
 		name      string
@@ -0,0 +1,289 @@
+package proxy
+
+import (
+	"strings"
+
+	"github.com/spicyneuron/llama-matchmaker/logger"
+)
+
+// streamAccumulator assembles the complete message from OpenAI or Ollama stream chunks
+// Chunks are recorded as the client sees them, after on_response actions.
+type streamAccumulator struct {
+	chunks int
+	ollama bool
+	// Set for /api/generate and legacy /v1/completions streams, which carry plain text
+	completion bool
+
+	id        any
+	model     any
+	created   any
+	createdAt any
+	role      string
+	content   strings.Builder
+	reasoning strings.Builder
+	toolCalls []map[string]any
+	finish    any
+	usage     map[string]any
+
+	// Trailing Ollama stats from the done chunk (durations, eval counts)
+	stats map[string]any
+}
+
+// add folds one chunk into the assembled message
+// Chunks in neither format only count toward the total.
+func (a *streamAccumulator) add(chunk map[string]any) {
+	a.chunks++
+
+	if choices, ok := chunk["choices"].([]any); ok {
+		a.addOpenAI(chunk, choices)
+		return
+	}
+	if _, ok := chunk["message"].(map[string]any); ok {
+		a.addOllama(chunk)
+		return
+	}
+	if _, ok := chunk["response"].(string); ok {
+		a.completion = true
+		a.addOllama(chunk)
+		return
+	}
+	// OpenAI usage arrives in a final chunk with no choices
+	if usage, ok := chunk["usage"].(map[string]any); ok {
+		a.usage = usage
+	}
+}
+
+func (a *streamAccumulator) addOpenAI(chunk map[string]any, choices []any) {
+	setIfPresent(&a.id, chunk["id"])
+	setIfPresent(&a.model, chunk["model"])
+	setIfPresent(&a.created, chunk["created"])
+	if usage, ok := chunk["usage"].(map[string]any); ok {
+		a.usage = usage
+	}
+
+	for _, raw := range choices {
+		choice, _ := raw.(map[string]any)
+		// Only the first choice is assembled; n>1 streams are rare in practice
+		if index, ok := toInt64(choice["index"]); ok && index != 0 {
+			continue
+		}
+		if text, ok := choice["text"].(string); ok {
+			a.completion = true
+			a.content.WriteString(text)
+		}
+		delta, _ := choice["delta"].(map[string]any)
+		if role := stringValue(delta["role"]); role != "" {
+			a.role = role
+		}
+		a.content.WriteString(stringValue(delta["content"]))
+		a.reasoning.WriteString(stringValue(delta["reasoning_content"]))
+		if calls, ok := delta["tool_calls"].([]any); ok {
+			a.toolCalls = mergeToolCallDeltas(a.toolCalls, calls)
+		}
+		setIfPresent(&a.finish, choice["finish_reason"])
+	}
+}
+
+func (a *streamAccumulator) addOllama(chunk map[string]any) {
+	a.ollama = true
+	setIfPresent(&a.model, chunk["model"])
+	setIfPresent(&a.createdAt, chunk["created_at"])
+
+	if a.completion {
+		a.content.WriteString(stringValue(chunk["response"]))
+		a.reasoning.WriteString(stringValue(chunk["thinking"]))
+	}
+	message, _ := chunk["message"].(map[string]any)
+	if role := stringValue(message["role"]); role != "" {
+		a.role = role
+	}
+	a.content.WriteString(stringValue(message["content"]))
+	a.reasoning.WriteString(stringValue(message["thinking"]))
+	if calls, ok := message["tool_calls"].([]any); ok {
+		for _, call := range calls {
+			if c, ok := call.(map[string]any); ok {
+				a.toolCalls = append(a.toolCalls, c)
+			}
+		}
+	}
+
+	if done, _ := chunk["done"].(bool); done {
+		a.stats = make(map[string]any)
+		for k, v := range chunk {
+			if k != "message" && k != "response" && k != "thinking" && k != "model" && k != "created_at" {
+				a.stats[k] = v
+			}
+		}
+	}
+}
+
+// message returns the assembled response in the stream's own protocol
+// OpenAI streams become a chat.completion; Ollama streams become a final /api/chat response.
+// Completion streams assemble into their non-streaming text forms instead.
+func (a *streamAccumulator) message() map[string]any {
+	if a.completion {
+		return a.completionMessage()
+	}
+
+	role := a.role
+	if role == "" {
+		role = "assistant"
+	}
+	msg := map[string]any{"role": role, "content": a.content.String()}
+
+	if a.ollama {
+		if a.reasoning.Len() > 0 {
+			msg["thinking"] = a.reasoning.String()
+		}
+		if len(a.toolCalls) > 0 {
+			msg["tool_calls"] = toAnySlice(a.toolCalls)
+		}
+		out := map[string]any{"model": a.model, "created_at": a.createdAt, "message": msg, "done": true}
+		for k, v := range a.stats {
+			out[k] = v
+		}
+		return out
+	}
+
+	if a.reasoning.Len() > 0 {
+		msg["reasoning_content"] = a.reasoning.String()
+	}
+	if len(a.toolCalls) > 0 {
+		msg["tool_calls"] = toAnySlice(a.toolCalls)
+	}
+	out := map[string]any{
+		"id":      a.id,
+		"object":  "chat.completion",
+		"created": a.created,
+		"model":   a.model,
+		"choices": []any{map[string]any{"index": 0, "message": msg, "finish_reason": a.finish}},
+	}
+	if a.usage != nil {
+		out["usage"] = a.usage
+	}
+	return out
+}
+
+func (a *streamAccumulator) completionMessage() map[string]any {
+	if a.ollama {
+		out := map[string]any{"model": a.model, "created_at": a.createdAt, "response": a.content.String(), "done": true}
+		if a.reasoning.Len() > 0 {
+			out["thinking"] = a.reasoning.String()
+		}
+		for k, v := range a.stats {
+			out[k] = v
+		}
+		return out
+	}
+
+	out := map[string]any{
+		"id":      a.id,
+		"object":  "text_completion",
+		"created": a.created,
+		"model":   a.model,
+		"choices": []any{map[string]any{"index": 0, "text": a.content.String(), "finish_reason": a.finish}},
+	}
+	if a.usage != nil {
+		out["usage"] = a.usage
+	}
+	return out
+}
+
+// finishReason returns the OpenAI finish_reason or Ollama done_reason seen in the stream
+func (a *streamAccumulator) finishReason() string {
+	if a.ollama {
+		return stringValue(a.stats["done_reason"])
+	}
+	return stringValue(a.finish)
+}
+
+// mergeToolCallDeltas folds streamed OpenAI tool call fragments into whole calls by index
+// Names and arguments arrive in pieces and are concatenated; ids and types are kept from the first fragment.
+// Indices must be non-negative and can only run a few calls ahead, so a malformed chunk can't grow the list unbounded.
+func mergeToolCallDeltas(calls []map[string]any, deltas []any) []map[string]any {
+	limit := len(calls) + len(deltas)
+	for i, raw := range deltas {
+		delta, _ := raw.(map[string]any)
+		index := i
+		if n, ok := toInt64(delta["index"]); ok {
+			if n < 0 || n > int64(limit) {
+				logger.Debug("Dropping tool call delta with out of range index", "index", n, "calls", len(calls))
+				continue
+			}
+			index = int(n)
+		}
+		for len(calls) <= index {
+			calls = append(calls, map[string]any{
+				"type":     "function",
+				"function": map[string]any{"name": "", "arguments": ""},
+			})
+		}
+
+		call := calls[index]
+		if id := stringValue(delta["id"]); id != "" {
+			call["id"] = id
+		}
+		fn := call["function"].(map[string]any)
+		deltaFn, _ := delta["function"].(map[string]any)
+		fn["name"] = stringValue(fn["name"]) + stringValue(deltaFn["name"])
+		fn["arguments"] = stringValue(fn["arguments"]) + stringValue(deltaFn["arguments"])
+	}
+	return calls
+}
+
+func setIfPresent(dst *any, v any) {
+	if v != nil {
+		*dst = v
+	}
+}
+
+func toAnySlice(items []map[string]any) []any {
+	out := make([]any, len(items))
+	for i, item := range items {
+		out[i] = item
+	}
+	return out
+}
+
+// isContentKey reports whether a field of the assembled message holds content the client already streamed
+func (a *streamAccumulator) isContentKey(key string) bool {
+	if a.ollama {
+		return key == "message" || key == "response" || key == "thinking" || key == "done"
+	}
+	return key == "object" || key == "choices"
+}
+
+// finalChunk wraps on_stream_end changes in one more chunk of the stream's protocol
+// OpenAI streams get a choice-less chunk (like the usage chunk); Ollama ones a done chunk with no content.
+func (a *streamAccumulator) finalChunk(changes map[string]any) map[string]any {
+	out := make(map[string]any)
+	if a.ollama {
+		setIfPresentKey(out, "model", a.model)
+		setIfPresentKey(out, "created_at", a.createdAt)
+		if a.completion {
+			out["response"] = ""
+		} else {
+			out["message"] = map[string]any{"role": "assistant", "content": ""}
+		}
+		out["done"] = true
+	} else {
+		setIfPresentKey(out, "id", a.id)
+		out["object"] = "chat.completion.chunk"
+		if a.completion {
+			out["object"] = "text_completion"
+		}
+		setIfPresentKey(out, "created", a.created)
+		setIfPresentKey(out, "model", a.model)
+		out["choices"] = []any{}
+	}
+	for k, v := range changes {
+		out[k] = v
+	}
+	return out
+}
+
+func setIfPresentKey(dst map[string]any, key string, v any) {
+	if v != nil {
+		dst[key] = v
+	}
+}
@@ -0,0 +1,114 @@
+package proxy
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestStreamAccumulatorOpenAI(t *testing.T) {
+	chunks := []string{
+		`{"id":"chatcmpl-1","created":1700000000,"model":"gpt","choices":[{"index":0,"delta":{"role":"assistant","content":"Hel"}}]}`,
+		`{"id":"chatcmpl-1","model":"gpt","choices":[{"index":0,"delta":{"content":"lo"}}]}`,
+		`{"id":"chatcmpl-1","model":"gpt","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_a","type":"function","function":{"name":"get_","arguments":"{\"ci"}}]}}]}`,
+		`{"id":"chatcmpl-1","model":"gpt","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"name":"weather","arguments":"ty\":\"Oslo\"}"}}]}}]}`,
+		`{"id":"chatcmpl-1","model":"gpt","choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}`,
+		`{"id":"chatcmpl-1","model":"gpt","choices":[],"usage":{"prompt_tokens":3,"completion_tokens":5}}`,
+	}
+
+	acc := &streamAccumulator{}
+	for _, c := range chunks {
+		acc.add(decodeChunk(t, c))
+	}
+
+	got := roundTrip(t, acc.message())
+	want := decodeChunk(t, `{
+		"id": "chatcmpl-1",
+		"object": "chat.completion",
+		"created": 1700000000,
+		"model": "gpt",
+		"choices": [{
+			"index": 0,
+			"finish_reason": "tool_calls",
+			"message": {
+				"role": "assistant",
+				"content": "Hello",
+				"tool_calls": [{"id": "call_a", "type": "function", "function": {"name": "get_weather", "arguments": "{\"city\":\"Oslo\"}"}}]
+			}
+		}],
+		"usage": {"prompt_tokens": 3, "completion_tokens": 5}
+	}`)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("message = %v\nwant %v", got, want)
+	}
+	if acc.chunks != len(chunks) || acc.finishReason() != "tool_calls" {
+		t.Errorf("chunks = %d, finish = %q", acc.chunks, acc.finishReason())
+	}
+}
+
+func TestStreamAccumulatorOllama(t *testing.T) {
+	chunks := []string{
+		`{"model":"llama","created_at":"2024-01-01T00:00:00Z","message":{"role":"assistant","content":"","thinking":"hmm"},"done":false}`,
+		`{"model":"llama","created_at":"2024-01-01T00:00:01Z","message":{"role":"assistant","content":"Hi"},"done":false}`,
+		`{"model":"llama","created_at":"2024-01-01T00:00:02Z","message":{"role":"assistant","content":"","tool_calls":[{"function":{"name":"f","arguments":{"x":1}}}]},"done":false}`,
+		`{"model":"llama","created_at":"2024-01-01T00:00:03Z","message":{"role":"assistant","content":""},"done":true,"done_reason":"stop","eval_count":4}`,
+	}
+
+	acc := &streamAccumulator{}
+	for _, c := range chunks {
+		acc.add(decodeChunk(t, c))
+	}
+
+	got := roundTrip(t, acc.message())
+	want := decodeChunk(t, `{
+		"model": "llama",
+		"created_at": "2024-01-01T00:00:03Z",
+		"message": {"role": "assistant", "content": "Hi", "thinking": "hmm", "tool_calls": [{"function": {"name": "f", "arguments": {"x": 1}}}]},
+		"done": true,
+		"done_reason": "stop",
+		"eval_count": 4
+	}`)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("message = %v\nwant %v", got, want)
+	}
+}
+
+func decodeChunk(t *testing.T, s string) map[string]any {
+	t.Helper()
+	var m map[string]any
+	if err := json.Unmarshal([]byte(s), &m); err != nil {
+		t.Fatalf("unmarshal %s: %v", s, err)
+	}
+	return m
+}
+
+// roundTrip normalizes a built map to what encoding/json would produce
+func roundTrip(t *testing.T, m map[string]any) map[string]any {
+	t.Helper()
+	b, err := json.Marshal(m)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	return decodeChunk(t, string(b))
+}
+
+func TestMergeToolCallDeltasMalformedIndex(t *testing.T) {
+	calls := mergeToolCallDeltas(nil, []any{
+		map[string]any{"index": 0, "id": "call_a", "function": map[string]any{"name": "get_weather"}},
+	})
+
+	for _, index := range []any{-1, 1 << 40, 1e18} {
+		calls = mergeToolCallDeltas(calls, []any{
+			map[string]any{"index": index, "function": map[string]any{"arguments": "{}"}},
+		})
+	}
+	if len(calls) != 1 || calls[0]["function"].(map[string]any)["arguments"] != "" {
+		t.Fatalf("calls = %v, want out of range deltas dropped", calls)
+	}
+
+	// The next call's first fragment is still accepted
+	calls = mergeToolCallDeltas(calls, []any{map[string]any{"index": 1, "id": "call_b"}})
+	if len(calls) != 2 || calls[1]["id"] != "call_b" {
+		t.Fatalf("calls = %v, want a second call", calls)
+	}
+}
@@ -1,13 +1,17 @@
 package proxy
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"maps"
+	"net"
 	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
 	"strings"
 
 	"github.com/spicyneuron/llama-matchmaker/config"
@@ -16,11 +20,36 @@ import (
 
 type contextKey string
 
-const routeContextKey contextKey = "matched_route"
+const (
+	routeContextKey contextKey = "matched_route"
+	traceContextKey contextKey = "trace"
+)
 
 type responseRouteContext struct {
-	rules   []*config.Route
-	indices []int
+	rules      []*config.Route
+	indices    []int
+	translator *translator
+	// bridge is set when upstream_stream changed the response shape (see applyUpstreamStream)
+	bridge string
+	// vars holds values captured by actions, shared by every phase of the exchange
+	vars map[string]any
+	// request is the client request as it arrived, for response templates
+	request *config.RequestInfo
+}
+
+// WithTrace returns a context that reports every route and action considered for its requests
+// explain uses it to show how a request is handled without reading debug logs.
+func WithTrace(ctx context.Context, trace func(config.TraceStep)) context.Context {
+	return context.WithValue(ctx, traceContextKey, trace)
+}
+
+// traceFor returns the trace function attached to a request, or nil
+func traceFor(req *http.Request) func(config.TraceStep) {
+	if req == nil {
+		return nil
+	}
+	trace, _ := req.Context().Value(traceContextKey).(func(config.TraceStep))
+	return trace
 }
 
 func headersJSON(headers map[string][]string) string {
@@ -42,24 +71,36 @@ func headersJSON(headers map[string][]string) string {
 }
 
 // MatchRoutes returns matching routes and their indices in order.
+// Only misses are traced here: ModifyRequest traces each matched route once its
+// match_body/match_headers gates have been evaluated, so every route gets one outcome.
 func MatchRoutes(req *http.Request, routes []config.Route) ([]*config.Route, []int) {
 	logger.Debug("Evaluating routes for request", "route_count", len(routes), "method", req.Method, "path", req.URL.Path)
 
 	var matchedRoutes []*config.Route
 	var matchedIndices []int
 
+	host := requestHostname(req)
+	query := req.URL.Query()
+	trace := traceFor(req)
+
 	for i := range routes {
 		route := &routes[i]
 		methodMatch := route.Methods.Matches(req.Method)
 		pathMatch := route.Paths.Matches(req.URL.Path)
+		hostMatch := route.Hosts.Len() == 0 || route.Hosts.Matches(host)
+		queryMatch := matchQuery(route.Query, query)
 
-		logger.Debug("Route evaluation", "index", i, "methods", route.Methods.Patterns, "paths", route.Paths.Patterns, "method_match", methodMatch, "path_match", pathMatch)
+		logger.Debug("Route evaluation", "index", i, "methods", route.Methods.Patterns, "paths", route.Paths.Patterns, "method_match", methodMatch, "path_match", pathMatch, "host_match", hostMatch, "query_match", queryMatch)
 
-		if methodMatch && pathMatch {
+		matched := methodMatch && pathMatch && hostMatch && queryMatch
+		if matched {
 			logger.Debug("Route matched", "index", i)
 			matchedRoutes = append(matchedRoutes, route)
 			matchedIndices = append(matchedIndices, i)
 		}
+		if trace != nil && !matched {
+			trace(config.TraceStep{Phase: "route", Route: i, Action: -1, Reason: routeReason(route, req, host, query)})
+		}
 	}
 
 	if len(matchedRoutes) == 0 {
@@ -71,12 +112,83 @@ func MatchRoutes(req *http.Request, routes []config.Route) ([]*config.Route, []i
 	return matchedRoutes, matchedIndices
 }
 
+// routeReason explains a route's method, path, host and query match for tracing
+func routeReason(route *config.Route, req *http.Request, host string, query url.Values) string {
+	switch {
+	case !route.Methods.Matches(req.Method):
+		return fmt.Sprintf("method %s does not match %s", req.Method, route.Methods)
+	case !route.Paths.Matches(req.URL.Path):
+		return fmt.Sprintf("path %s does not match %s", req.URL.Path, route.Paths)
+	case route.Hosts.Len() > 0 && !route.Hosts.Matches(host):
+		return fmt.Sprintf("host %s does not match %s", host, route.Hosts)
+	}
+	for _, name := range sortedQueryNames(route.Query) {
+		if !matchQuery(map[string]config.PatternField{name: route.Query[name]}, query) {
+			return fmt.Sprintf("query '%s' %q does not match %s", name, query.Get(name), route.Query[name])
+		}
+	}
+	return matchedRouteReason(route, req.Method, req.URL.Path)
+}
+
+// matchedRouteReason describes a route whose method, path, host and query all matched
+func matchedRouteReason(route *config.Route, method, path string) string {
+	reason := fmt.Sprintf("%s %s matched methods %s and paths %s", method, path, route.Methods, route.Paths)
+	if len(route.MatchBody) > 0 || len(route.MatchHeaders) > 0 {
+		reason += ", and its match_body/match_headers"
+	}
+	return reason
+}
+
+func sortedQueryNames(criteria map[string]config.PatternField) []string {
+	names := make([]string, 0, len(criteria))
+	for name := range criteria {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// requestHostname returns the client's Host without its port
+func requestHostname(req *http.Request) string {
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
+
+// matchQuery reports whether every parameter has a value matching its patterns
+// Missing parameters only match conditions such as {exists: false}.
+func matchQuery(criteria map[string]config.PatternField, query url.Values) bool {
+	for name, pattern := range criteria {
+		values := make([]any, len(query[name]))
+		for i, value := range query[name] {
+			values[i] = value
+		}
+		if !pattern.MatchesValues(values) {
+			return false
+		}
+	}
+	return true
+}
+
 // FindMatchingRoutes returns all routes that match the request sequentially.
 func FindMatchingRoutes(req *http.Request, routes []config.Route) []*config.Route {
 	matchedRoutes, _ := MatchRoutes(req, routes)
 	return matchedRoutes
 }
 
+// setRequestBody installs a buffered body that can be replayed on retry
+func setRequestBody(req *http.Request, body []byte) {
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+}
+
 // ModifyRequest processes the request through rules sequentially
 // Each rule is checked and processed immediately before moving to the next rule
 func ModifyRequest(req *http.Request, routes []config.Route) {
@@ -117,7 +229,7 @@ func ModifyRequest(req *http.Request, routes []config.Route) {
 			if logger.IsDebug() {
 				logger.Debug("Request body is not JSON, passing through unchanged")
 			}
-			req.Body = io.NopCloser(bytes.NewReader(body))
+			setRequestBody(req, body)
 		}
 	}
 
@@ -128,32 +240,86 @@ func ModifyRequest(req *http.Request, routes []config.Route) {
 		}
 	}
 
+	upstream := UpstreamFor(req)
+	trace := traceFor(req)
 	matchedRoutes, matchedRouteIndices := MatchRoutes(req, routes)
-	var matchedResponseRoutes responseRouteContext
+	matchedResponseRoutes := responseRouteContext{vars: make(map[string]any)}
+	if len(matchedRoutes) > 0 {
+		matchedResponseRoutes.request = newRequestInfo(method, path, headers, body, hasJSONBody)
+	}
 	anyModified := false
 	allAppliedValues := make(map[string]any)
 
 	for idx, rule := range matchedRoutes {
 		routeIndex := matchedRouteIndices[idx]
 
+		// Gates see the body and headers as earlier routes left them
+		gate := &config.Exchange{Vars: matchedResponseRoutes.vars}
+		if !rule.MatchesRequest(data, headers, gate) {
+			logger.Debug("Route skipped by match_body/match_headers", "index", routeIndex)
+			if trace != nil {
+				trace(config.TraceStep{Phase: "route", Route: routeIndex, Action: -1, Reason: rule.GateMiss(data, headers, gate)})
+			}
+			continue
+		}
+		// Groups from the path regex (matched against the client's path) and match_body become ${name}
+		rule.CaptureGroups(path, data, gate, routeIndex)
+		vars := matchedResponseRoutes.vars
+
 		matchedResponseRoutes.rules = append(matchedResponseRoutes.rules, rule)
 		matchedResponseRoutes.indices = append(matchedResponseRoutes.indices, routeIndex)
 
+		if rule.Target != "" {
+			retarget(req, config.ExpandVars(rule.Target, vars), routeIndex)
+			upstream = UpstreamFor(req)
+		}
+
 		if rule.TargetPath != "" {
 			originalPath := req.URL.Path
-			if rule.TargetPath != originalPath {
-				req.URL.Path = rule.TargetPath
-				logger.Debug("Route path rewrite applied", "index", routeIndex, "from", originalPath, "to", rule.TargetPath)
+			targetPath := config.ExpandVars(rule.TargetPath, vars)
+			if targetPath != originalPath {
+				req.URL.Path = targetPath
+				req.URL.RawPath = ""
+				logger.Debug("Route path rewrite applied", "index", routeIndex, "from", originalPath, "to", targetPath)
 			}
 		}
 
-		if !hasJSONBody || len(rule.OnRequest) == 0 {
+		if rule.TargetQuery.Len() > 0 {
+			from := req.URL.RawQuery
+			applyQueryEdits(req.URL, rule.TargetQuery, vars)
+			logger.Debug("Route query rewrite applied", "index", routeIndex, "from", from, "to", req.URL.RawQuery)
+		}
+
+		if trace != nil {
+			reason := matchedRouteReason(rule, method, path)
+			if rule.Target != "" || rule.TargetPath != "" || rule.TargetQuery.Len() > 0 {
+				reason += "; rewrote request to " + req.URL.String()
+			}
+			trace(config.TraceStep{Phase: "route", Route: routeIndex, Action: -1, Matched: true, Reason: reason})
+		}
+
+		if len(rule.OnRequest) == 0 || rule.Compiled == nil {
 			continue
 		}
 
-		modified, appliedValues := config.ProcessRequest(data, headers, rule.Compiled, routeIndex, method, path)
+		actionData := data
+		if !hasJSONBody {
+			if !config.HasHeaderActions(rule.OnRequest) {
+				continue
+			}
+			// Non-JSON bodies pass through untouched; only header rewrites apply
+			actionData = map[string]any{}
+		}
+
+		ex := config.Exchange{Upstream: upstream, Vars: matchedResponseRoutes.vars, Trace: trace}
+		modified, appliedValues := config.ProcessRequest(actionData, headers, rule.Compiled, routeIndex, method, path, &ex)
+		applyHeaderEdits(req.Header, ex.HeaderEdits)
+		if ex.Target != "" {
+			retarget(req, ex.Target, routeIndex)
+			upstream = UpstreamFor(req)
+		}
 
-		if modified {
+		if modified && hasJSONBody {
 			anyModified = true
 			for k, v := range appliedValues {
 				allAppliedValues[k] = v
@@ -162,6 +328,36 @@ func ModifyRequest(req *http.Request, routes []config.Route) {
 
 	}
 
+	if tr := newRouteTranslator(matchedResponseRoutes.rules, path); tr != nil {
+		from := req.URL.Path
+		req.URL.Path = tr.rewritePath(req.URL.Path)
+		req.URL.RawPath = ""
+		if hasJSONBody {
+			data = tr.request(data)
+			anyModified = true
+		}
+		matchedResponseRoutes.translator = tr
+		logger.Debug("Protocol translation applied", "mode", tr.mode, "endpoint", tr.endpoint, "from", from, "to", req.URL.Path)
+		if trace != nil {
+			step := config.TraceStep{Phase: "translate", Route: -1, Action: -1, Matched: true, Reason: fmt.Sprintf("%s: %s to %s", tr.mode, from, req.URL.Path)}
+			if hasJSONBody {
+				step.Body = maps.Clone(data)
+			}
+			trace(step)
+		}
+	}
+
+	if hasJSONBody {
+		if bridge := applyUpstreamStream(matchedResponseRoutes.rules, data, req.URL.Path); bridge != "" {
+			matchedResponseRoutes.bridge = bridge
+			anyModified = true
+			logger.Debug("Upstream stream override applied", "bridge", bridge, "stream", data["stream"])
+			if trace != nil {
+				trace(config.TraceStep{Phase: "upstream_stream", Route: -1, Action: -1, Matched: true, Reason: bridge, Body: maps.Clone(data)})
+			}
+		}
+	}
+
 	if len(matchedResponseRoutes.rules) > 0 {
 		ctx := context.WithValue(req.Context(), routeContextKey, &matchedResponseRoutes)
 		*req = *req.WithContext(ctx)
@@ -171,11 +367,11 @@ func ModifyRequest(req *http.Request, routes []config.Route) {
 		modifiedBody, err := json.Marshal(data)
 		if err != nil {
 			logger.Error("Failed to marshal modified request JSON", "method", method, "path", path, "err", err)
-			req.Body = io.NopCloser(bytes.NewReader(body))
+			setRequestBody(req, body)
 			return
 		}
 
-		req.Body = io.NopCloser(bytes.NewReader(modifiedBody))
+		setRequestBody(req, modifiedBody)
 		req.ContentLength = int64(len(modifiedBody))
 
 		fields := []any{
@@ -183,6 +379,9 @@ func ModifyRequest(req *http.Request, routes []config.Route) {
 			"path", path,
 			"changes", len(allAppliedValues),
 		}
+		if upstream != "" {
+			fields = append(fields, "target", config.RedactURL(upstream))
+		}
 		if len(matchedResponseRoutes.rules) > 0 {
 			fields = append(fields, "matched_routes", matchedResponseRoutes.indices)
 		}
@@ -193,8 +392,60 @@ func ModifyRequest(req *http.Request, routes []config.Route) {
 			logger.Debug("Outbound request body", "body", string(finalBody))
 		}
 	} else if len(body) > 0 {
-		req.Body = io.NopCloser(bytes.NewReader(body))
+		setRequestBody(req, body)
+	}
+}
+
+// routeContextFor returns the state ModifyRequest attached to a request, or nil
+func routeContextFor(req *http.Request) *responseRouteContext {
+	if req == nil {
+		return nil
 	}
+	if v, ok := req.Context().Value(routeContextKey).(*responseRouteContext); ok && v != nil {
+		return v
+	}
+	return nil
+}
+
+// newRequestInfo snapshots the client request before actions change it
+// The body is decoded again so later edits to the working copy don't show through.
+func newRequestInfo(method, path string, headers map[string]string, body []byte, hasJSONBody bool) *config.RequestInfo {
+	info := &config.RequestInfo{
+		Headers: make(map[string]string, len(headers)),
+		Method:  method,
+		Path:    path,
+	}
+	maps.Copy(info.Headers, headers)
+	if hasJSONBody {
+		json.Unmarshal(body, &info.Body)
+	}
+	return info
+}
+
+// applyQueryEdits rewrites the outbound query string: removals, then set, then add
+// Values may reference request-scoped variables as ${name}.
+func applyQueryEdits(u *url.URL, edits config.QueryEdits, vars map[string]any) {
+	query := u.Query()
+	for _, name := range edits.Remove {
+		query.Del(name)
+	}
+	for name, value := range edits.Set {
+		query.Set(name, config.ExpandVars(value, vars))
+	}
+	for name, value := range edits.Add {
+		query.Add(name, config.ExpandVars(value, vars))
+	}
+	u.RawQuery = query.Encode()
+}
+
+// retarget applies a route or action target override, logging failures rather than aborting
+func retarget(req *http.Request, target string, routeIndex int) {
+	from := req.URL.Host
+	if err := Retarget(req, target); err != nil {
+		logger.Error("Failed to apply target override", "index", routeIndex, "target", config.RedactURL(target), "err", err)
+		return
+	}
+	logger.Debug("Route target override applied", "index", routeIndex, "from", from, "to", req.URL.Host)
 }
 
 // ModifyResponse processes the response through matching routes
@@ -202,15 +453,24 @@ func ModifyResponse(resp *http.Response, routes []config.Route) error {
 	method := resp.Request.Method
 	path := resp.Request.URL.Path
 	contentType := resp.Header.Get("Content-Type")
+	upstream := UpstreamFor(resp.Request)
 
 	// Get the routes from context (may be nil)
 	var matchedRoutes []*config.Route
 	var matchedRouteIndices []int
+	var tr *translator
+	var vars map[string]any
+	var request *config.RequestInfo
+	bridge := ""
 	switch v := resp.Request.Context().Value(routeContextKey).(type) {
 	case *responseRouteContext:
 		if v != nil {
 			matchedRoutes = v.rules
 			matchedRouteIndices = v.indices
+			tr = v.translator
+			bridge = v.bridge
+			vars = v.vars
+			request = v.request
 		}
 	case *config.Route:
 		matchedRoutes = []*config.Route{v}
@@ -224,8 +484,16 @@ func ModifyResponse(resp *http.Response, routes []config.Route) error {
 		}
 	}
 
-	// Route to streaming handler if SSE (log events even without on_response operations)
-	if strings.Contains(contentType, "text/event-stream") {
+	// Errors keep the upstream's shape; clients handle those without streaming
+	if bridge != "" && resp.StatusCode < 300 {
+		if err := bridgeResponse(resp, bridge); err != nil {
+			return err
+		}
+		contentType = resp.Header.Get("Content-Type")
+	}
+
+	// Route to streaming handler for SSE and NDJSON (log events even without on_response operations)
+	if isStreamingContentType(contentType) {
 		if len(matchedRoutes) == 0 {
 			logger.Info("Streaming response", "method", method, "path", path, "status", resp.StatusCode, "content_type", contentType)
 		} else {
@@ -258,6 +526,23 @@ func ModifyResponse(resp *http.Response, routes []config.Route) error {
 		}
 	}
 
+	if tr != nil {
+		if translated, ok := tr.response(body, resp.StatusCode); ok {
+			body = translated
+			contentType = "application/json"
+			resp.Header.Set("Content-Type", contentType)
+			logger.Debug("Translated response", "mode", tr.mode, "endpoint", tr.endpoint, "status", resp.StatusCode)
+			if trace := traceFor(resp.Request); trace != nil {
+				var translatedData map[string]any
+				reason := tr.mode + " response"
+				if err := json.Unmarshal(body, &translatedData); err != nil {
+					reason += " (not JSON: " + err.Error() + ")"
+				}
+				trace(config.TraceStep{Phase: "translate", Route: -1, Action: -1, Matched: true, Reason: reason, Body: translatedData})
+			}
+		}
+	}
+
 	resp.Body = io.NopCloser(bytes.NewReader(body))
 	resp.ContentLength = int64(len(body))
 
@@ -278,16 +563,18 @@ func ModifyResponse(resp *http.Response, routes []config.Route) error {
 		return nil
 	}
 
-	if !strings.Contains(contentType, "application/json") {
-		logger.Info("Outbound response", "method", method, "path", path, "status", resp.StatusCode, "changes", 0, "reason", "non_json", "matched_routes", matchedRouteIndices, "content_type", contentType)
-		return nil
-	}
-
 	var data map[string]any
-	if err := json.Unmarshal(body, &data); err != nil {
-		// If not JSON, return original body
-		resp.Body = io.NopCloser(bytes.NewReader(body))
-		return nil
+	isJSON := strings.Contains(contentType, "application/json") && json.Unmarshal(body, &data) == nil && data != nil
+	rawBody := ""
+	if !isJSON {
+		if resp.StatusCode < 400 {
+			applyResponseHeaderActions(resp, matchedRoutes, matchedRouteIndices)
+			logger.Info("Outbound response", "method", method, "path", path, "status", resp.StatusCode, "changes", 0, "reason", "non_json", "matched_routes", matchedRouteIndices, "content_type", contentType)
+			return nil
+		}
+		// Error bodies of any shape go through actions so they can be reshaped (ex: error_format)
+		data = map[string]any{}
+		rawBody = strings.TrimSpace(string(body))
 	}
 
 	// Extract response headers as map[string]string for matching
@@ -300,11 +587,17 @@ func ModifyResponse(resp *http.Response, routes []config.Route) error {
 
 	anyModified := false
 	appliedValues := make(map[string]any)
+	statusOverride := 0
 	for i, route := range matchedRoutes {
 		if len(route.OnResponse) == 0 || route.Compiled == nil {
 			continue
 		}
-		modified, vals := config.ProcessResponse(data, headers, route.Compiled, matchedRouteIndices[i], method, path)
+		ex := config.Exchange{Upstream: upstream, Vars: vars, Request: request, Status: resp.StatusCode, RawBody: rawBody, Trace: traceFor(resp.Request)}
+		modified, vals := config.ProcessResponse(data, headers, route.Compiled, matchedRouteIndices[i], method, path, &ex)
+		applyHeaderEdits(resp.Header, ex.HeaderEdits)
+		if ex.StatusOverride != 0 {
+			statusOverride = ex.StatusOverride
+		}
 		if modified {
 			anyModified = true
 		}
@@ -312,6 +605,16 @@ func ModifyResponse(resp *http.Response, routes []config.Route) error {
 			appliedValues[k] = v
 		}
 	}
+	setResponseStatus(resp, statusOverride)
+
+	// Non-JSON errors no action rewrote keep their original body
+	if !isJSON && len(data) == 0 {
+		logger.Info("Outbound response", "method", method, "path", path, "status", resp.StatusCode, "changes", len(appliedValues), "reason", "non_json", "matched_routes", matchedRouteIndices, "content_type", contentType)
+		return nil
+	}
+	if !isJSON {
+		resp.Header.Set("Content-Type", "application/json")
+	}
 
 	modifiedBody, err := json.Marshal(data)
 	if err != nil {
@@ -328,6 +631,9 @@ func ModifyResponse(resp *http.Response, routes []config.Route) error {
 		"status", resp.StatusCode,
 		"changes", len(appliedValues),
 	}
+	if upstream != "" {
+		fields = append(fields, "target", config.RedactURL(upstream))
+	}
 	if len(matchedRouteIndices) > 0 {
 		fields = append(fields, "matched_routes", matchedRouteIndices)
 	}
@@ -341,7 +647,170 @@ func ModifyResponse(resp *http.Response, routes []config.Route) error {
 	return nil
 }
 
-// ModifyStreamingResponse processes Server-Sent Events (SSE) line-by-line
+// resolveStreamFormat picks the framing for a streamed response
+// The first matched route with stream_format wins; translated streams default to the client's framing.
+// An empty result keeps each line's upstream framing.
+func resolveStreamFormat(routes []*config.Route, tr *translator, contentType string) string {
+	format := ""
+	for _, route := range routes {
+		if route != nil && route.StreamFormat != "" {
+			format = route.StreamFormat
+			break
+		}
+	}
+
+	switch format {
+	case config.StreamFormatSSE, config.StreamFormatNDJSON:
+		return format
+	case config.StreamFormatPassthrough:
+		// Translated chunks have no framing of their own, so reuse the upstream's
+		if tr != nil {
+			if strings.Contains(contentType, "text/event-stream") {
+				return config.StreamFormatSSE
+			}
+			return config.StreamFormatNDJSON
+		}
+		return ""
+	}
+	if tr != nil {
+		return tr.clientStreamFormat()
+	}
+	return ""
+}
+
+// streamContentType is the Content-Type for a stream framing
+func streamContentType(format string) string {
+	if format == config.StreamFormatNDJSON {
+		return "application/x-ndjson"
+	}
+	return "text/event-stream"
+}
+
+// frameChunk wraps an encoded chunk in the given stream framing
+func frameChunk(format string, chunk []byte) []byte {
+	if format == config.StreamFormatSSE {
+		return append(append([]byte("data: "), chunk...), '\n', '\n')
+	}
+	return append(chunk, '\n')
+}
+
+// isStreamingContentType reports whether a response is streamed as SSE or NDJSON
+func isStreamingContentType(contentType string) bool {
+	return strings.Contains(contentType, "text/event-stream") || strings.Contains(contentType, "application/x-ndjson")
+}
+
+// applyResponseHeaderActions runs response actions against an empty body so header and status
+// rewrites apply to responses whose body is streamed or not JSON
+// match_body gates see that empty body too, so body-gated rewrites rarely apply; they are logged at debug.
+func applyResponseHeaderActions(resp *http.Response, routes []*config.Route, routeIndices []int) {
+	headers := make(map[string]string)
+	for key, values := range resp.Header {
+		if len(values) > 0 {
+			headers[key] = values[0]
+		}
+	}
+
+	base := config.Exchange{Upstream: UpstreamFor(resp.Request), Status: resp.StatusCode, Trace: traceFor(resp.Request)}
+	if rc := routeContextFor(resp.Request); rc != nil {
+		base.Vars = rc.vars
+		base.Request = rc.request
+	}
+
+	statusOverride := 0
+	for i, route := range routes {
+		if route == nil || route.Compiled == nil {
+			continue
+		}
+		if !config.HasHeaderActions(route.OnResponse) && !config.HasStatusActions(route.OnResponse) {
+			continue
+		}
+		routeIndex := -1
+		if i < len(routeIndices) {
+			routeIndex = routeIndices[i]
+		}
+		if gated := config.BodyGatedHeaderActions(route.OnResponse); len(gated) > 0 {
+			logger.Debug("Response body is not available to header actions; their match_body sees an empty body", "route", routeIndex, "actions", gated)
+		}
+		ex := base
+		config.ProcessResponse(map[string]any{}, headers, route.Compiled, routeIndex, resp.Request.Method, resp.Request.URL.Path, &ex)
+		applyHeaderEdits(resp.Header, ex.HeaderEdits)
+		if ex.StatusOverride != 0 {
+			statusOverride = ex.StatusOverride
+		}
+	}
+	setResponseStatus(resp, statusOverride)
+}
+
+// setResponseStatus applies a set_status override; zero leaves the status alone
+func setResponseStatus(resp *http.Response, status int) {
+	if status == 0 || status == resp.StatusCode {
+		return
+	}
+	logger.Debug("Response status rewritten", "from", resp.StatusCode, "to", status)
+	resp.StatusCode = status
+	resp.Status = fmt.Sprintf("%d %s", status, http.StatusText(status))
+}
+
+// applyHeaderEdits applies action header rewrites to an outbound request or response
+func applyHeaderEdits(h http.Header, edits []config.HeaderEdit) {
+	for _, e := range edits {
+		switch e.Op {
+		case config.HeaderRemove:
+			h.Del(e.Name)
+		case config.HeaderSet:
+			h.Set(e.Name, e.Value)
+		case config.HeaderAdd:
+			h.Add(e.Name, e.Value)
+		}
+	}
+}
+
+// finishStream runs on_stream_end actions on the assembled message
+// Returns the top-level fields the actions added or changed, to be sent in the stream's own protocol;
+// the assembled content itself is never re-sent, since the client already has it.
+func finishStream(acc *streamAccumulator, routes []*config.Route, routeIndices []int, headers map[string]string, method, path string, ex config.Exchange) map[string]any {
+	message := acc.message()
+	logger.Info("Stream assembled",
+		"method", method,
+		"path", path,
+		"chunks", acc.chunks,
+		"content_length", acc.content.Len(),
+		"tool_calls", len(acc.toolCalls),
+		"finish_reason", acc.finishReason())
+	if logger.IsDebug() {
+		assembledJSON, _ := json.MarshalIndent(message, "", "  ")
+		logger.Debug("Assembled stream message", "message", string(assembledJSON))
+	}
+
+	modified := false
+	for i, rule := range routes {
+		if rule == nil || len(rule.OnStreamEnd) == 0 || rule.Compiled == nil {
+			continue
+		}
+		if changed, _ := config.ProcessStreamEnd(message, headers, rule.Compiled, routeIndices[i], method, path, &ex); changed {
+			modified = true
+		}
+	}
+	if !modified {
+		return nil
+	}
+
+	assembled := acc.message()
+	changes := make(map[string]any)
+	for k, v := range message {
+		if acc.isContentKey(k) {
+			if !reflect.DeepEqual(assembled[k], v) {
+				logger.Debug("on_stream_end change to streamed content is not sent", "field", k)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(assembled[k], v) {
+			changes[k] = v
+		}
+	}
+	return changes
+}
+
 // ModifyStreamingResponse rewrites streaming responses for matched routes, handling both SSE (`data:`) lines and raw JSON chunks.
 func ModifyStreamingResponse(resp *http.Response, routes []*config.Route, routeIndices []int) error {
 	method := resp.Request.Method
@@ -354,6 +823,42 @@ func ModifyStreamingResponse(resp *http.Response, routes []*config.Route, routeI
 		}
 	}
 
+	tr := translatorFor(resp.Request)
+	trace := traceFor(resp.Request)
+	rc := routeContextFor(resp.Request)
+	var request *config.RequestInfo
+	if rc != nil {
+		request = rc.request
+	}
+	status := resp.StatusCode
+	upstream := UpstreamFor(resp.Request)
+	upstreamType := resp.Header.Get("Content-Type")
+	format := resolveStreamFormat(routes, tr, upstreamType)
+	if format != "" {
+		resp.Header.Set("Content-Type", streamContentType(format))
+		resp.Header.Del("Content-Length")
+		resp.ContentLength = -1
+	}
+
+	// Chunk actions match against the headers before header actions rewrite them,
+	// with Content-Type already reflecting any stream_format
+	headers := make(map[string]string)
+	for key, values := range resp.Header {
+		if len(values) > 0 {
+			headers[key] = values[0]
+		}
+	}
+
+	// Headers go out before the first chunk, so rewrite them without a body,
+	// and before the goroutine starts so it never shares resp with this one
+	applyResponseHeaderActions(resp, routes, routeIndices)
+
+	// Chunks capture into their own copy of the exchange's variables
+	var vars map[string]any
+	if rc != nil {
+		vars = maps.Clone(rc.vars)
+	}
+
 	pipeReader, pipeWriter := io.Pipe()
 	originalBody := resp.Body
 
@@ -363,69 +868,56 @@ func ModifyStreamingResponse(resp *http.Response, routes []*config.Route, routeI
 		defer pipeWriter.Close()
 		defer originalBody.Close()
 
-		scanner := bufio.NewScanner(originalBody)
-		scanner.Buffer(make([]byte, 64*1024), 1024*1024) // 64KB initial, 1MB max line size
+		// Upstreams that don't label the stream as SSE are read line by line
+		reader := newSSEReader(originalBody, !strings.Contains(upstreamType, "text/event-stream"))
 		logger.Info("Streaming response start", "method", method, "path", path)
-		logger.Debug("Initialized streaming scanner", "max_line_size", "1MB")
+		logger.Debug("Initialized streaming reader", "max_line_size", "1MB", "ndjson", reader.ndjson)
+
+		doneSent := false
 
-		headers := make(map[string]string)
-		for key, values := range resp.Header {
-			if len(values) > 0 {
-				headers[key] = values[0]
+		// Assemble the message only when a route has on_stream_end actions
+		var acc *streamAccumulator
+		for _, rule := range routes {
+			if rule != nil && len(rule.OnStreamEnd) > 0 && rule.Compiled != nil {
+				acc = &streamAccumulator{}
+				break
 			}
 		}
-
-		lineNum := 0
-		for scanner.Scan() {
-			lineNum++
-			line := scanner.Text()
-
-			if logger.IsDebug() {
-				safeLine, truncated := sanitizeBody([]byte(line), 4096)
-				logger.Debug("Streaming event received", "line", lineNum, "body", safeLine, "truncated", truncated)
+		// An upstream [DONE] is held back so on_stream_end output lands before it
+		var heldDone *sseEvent
+		// So is an Ollama done chunk, which carries on_stream_end output itself
+		var heldFinal func(changes map[string]any) []byte
+		holdFinal := func(data map[string]any, encode func([]byte) []byte) bool {
+			if acc == nil || !acc.ollama {
+				return false
 			}
-
-			if lineNum == 1 && logger.IsDebug() {
-				logger.Debug("Streaming first line", "line", lineNum)
-			} else if lineNum%50 == 0 && logger.IsDebug() {
-				logger.Debug("Streaming heartbeat", "line", lineNum)
+			if done, _ := data["done"].(bool); !done {
+				return false
 			}
-
-			// Empty lines are SSE delimiters - pass through
-			if line == "" {
-				if _, err := pipeWriter.Write([]byte("\n")); err != nil {
-					logger.Error("Failed to write empty streaming line", "err", err)
-					return
+			heldFinal = func(changes map[string]any) []byte {
+				for k, v := range changes {
+					data[k] = v
 				}
-				continue
-			}
-
-			var jsonData []byte
-			var isSSE bool
-
-			if strings.HasPrefix(line, "data: ") {
-				isSSE = true
-				jsonStr := strings.TrimPrefix(line, "data: ")
-
-				// Handle [DONE] marker
-				if jsonStr == "[DONE]" {
-					if _, err := pipeWriter.Write([]byte(line + "\n")); err != nil {
-						logger.Error("Failed to write streaming [DONE] marker", "err", err)
-					}
-					continue
+				out, err := json.Marshal(data)
+				if err != nil {
+					logger.Error("Failed to marshal final streaming chunk", "err", err)
+					return nil
 				}
-
-				jsonData = []byte(jsonStr)
-			} else {
-				jsonData = []byte(line)
+				return encode(out)
 			}
+			return true
+		}
 
-			var data map[string]any
-			if err := json.Unmarshal(jsonData, &data); err != nil {
-				if _, err := pipeWriter.Write([]byte(line + "\n")); err != nil {
-					logger.Error("Failed to write non-JSON streaming line", "err", err)
+		// transform applies on_response actions to one chunk and returns it re-encoded
+		transform := func(data map[string]any, ev *sseEvent, eventNum int) ([]byte, error) {
+			ex := config.Exchange{Upstream: upstream, Vars: vars, Request: request, Status: status, Trace: trace}
+			if !ev.bare {
+				// Events without an event field have the default SSE type
+				ex.Event = ev.Event
+				if ex.Event == "" {
+					ex.Event = "message"
 				}
-				continue
+				ex.EventID = ev.ID
 			}
 
 			modified := false
@@ -434,7 +926,8 @@ func ModifyStreamingResponse(resp *http.Response, routes []*config.Route, routeI
 				if rule == nil || len(rule.OnResponse) == 0 || rule.Compiled == nil {
 					continue
 				}
-				changed, vals := config.ProcessResponse(data, headers, rule.Compiled, routeIndices[i], method, path)
+				// Header edits are dropped here; headers were already sent
+				changed, vals := config.ProcessResponse(data, headers, rule.Compiled, routeIndices[i], method, path, &ex)
 				if changed {
 					modified = true
 					for k, v := range vals {
@@ -445,34 +938,187 @@ func ModifyStreamingResponse(resp *http.Response, routes []*config.Route, routeI
 
 			if logger.IsDebug() && modified {
 				appliedJSON, _ := json.MarshalIndent(appliedValues, "", "  ")
-				logger.Debug("Applied streaming chunk transformation", "line", lineNum, "changes", string(appliedJSON))
+				logger.Debug("Applied streaming chunk transformation", "event", eventNum, "changes", string(appliedJSON))
+			}
+
+			if acc != nil {
+				acc.add(data)
+			}
+			return json.Marshal(data)
+		}
+
+		write := func(b []byte) bool {
+			if _, err := pipeWriter.Write(b); err != nil {
+				logger.Error("Failed to write streaming event", "err", err)
+				return false
 			}
+			return true
+		}
 
-			modifiedJSON, err := json.Marshal(data)
+		// passThrough re-emits an event untouched, adding SSE framing to bare lines when needed
+		passThrough := func(ev *sseEvent) bool {
+			if format == config.StreamFormatSSE && ev.bare {
+				return write(frameChunk(format, []byte(ev.Data)))
+			}
+			return write(ev.raw())
+		}
+
+		// writeTranslated frames translated chunks for the client's protocol
+		writeTranslated := func(chunks []map[string]any, ev *sseEvent, eventNum int) bool {
+			for _, chunk := range chunks {
+				out, err := transform(chunk, ev, eventNum)
+				if err != nil {
+					logger.Error("Failed to marshal translated streaming chunk", "err", err)
+					continue
+				}
+				if holdFinal(chunk, func(b []byte) []byte { return frameChunk(format, b) }) {
+					continue
+				}
+				if !write(frameChunk(format, out)) {
+					return false
+				}
+			}
+			return true
+		}
+
+		eventNum := 0
+		var last *sseEvent
+		for {
+			ev, err := reader.Next()
+			if err == io.EOF {
+				break
+			}
 			if err != nil {
-				logger.Error("Failed to marshal modified streaming chunk", "err", err)
-				if _, err := pipeWriter.Write([]byte(line + "\n")); err != nil {
+				logger.Error("Streaming reader error", "err", err)
+				pipeWriter.CloseWithError(err)
+				return
+			}
+			eventNum++
+			last = ev
+
+			if logger.IsDebug() {
+				safeEvent, truncated := sanitizeBody(ev.raw(), 4096)
+				logger.Debug("Streaming event received", "event", eventNum, "type", ev.Event, "id", ev.ID, "body", safeEvent, "truncated", truncated)
+			}
+
+			if eventNum == 1 && logger.IsDebug() {
+				logger.Debug("Streaming first event", "event", eventNum)
+			} else if eventNum%50 == 0 && logger.IsDebug() {
+				logger.Debug("Streaming heartbeat", "event", eventNum)
+			}
+
+			if tr != nil {
+				if ev.hasData && !writeTranslated(tr.streamChunk(ev.Data), ev, eventNum) {
 					return
 				}
 				continue
 			}
 
-			if isSSE {
-				if _, err := pipeWriter.Write([]byte("data: ")); err != nil {
+			// Blank lines, comments and retry hints carry no data
+			if !ev.hasData {
+				if format == config.StreamFormatNDJSON {
+					continue
+				}
+				if !write(ev.raw()) {
 					return
 				}
+				continue
 			}
-			if _, err := pipeWriter.Write(modifiedJSON); err != nil {
-				return
+
+			if ev.Data == "[DONE]" {
+				if format == config.StreamFormatNDJSON {
+					continue
+				}
+				doneSent = true
+				if acc != nil {
+					heldDone = ev
+					continue
+				}
+				if !passThrough(ev) {
+					return
+				}
+				continue
 			}
-			if _, err := pipeWriter.Write([]byte("\n")); err != nil {
+
+			var data map[string]any
+			if err := json.Unmarshal([]byte(ev.Data), &data); err != nil {
+				if format == config.StreamFormatNDJSON {
+					logger.Debug("Dropping non-JSON streaming event for NDJSON output", "event", eventNum)
+					continue
+				}
+				if !passThrough(ev) {
+					return
+				}
+				continue
+			}
+
+			modifiedJSON, err := transform(data, ev, eventNum)
+			if err != nil {
+				logger.Error("Failed to marshal modified streaming chunk", "err", err)
+				if !passThrough(ev) {
+					return
+				}
+				continue
+			}
+
+			encode := func(b []byte) []byte {
+				if format == config.StreamFormatNDJSON || format == config.StreamFormatSSE && ev.bare {
+					return frameChunk(format, b)
+				}
+				return ev.encode(b)
+			}
+			if holdFinal(data, encode) {
+				continue
+			}
+			if !write(encode(modifiedJSON)) {
 				return
 			}
 		}
 
-		if err := scanner.Err(); err != nil {
-			logger.Error("Streaming scanner error", "err", err)
-			pipeWriter.CloseWithError(err)
+		if tr != nil && !writeTranslated(tr.streamEnd(), &sseEvent{bare: true}, eventNum) {
+			return
+		}
+		if acc != nil {
+			changes := finishStream(acc, routes, routeIndices, headers, method, path, config.Exchange{Upstream: upstream, Vars: vars, Request: request, Status: status, Trace: trace})
+			var framed []byte
+			switch {
+			case heldFinal != nil:
+				framed = heldFinal(changes)
+			case len(changes) > 0:
+				out, err := json.Marshal(acc.finalChunk(changes))
+				if err != nil {
+					logger.Error("Failed to marshal on_stream_end result", "err", err)
+					break
+				}
+				switch {
+				case format != "":
+					framed = frameChunk(format, out)
+				case reader.ndjson:
+					framed = append(out, '\n')
+				default:
+					framed = frameChunk(config.StreamFormatSSE, out)
+				}
+			}
+			if framed != nil {
+				// A held chunk is re-encoded whole; otherwise the upstream's last event may still be open
+				if heldFinal == nil && last != nil && !last.bare && !last.terminated && len(last.lines) > 0 {
+					write([]byte("\n"))
+				}
+				last = nil
+				if !write(framed) {
+					return
+				}
+			}
+			if heldDone != nil && !passThrough(heldDone) {
+				return
+			}
+		}
+		// SSE clients wait for [DONE]; add it when the upstream did not send one
+		if format == config.StreamFormatSSE && !doneSent {
+			if last != nil && !last.bare && !last.terminated && len(last.lines) > 0 {
+				write([]byte("\n"))
+			}
+			write([]byte("data: [DONE]\n\n"))
 		}
 	}()
 
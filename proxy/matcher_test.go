@@ -1,7 +1,12 @@
 package proxy
 
 import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"testing"
 
 	"github.com/spicyneuron/llama-matchmaker/config"
@@ -117,6 +122,107 @@ func TestFindMatchingRoutesStacking(t *testing.T) {
 	}
 }
 
+func TestFindMatchingRoutesHostsAndQuery(t *testing.T) {
+	routes := []config.Route{
+		{
+			Methods: newPatternField(".*"),
+			Paths:   newPatternField(".*"),
+			Hosts:   newPatternField(`^llm\.internal$`),
+		},
+		{
+			Methods: newPatternField(".*"),
+			Paths:   newPatternField(".*"),
+			Query:   map[string]config.PatternField{"beta": newPatternField("^(true|1)$")},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		url         string
+		wantIndices []int
+	}{
+		{name: "host with port", url: "http://llm.internal:8080/v1/models", wantIndices: []int{0}},
+		{name: "other host", url: "http://example.com/v1/models", wantIndices: nil},
+		{name: "query match on any value", url: "http://example.com/v1/models?beta=false&beta=1", wantIndices: []int{1}},
+		{name: "query mismatch", url: "http://example.com/v1/models?beta=no", wantIndices: nil},
+		{name: "both", url: "http://LLM.internal/v1/models?beta=true", wantIndices: []int{0, 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.url, nil)
+			_, indices := MatchRoutes(req, routes)
+			if !reflect.DeepEqual(indices, tt.wantIndices) {
+				t.Errorf("matched %v, want %v", indices, tt.wantIndices)
+			}
+		})
+	}
+}
+
+func TestModifyRequestTargetQuery(t *testing.T) {
+	routes := []config.Route{{
+		Methods: newPatternField(".*"),
+		Paths:   newPatternField(".*"),
+		TargetQuery: config.QueryEdits{
+			Set:    map[string]string{"api-version": "2024-06-01"},
+			Add:    map[string]string{"tag": "proxy"},
+			Remove: []string{"beta", "api-version"},
+		},
+	}}
+
+	req := httptest.NewRequest("GET", "http://example.com/v1/models?beta=true&tag=client&api-version=old", nil)
+	ModifyRequest(req, routes)
+
+	if got, want := req.URL.RawQuery, "api-version=2024-06-01&tag=client&tag=proxy"; got != want {
+		t.Errorf("query = %s, want %s", got, want)
+	}
+}
+
+func TestModifyRequestPathCaptures(t *testing.T) {
+	cfg := newTestConfig("http://localhost:8080", []config.Route{{
+		Methods:     newPatternField("POST"),
+		Paths:       newPatternField(`^/models/(?P<model>[^/]+)/chat$`),
+		TargetPath:  "/v1/chat/completions",
+		TargetQuery: config.QueryEdits{Set: map[string]string{"model": "${model}"}},
+		OnRequest:   []config.Action{{Merge: map[string]any{"model": "${model}"}}},
+		OnResponse:  []config.Action{{Merge: map[string]any{"served_by": "${model}"}}},
+	}})
+	if err := config.Validate(cfg); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+	if err := config.CompileTemplates(cfg); err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	routes := cfg.Proxies[0].Routes
+
+	req := httptest.NewRequest("POST", "http://example.com/models/qwen3-8b/chat", bytes.NewBufferString(`{"messages":[]}`))
+	ModifyRequest(req, routes)
+
+	if req.URL.Path != "/v1/chat/completions" || req.URL.RawQuery != "model=qwen3-8b" {
+		t.Errorf("url = %s, want /v1/chat/completions?model=qwen3-8b", req.URL.RequestURI())
+	}
+	var sent map[string]any
+	json.NewDecoder(req.Body).Decode(&sent)
+	if sent["model"] != "qwen3-8b" {
+		t.Errorf("model = %v, want qwen3-8b", sent["model"])
+	}
+
+	resp := &http.Response{
+		Request:    req,
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(bytes.NewBufferString(`{"ok":true}`)),
+	}
+	if err := ModifyResponse(resp, routes); err != nil {
+		t.Fatalf("ModifyResponse error: %v", err)
+	}
+	var got map[string]any
+	json.NewDecoder(resp.Body).Decode(&got)
+	if got["served_by"] != "qwen3-8b" {
+		t.Errorf("served_by = %v, want qwen3-8b", got["served_by"])
+	}
+}
+
 // Helper to get route index
 func getRouteIndex(rule *config.Route, routes []config.Route) int {
 	for i := range routes {
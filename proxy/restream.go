@@ -0,0 +1,274 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/spicyneuron/llama-matchmaker/config"
+	"github.com/spicyneuron/llama-matchmaker/logger"
+)
+
+// Stream bridges, set when upstream_stream overrides what the client asked for
+const (
+	// The client streams but the upstream answers with one JSON body
+	bridgeSynthesize = "synthesize"
+	// The client wants one JSON body but the upstream streams
+	bridgeBuffer = "buffer"
+)
+
+// applyUpstreamStream forces the outbound stream flag for the first route with upstream_stream
+// Returns the bridge needed to give the client the response shape it asked for, or "".
+// data is the body as the upstream will see it, so translated requests are already explicit.
+func applyUpstreamStream(routes []*config.Route, data map[string]any, path string) string {
+	var force *bool
+	for _, route := range routes {
+		if route != nil && route.UpstreamStream != nil {
+			force = route.UpstreamStream
+			break
+		}
+	}
+	if force == nil {
+		return ""
+	}
+
+	clientStream := requestsStream(data, path)
+	data["stream"] = *force
+	if *force {
+		// OpenAI only reports usage on streams when asked; buffered responses should keep it
+		if !isOllamaPath(path) {
+			data["stream_options"] = map[string]any{"include_usage": true}
+		}
+	} else {
+		delete(data, "stream_options")
+	}
+
+	switch {
+	case clientStream && !*force:
+		return bridgeSynthesize
+	case !clientStream && *force:
+		return bridgeBuffer
+	}
+	return ""
+}
+
+// requestsStream reports whether a request body asks for a streamed response
+// Ollama streams unless told otherwise; OpenAI only when stream is true.
+func requestsStream(data map[string]any, path string) bool {
+	if stream, ok := data["stream"].(bool); ok {
+		return stream
+	}
+	return isOllamaPath(path)
+}
+
+func isOllamaPath(path string) bool {
+	return strings.HasSuffix(path, "/api/chat") || strings.HasSuffix(path, "/api/generate")
+}
+
+// synthesizeStream turns a complete JSON response into the stream the upstream would have sent
+// OpenAI responses become SSE chunks ending in [DONE]; Ollama responses become NDJSON lines.
+func synthesizeStream(body []byte) ([]byte, string, error) {
+	var data map[string]any
+	if err := json.Unmarshal(body, &data); err != nil {
+		return nil, "", err
+	}
+
+	var chunks []map[string]any
+	format := config.StreamFormatSSE
+	switch {
+	case data["choices"] != nil:
+		chunks = openAIResponseToChunks(data)
+	case data["message"] != nil, data["response"] != nil:
+		chunks = ollamaResponseToChunks(data)
+		format = config.StreamFormatNDJSON
+	default:
+		return nil, "", fmt.Errorf("unrecognised response shape")
+	}
+
+	var out bytes.Buffer
+	for _, chunk := range chunks {
+		encoded, err := json.Marshal(chunk)
+		if err != nil {
+			return nil, "", err
+		}
+		out.Write(frameChunk(format, encoded))
+	}
+	if format == config.StreamFormatSSE {
+		out.WriteString("data: [DONE]\n\n")
+	}
+	return out.Bytes(), format, nil
+}
+
+// openAIResponseToChunks splits a chat.completion or text_completion into a content chunk and a finish chunk
+func openAIResponseToChunks(data map[string]any) []map[string]any {
+	object := "chat.completion.chunk"
+	if data["object"] == "text_completion" {
+		object = "text_completion"
+	}
+	header := func() map[string]any {
+		chunk := make(map[string]any)
+		for k, v := range data {
+			if k != "choices" && k != "usage" {
+				chunk[k] = v
+			}
+		}
+		chunk["object"] = object
+		return chunk
+	}
+
+	choices, _ := data["choices"].([]any)
+	content := header()
+	finish := header()
+	contentChoices := make([]any, 0, len(choices))
+	finishChoices := make([]any, 0, len(choices))
+	for i, raw := range choices {
+		choice, _ := raw.(map[string]any)
+		index := choice["index"]
+		if index == nil {
+			index = i
+		}
+
+		if text, ok := choice["text"].(string); ok {
+			contentChoices = append(contentChoices, map[string]any{"index": index, "text": text, "finish_reason": nil})
+			finishChoices = append(finishChoices, map[string]any{"index": index, "text": "", "finish_reason": choice["finish_reason"]})
+			continue
+		}
+
+		delta := make(map[string]any)
+		if message, ok := choice["message"].(map[string]any); ok {
+			for k, v := range message {
+				delta[k] = v
+			}
+		}
+		// Streamed tool call fragments carry their position
+		if calls, ok := delta["tool_calls"].([]any); ok {
+			indexed := make([]any, len(calls))
+			for j, rawCall := range calls {
+				call := make(map[string]any)
+				if c, ok := rawCall.(map[string]any); ok {
+					for k, v := range c {
+						call[k] = v
+					}
+				}
+				call["index"] = j
+				indexed[j] = call
+			}
+			delta["tool_calls"] = indexed
+		}
+		contentChoices = append(contentChoices, map[string]any{"index": index, "delta": delta, "finish_reason": nil})
+		finishChoices = append(finishChoices, map[string]any{"index": index, "delta": map[string]any{}, "finish_reason": choice["finish_reason"]})
+	}
+	content["choices"] = contentChoices
+	finish["choices"] = finishChoices
+	if usage, ok := data["usage"]; ok {
+		finish["usage"] = usage
+	}
+	return []map[string]any{content, finish}
+}
+
+// ollamaResponseToChunks splits an /api/chat or /api/generate response into a content line and a done line
+func ollamaResponseToChunks(data map[string]any) []map[string]any {
+	content := map[string]any{"model": data["model"], "created_at": data["created_at"], "done": false}
+	done := make(map[string]any)
+	for k, v := range data {
+		done[k] = v
+	}
+	done["done"] = true
+
+	if message, ok := data["message"].(map[string]any); ok {
+		content["message"] = message
+		done["message"] = map[string]any{"role": stringValue(message["role"]), "content": ""}
+	} else {
+		content["response"] = data["response"]
+		if thinking, ok := data["thinking"]; ok {
+			content["thinking"] = thinking
+			delete(done, "thinking")
+		}
+		done["response"] = ""
+	}
+	return []map[string]any{content, done}
+}
+
+// maxBufferedStream caps how much of an upstream stream bufferStream reads, like other buffered bodies
+const maxBufferedStream = 10 * 1024 * 1024
+
+// bufferStream reads a whole upstream stream and assembles it into one JSON response
+// Streams longer than maxBufferedStream are an error rather than a truncated message.
+func bufferStream(r io.Reader, ndjson bool) ([]byte, error) {
+	limited := &io.LimitedReader{R: r, N: maxBufferedStream + 1}
+	reader := newSSEReader(limited, ndjson)
+	acc := &streamAccumulator{}
+	for {
+		ev, err := reader.Next()
+		if limited.N == 0 {
+			return nil, fmt.Errorf("stream exceeds %d bytes", maxBufferedStream)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !ev.hasData || ev.Data == "[DONE]" {
+			continue
+		}
+		var chunk map[string]any
+		if err := json.Unmarshal([]byte(ev.Data), &chunk); err != nil {
+			continue
+		}
+		acc.add(chunk)
+	}
+	if acc.chunks == 0 {
+		return nil, fmt.Errorf("stream had no JSON chunks")
+	}
+	return json.Marshal(acc.message())
+}
+
+// bridgeResponse reshapes a successful upstream response into the form the client asked for
+// Responses already in the client's shape (ex: an upstream that ignored the stream flag) pass through.
+func bridgeResponse(resp *http.Response, bridge string) error {
+	contentType := resp.Header.Get("Content-Type")
+
+	switch bridge {
+	case bridgeSynthesize:
+		if isStreamingContentType(contentType) {
+			return nil
+		}
+		body, err := io.ReadAll(io.LimitReader(resp.Body, 10*1024*1024))
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read response body: %w", err)
+		}
+		stream, format, err := synthesizeStream(body)
+		if err != nil {
+			// Leave unrecognised bodies for the client to deal with
+			resp.Body = io.NopCloser(bytes.NewReader(body))
+			logger.Debug("Response not synthesized into a stream", "err", err)
+			return nil
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(stream))
+		resp.Header.Set("Content-Type", streamContentType(format))
+		resp.Header.Del("Content-Length")
+		resp.ContentLength = -1
+		logger.Debug("Synthesized stream from upstream response", "format", format)
+
+	case bridgeBuffer:
+		if !isStreamingContentType(contentType) {
+			return nil
+		}
+		body, err := bufferStream(resp.Body, !strings.Contains(contentType, "text/event-stream"))
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("failed to buffer upstream stream: %w", err)
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		resp.Header.Set("Content-Type", "application/json")
+		resp.Header.Del("Content-Length")
+		resp.ContentLength = int64(len(body))
+		logger.Debug("Buffered upstream stream into one response", "bytes", len(body))
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package proxy
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Synthesizing a stream and buffering it again should give back the original response
+func TestSynthesizeStreamRoundTrip(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantFormat string
+	}{
+		{
+			name:       "openai chat with tool calls",
+			body:       `{"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"gpt","choices":[{"index":0,"message":{"role":"assistant","content":"","tool_calls":[{"id":"call_a","type":"function","function":{"name":"f","arguments":"{}"}}]},"finish_reason":"tool_calls"}],"usage":{"total_tokens":9}}`,
+			wantFormat: "sse",
+		},
+		{
+			name:       "openai text completion",
+			body:       `{"id":"cmpl-1","object":"text_completion","created":1,"model":"gpt","choices":[{"index":0,"text":"hi","finish_reason":"stop"}]}`,
+			wantFormat: "sse",
+		},
+		{
+			name:       "ollama chat",
+			body:       `{"model":"llama","created_at":"2024-01-01T00:00:00Z","message":{"role":"assistant","content":"hi"},"done":true,"done_reason":"stop","eval_count":3}`,
+			wantFormat: "ndjson",
+		},
+		{
+			name:       "ollama generate",
+			body:       `{"model":"llama","created_at":"2024-01-01T00:00:00Z","response":"hi","thinking":"hmm","done":true,"done_reason":"stop","context":[1,2]}`,
+			wantFormat: "ndjson",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream, format, err := synthesizeStream([]byte(tt.body))
+			if err != nil {
+				t.Fatalf("synthesizeStream: %v", err)
+			}
+			if format != tt.wantFormat {
+				t.Errorf("format = %s, want %s", format, tt.wantFormat)
+			}
+
+			buffered, err := bufferStream(bytes.NewReader(stream), format == "ndjson")
+			if err != nil {
+				t.Fatalf("bufferStream: %v\nstream: %s", err, stream)
+			}
+			got := decodeChunk(t, string(buffered))
+			want := decodeChunk(t, tt.body)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("round trip = %v\nwant %v\nstream: %s", got, want, stream)
+			}
+		})
+	}
+}
+
+func TestBufferStreamRejectsOversizedStreams(t *testing.T) {
+	chunk := `data: {"choices":[{"index":0,"delta":{"content":"` + strings.Repeat("x", 1000) + `"}}]}` + "\n\n"
+	stream := strings.Repeat(chunk, maxBufferedStream/len(chunk)+1)
+
+	_, err := bufferStream(strings.NewReader(stream), false)
+	if err == nil || !strings.Contains(err.Error(), "stream exceeds") {
+		t.Fatalf("bufferStream error = %v, want size limit error", err)
+	}
+
+	// A stream just under the cap still assembles
+	under := strings.Repeat(chunk, maxBufferedStream/len(chunk))
+	if _, err := bufferStream(strings.NewReader(under), false); err != nil {
+		t.Fatalf("bufferStream under the cap: %v", err)
+	}
+}
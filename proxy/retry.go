@@ -0,0 +1,187 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/spicyneuron/llama-matchmaker/config"
+	"github.com/spicyneuron/llama-matchmaker/logger"
+)
+
+const (
+	defaultRetryBackoff    = 100 * time.Millisecond
+	defaultRetryMaxBackoff = 2 * time.Second
+)
+
+var defaultRetryStatuses = []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+
+// retryTransport retries failed upstream attempts and fails over to fallback targets
+// Attempts happen before the response is handed back to the reverse proxy,
+// so clients never see bytes from a failed attempt, streaming or not.
+type retryTransport struct {
+	base           http.RoundTripper
+	attempts       int
+	backoff        time.Duration
+	maxBackoff     time.Duration
+	statuses       []int
+	onNetworkError bool
+	fallbacks      []string
+	sleep          func(ctx context.Context, d time.Duration) error
+}
+
+// NewRetryTransport wraps base with the proxy's retry policy and fallbacks
+// Returns base unchanged when neither is configured.
+func NewRetryTransport(base http.RoundTripper, cfg config.ProxyConfig) http.RoundTripper {
+	if cfg.Retry.Attempts <= 1 && len(cfg.Fallbacks) == 0 {
+		return base
+	}
+
+	t := &retryTransport{
+		base:           base,
+		attempts:       max(cfg.Retry.Attempts, 1),
+		backoff:        cfg.Retry.Backoff,
+		maxBackoff:     cfg.Retry.MaxBackoff,
+		statuses:       cfg.Retry.OnStatus,
+		onNetworkError: true,
+		fallbacks:      cfg.Fallbacks,
+		sleep:          sleepContext,
+	}
+	if t.backoff == 0 {
+		t.backoff = defaultRetryBackoff
+	}
+	if t.maxBackoff == 0 {
+		t.maxBackoff = defaultRetryMaxBackoff
+	}
+	if len(t.statuses) == 0 {
+		t.statuses = defaultRetryStatuses
+	}
+	if cfg.Retry.OnNetworkError != nil {
+		t.onNetworkError = *cfg.Retry.OnNetworkError
+	}
+	return t
+}
+
+// RoundTrip tries the selected upstream, then each fallback, up to attempts times each
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Bodies without a replay source can only be sent once
+	replayable := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+
+	targets := append([]string{""}, t.fallbacks...)
+	current := req
+	tries := 0
+
+	for ti, target := range targets {
+		for attempt := 1; attempt <= t.attempts; attempt++ {
+			if tries > 0 {
+				if err := t.sleep(req.Context(), t.delay(tries)); err != nil {
+					return nil, err
+				}
+				next, err := t.nextRequest(req, current, target)
+				if err != nil {
+					return nil, err
+				}
+				current = next
+			}
+			tries++
+
+			resp, err := t.base.RoundTrip(current)
+			last := ti == len(targets)-1 && attempt == t.attempts
+
+			reason, retryable := t.shouldRetry(req.Context(), resp, err)
+			if !retryable || last || !replayable {
+				if err != nil {
+					// The reverse proxy only knows the original request's lease
+					if lease := leaseFor(current); lease != nil {
+						lease.release(true)
+					}
+				}
+				return resp, err
+			}
+
+			logger.Info("Retrying upstream request",
+				"method", req.Method,
+				"path", req.URL.Path,
+				"target", RedactedUpstreamFor(current),
+				"attempt", tries,
+				"reason", reason)
+
+			if resp != nil {
+				io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+				resp.Body.Close()
+			}
+			// Count the failure against the upstream before moving on
+			if lease := leaseFor(current); lease != nil {
+				lease.release(true)
+			}
+		}
+	}
+
+	// Unreachable: the final attempt always returns above
+	return nil, errors.New("no upstream attempts made")
+}
+
+// nextRequest clones the original request with a fresh body for the next attempt
+// An empty target repeats the selected upstream; otherwise the request is retargeted.
+func (t *retryTransport) nextRequest(orig, prev *http.Request, target string) (*http.Request, error) {
+	next := prev.Clone(prev.Context())
+	if orig.GetBody != nil {
+		body, err := orig.GetBody()
+		if err != nil {
+			return nil, fmt.Errorf("failed to replay request body: %w", err)
+		}
+		next.Body = body
+	}
+
+	if target == "" {
+		if lease := leaseFor(prev); lease != nil {
+			renewed := lease.renew()
+			*next = *next.WithContext(context.WithValue(next.Context(), upstreamContextKey, renewed))
+		}
+		return next, nil
+	}
+
+	if err := Retarget(next, target); err != nil {
+		return nil, err
+	}
+	return next, nil
+}
+
+// shouldRetry reports whether an attempt failed in a retryable way, and why
+func (t *retryTransport) shouldRetry(ctx context.Context, resp *http.Response, err error) (string, bool) {
+	if err != nil {
+		// The client went away; retrying would only waste upstream capacity
+		if ctx.Err() != nil {
+			return "", false
+		}
+		return err.Error(), t.onNetworkError
+	}
+	if slices.Contains(t.statuses, resp.StatusCode) {
+		return fmt.Sprintf("status %d", resp.StatusCode), true
+	}
+	return "", false
+}
+
+// delay returns exponential backoff for the given retry number, capped at maxBackoff
+func (t *retryTransport) delay(retry int) time.Duration {
+	d := t.backoff
+	for i := 1; i < retry && d < t.maxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, t.maxBackoff)
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
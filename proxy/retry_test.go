@@ -0,0 +1,155 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spicyneuron/llama-matchmaker/config"
+)
+
+func noSleep(context.Context, time.Duration) error { return nil }
+
+func newRetryRequest(t *testing.T, balancer *Balancer, body string) *http.Request {
+	t.Helper()
+	req := httptest.NewRequest("POST", "http://proxy/v1/chat/completions", nil)
+	balancer.Direct(req)
+	setRequestBody(req, []byte(body))
+	req.RequestURI = ""
+	return req
+}
+
+func TestRetryTransportRetriesStatusAndReplaysBody(t *testing.T) {
+	var calls atomic.Int32
+	backend, closeBackend := newSafeTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if string(body) != `{"model":"qwen"}` {
+			t.Errorf("attempt %d got body %q", calls.Load()+1, body)
+		}
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"ok":true}`))
+	})
+	if backend == nil {
+		return
+	}
+	defer closeBackend()
+
+	cfg := config.ProxyConfig{Target: backend.URL, Retry: config.RetryConfig{Attempts: 3}}
+	balancer := newTestBalancer(t, cfg)
+	rt := NewRetryTransport(http.DefaultTransport, cfg).(*retryTransport)
+	rt.sleep = noSleep
+
+	resp, err := rt.RoundTrip(newRetryRequest(t, balancer, `{"model":"qwen"}`))
+	if err != nil {
+		t.Fatalf("RoundTrip error: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || calls.Load() != 3 {
+		t.Fatalf("status=%d calls=%d, want 200 after 3 attempts", resp.StatusCode, calls.Load())
+	}
+}
+
+func TestRetryTransportFailsOverToFallback(t *testing.T) {
+	fallback, closeFallback := newSafeTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"from":"fallback"}`))
+	})
+	if fallback == nil {
+		return
+	}
+	defer closeFallback()
+
+	// A closed server refuses connections
+	dead, closeDead := newSafeTestServer(t, func(w http.ResponseWriter, r *http.Request) {})
+	if dead == nil {
+		return
+	}
+	closeDead()
+
+	cfg := config.ProxyConfig{Target: dead.URL, Fallbacks: []string{fallback.URL}}
+	balancer := newTestBalancer(t, cfg)
+	rt := NewRetryTransport(http.DefaultTransport, cfg).(*retryTransport)
+	rt.sleep = noSleep
+
+	resp, err := rt.RoundTrip(newRetryRequest(t, balancer, `{}`))
+	if err != nil {
+		t.Fatalf("RoundTrip error: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if !bytes.Contains(body, []byte("fallback")) {
+		t.Fatalf("expected fallback response, got %s", body)
+	}
+	if UpstreamFor(resp.Request) != fallback.URL {
+		t.Fatalf("UpstreamFor(resp.Request) = %q, want fallback", UpstreamFor(resp.Request))
+	}
+	if balancer.Upstreams()[0].outstanding != 0 {
+		t.Fatalf("expected failed primary lease released, outstanding=%d", balancer.Upstreams()[0].outstanding)
+	}
+}
+
+func TestRetryTransportPassesThroughFinalAndNonRetryable(t *testing.T) {
+	var calls atomic.Int32
+	backend, closeBackend := newSafeTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if r.URL.Path == "/bad" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	if backend == nil {
+		return
+	}
+	defer closeBackend()
+
+	cfg := config.ProxyConfig{Target: backend.URL, Retry: config.RetryConfig{Attempts: 2}}
+	balancer := newTestBalancer(t, cfg)
+	rt := NewRetryTransport(http.DefaultTransport, cfg).(*retryTransport)
+	rt.sleep = noSleep
+
+	resp, err := rt.RoundTrip(newRetryRequest(t, balancer, `{}`))
+	if err != nil {
+		t.Fatalf("RoundTrip error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadGateway || calls.Load() != 2 {
+		t.Fatalf("status=%d calls=%d, want final 502 after 2 attempts", resp.StatusCode, calls.Load())
+	}
+
+	calls.Store(0)
+	req := httptest.NewRequest("POST", "http://proxy/bad", nil)
+	balancer.Direct(req)
+	req.RequestURI = ""
+	resp, err = rt.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest || calls.Load() != 1 {
+		t.Fatalf("status=%d calls=%d, want single 400", resp.StatusCode, calls.Load())
+	}
+}
+
+func TestRetryTransportDelay(t *testing.T) {
+	rt := &retryTransport{backoff: 100 * time.Millisecond, maxBackoff: 350 * time.Millisecond}
+	want := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 350 * time.Millisecond, 350 * time.Millisecond}
+	for i, w := range want {
+		if got := rt.delay(i + 1); got != w {
+			t.Errorf("delay(%d) = %v, want %v", i+1, got, w)
+		}
+	}
+
+	if NewRetryTransport(http.DefaultTransport, config.ProxyConfig{}) != http.DefaultTransport {
+		t.Fatal("expected base transport when retries are not configured")
+	}
+}
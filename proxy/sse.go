@@ -0,0 +1,146 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+)
+
+// sseEvent is one Server-Sent Event, or one line of a stream without SSE framing
+type sseEvent struct {
+	Event string
+	ID    string
+	Retry string
+	Data  string
+
+	// hasData is set when the event had at least one data field
+	hasData bool
+	// bare marks an NDJSON-style line carried as a single data payload
+	bare bool
+	// lines holds the event as received so untouched events re-emit byte for byte
+	lines []string
+	// terminated is set when a blank line ended the event (not EOF)
+	terminated bool
+}
+
+// sseReader assembles events from an SSE stream
+// A line that opens with '{' outside an event is treated as bare JSON, so NDJSON
+// served as text/event-stream keeps working. With ndjson set every line is bare.
+type sseReader struct {
+	scanner *bufio.Scanner
+	ndjson  bool
+	lineNum int
+}
+
+func newSSEReader(r io.Reader, ndjson bool) *sseReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024) // 64KB initial, 1MB max line size
+	return &sseReader{scanner: scanner, ndjson: ndjson}
+}
+
+// Next returns the next event, or io.EOF once the stream is exhausted
+// Runs of blank lines between events come back as empty terminated events.
+func (r *sseReader) Next() (*sseEvent, error) {
+	ev := &sseEvent{}
+	var data []string
+
+	for r.scanner.Scan() {
+		r.lineNum++
+		line := r.scanner.Text()
+
+		if r.ndjson {
+			if line == "" {
+				continue
+			}
+			return &sseEvent{Data: line, hasData: true, bare: true, lines: []string{line}}, nil
+		}
+
+		if line == "" {
+			ev.terminated = true
+			break
+		}
+		if len(ev.lines) == 0 && strings.HasPrefix(line, "{") {
+			return &sseEvent{Data: line, hasData: true, bare: true, lines: []string{line}}, nil
+		}
+
+		ev.lines = append(ev.lines, line)
+		name, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch name {
+		case "":
+			// Comment
+		case "event":
+			ev.Event = value
+		case "data":
+			data = append(data, value)
+			ev.hasData = true
+		case "id":
+			if !strings.ContainsRune(value, 0) {
+				ev.ID = value
+			}
+		case "retry":
+			ev.Retry = value
+		}
+	}
+
+	if err := r.scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(ev.lines) == 0 && !ev.terminated {
+		return nil, io.EOF
+	}
+	ev.Data = strings.Join(data, "\n")
+	return ev, nil
+}
+
+// isDataField reports whether a raw event line is a data field
+func isDataField(line string) bool {
+	return line == "data" || strings.HasPrefix(line, "data:")
+}
+
+// raw returns the event exactly as it was received
+func (e *sseEvent) raw() []byte {
+	var b bytes.Buffer
+	for _, line := range e.lines {
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	if e.terminated {
+		b.WriteByte('\n')
+	}
+	return b.Bytes()
+}
+
+// encode re-emits the event with its data replaced by payload
+// Other fields and comments keep their order; multi-line data collapses into one data line.
+func (e *sseEvent) encode(payload []byte) []byte {
+	if e.bare {
+		return append(append([]byte(nil), payload...), '\n')
+	}
+
+	var b bytes.Buffer
+	wrote := false
+	for _, line := range e.lines {
+		if isDataField(line) {
+			if !wrote {
+				writeDataLine(&b, payload)
+				wrote = true
+			}
+			continue
+		}
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	if !wrote {
+		writeDataLine(&b, payload)
+	}
+	b.WriteByte('\n')
+	return b.Bytes()
+}
+
+func writeDataLine(b *bytes.Buffer, payload []byte) {
+	b.WriteString("data: ")
+	b.Write(payload)
+	b.WriteByte('\n')
+}
@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestSSEReaderAssemblesEvents(t *testing.T) {
+	stream := ": keep-alive\n" +
+		"\n" +
+		"event: content_block_delta\n" +
+		"id: 7\n" +
+		"retry: 3000\n" +
+		"data: {\"a\":\n" +
+		"data:1}\n" +
+		"\n" +
+		"{\"bare\":true}\n" +
+		"data: [DONE]\n"
+
+	r := newSSEReader(strings.NewReader(stream), false)
+	var events []*sseEvent
+	for {
+		ev, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		events = append(events, ev)
+	}
+
+	if len(events) != 4 {
+		t.Fatalf("expected 4 events, got %d: %+v", len(events), events)
+	}
+	if events[0].hasData || !events[0].terminated || string(events[0].raw()) != ": keep-alive\n\n" {
+		t.Errorf("unexpected comment event: %+v", events[0])
+	}
+
+	ev := events[1]
+	if ev.Event != "content_block_delta" || ev.ID != "7" || ev.Retry != "3000" || ev.Data != "{\"a\":\n1}" {
+		t.Errorf("unexpected fields: %+v", ev)
+	}
+	want := "event: content_block_delta\nid: 7\nretry: 3000\ndata: {\"a\":1}\n\n"
+	if got := string(ev.encode([]byte(`{"a":1}`))); got != want {
+		t.Errorf("encode = %q, want %q", got, want)
+	}
+
+	if !events[2].bare || events[2].Data != `{"bare":true}` {
+		t.Errorf("expected bare JSON line, got %+v", events[2])
+	}
+	if events[3].Data != "[DONE]" || events[3].terminated || string(events[3].raw()) != "data: [DONE]\n" {
+		t.Errorf("unexpected unterminated final event: %+v", events[3])
+	}
+}
+
+func TestSSEReaderNDJSON(t *testing.T) {
+	r := newSSEReader(strings.NewReader("{\"n\":1}\n\n{\"n\":2}\n"), true)
+	var data []string
+	for {
+		ev, err := r.Next()
+		if err != nil {
+			break
+		}
+		if !ev.bare {
+			t.Fatalf("expected bare lines in ndjson mode, got %+v", ev)
+		}
+		data = append(data, ev.Data)
+	}
+	if strings.Join(data, "|") != `{"n":1}|{"n":2}` {
+		t.Fatalf("unexpected lines: %v", data)
+	}
+}
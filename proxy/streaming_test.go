@@ -3,6 +3,7 @@ package proxy
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"io"
 	"net/http"
 	"net/url"
@@ -314,3 +315,319 @@ func TestModifyResponse_RoutesToStreaming(t *testing.T) {
 		})
 	}
 }
+
+func TestModifyStreamingResponse_StreamFormat(t *testing.T) {
+	tests := []struct {
+		name        string
+		format      string
+		contentType string
+		body        string
+		wantType    string
+		want        string
+	}{
+		{
+			name:        "ndjson to sse adds [DONE]",
+			format:      config.StreamFormatSSE,
+			contentType: "application/x-ndjson",
+			body:        "{\"n\":1}\n{\"n\":2}\n",
+			wantType:    "text/event-stream",
+			want:        "data: {\"n\":1,\"seen\":true}\n\ndata: {\"n\":2,\"seen\":true}\n\ndata: [DONE]\n\n",
+		},
+		{
+			name:        "sse keeps single [DONE]",
+			format:      config.StreamFormatSSE,
+			contentType: "text/event-stream",
+			body:        ": ping\n\ndata: {\"n\":1}\n\ndata: [DONE]\n\n",
+			wantType:    "text/event-stream",
+			want:        ": ping\n\ndata: {\"n\":1,\"seen\":true}\n\ndata: [DONE]\n\n",
+		},
+		{
+			name:        "sse to ndjson drops framing",
+			format:      config.StreamFormatNDJSON,
+			contentType: "text/event-stream",
+			body:        "data: {\"n\":1}\n\n: ping\ndata: [DONE]\n\n",
+			wantType:    "application/x-ndjson",
+			want:        "{\"n\":1,\"seen\":true}\n",
+		},
+		{
+			name:        "passthrough keeps upstream framing",
+			format:      config.StreamFormatPassthrough,
+			contentType: "text/event-stream",
+			body:        "data: {\"n\":1}\n\n",
+			wantType:    "text/event-stream",
+			want:        "data: {\"n\":1,\"seen\":true}\n\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			route := &config.Route{
+				Methods:      newPatternField("POST"),
+				Paths:        newPatternField("/stream"),
+				StreamFormat: tt.format,
+				OnResponse:   []config.Action{{Merge: map[string]any{"seen": true}}},
+			}
+			cfg := &config.Config{Proxies: []config.ProxyConfig{{Routes: []config.Route{*route}}}}
+			if err := config.CompileTemplates(cfg); err != nil {
+				t.Fatalf("CompileTemplates: %v", err)
+			}
+			route = &cfg.Proxies[0].Routes[0]
+
+			resp := &http.Response{
+				StatusCode: 200,
+				Header:     http.Header{"Content-Type": []string{tt.contentType}},
+				Body:       io.NopCloser(strings.NewReader(tt.body)),
+				Request:    &http.Request{Method: "POST", URL: mustParseURL("/stream")},
+			}
+			if err := ModifyStreamingResponse(resp, []*config.Route{route}, []int{0}); err != nil {
+				t.Fatalf("ModifyStreamingResponse failed: %v", err)
+			}
+
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if got := resp.Header.Get("Content-Type"); got != tt.wantType {
+				t.Errorf("Content-Type = %s, want %s", got, tt.wantType)
+			}
+			if string(body) != tt.want {
+				t.Errorf("body = %q, want %q", body, tt.want)
+			}
+		})
+	}
+}
+
+func TestModifyStreamingResponse_EventMetadata(t *testing.T) {
+	cfg := &config.Config{Proxies: []config.ProxyConfig{{Listen: "localhost:8080", Target: "http://localhost:9000", Routes: []config.Route{{
+		Methods: newPatternField("POST"),
+		Paths:   newPatternField("/v1/messages"),
+		OnResponse: []config.Action{
+			{
+				MatchBody: map[string]config.PatternField{"$event": newPatternField("^content_block_delta$")},
+				Merge:     map[string]any{"seen": true},
+			},
+			{
+				MatchBody: map[string]config.PatternField{"$event_id": newPatternField("^2$")},
+				Template:  `{"type": "{{ event }}", "id": "{{ eventId }}"}`,
+			},
+		},
+	}}}}}
+	if err := config.Validate(cfg); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	if err := config.CompileTemplates(cfg); err != nil {
+		t.Fatalf("CompileTemplates: %v", err)
+	}
+
+	stream := "event: message_start\nid: 1\ndata: {\"type\":\"message_start\"}\n\n" +
+		"event: content_block_delta\nid: 2\ndata: {\"type\":\"content_block_delta\"}\n\n"
+	resp := &http.Response{
+		StatusCode: 200,
+		Header:     http.Header{"Content-Type": []string{"text/event-stream"}},
+		Body:       io.NopCloser(strings.NewReader(stream)),
+		Request:    &http.Request{Method: "POST", URL: mustParseURL("/v1/messages")},
+	}
+	route := &cfg.Proxies[0].Routes[0]
+	if err := ModifyStreamingResponse(resp, []*config.Route{route}, []int{0}); err != nil {
+		t.Fatalf("ModifyStreamingResponse failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+
+	want := "event: message_start\nid: 1\ndata: {\"type\":\"message_start\"}\n\n" +
+		"event: content_block_delta\nid: 2\ndata: {\"id\":\"2\",\"type\":\"content_block_delta\"}\n\n"
+	if string(body) != want {
+		t.Fatalf("body = %q, want %q", body, want)
+	}
+}
+
+func TestModifyStreamingResponse_OnStreamEnd(t *testing.T) {
+	cfg := &config.Config{Proxies: []config.ProxyConfig{{Listen: "localhost:8080", Target: "http://localhost:9000", Routes: []config.Route{{
+		Methods:    newPatternField("POST"),
+		Paths:      newPatternField("/v1/chat/completions"),
+		OnResponse: []config.Action{{Merge: map[string]any{"seen": true}}},
+		OnStreamEnd: []config.Action{{
+			MatchBody: map[string]config.PatternField{"choices[0].finish_reason": newPatternField("^stop$")},
+			Template:  `{"summary": {"content": {{ toJson (index .choices 0).message.content }}, "model": "{{ .model }}"}}`,
+		}},
+	}}}}}
+	if err := config.Validate(cfg); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	if err := config.CompileTemplates(cfg); err != nil {
+		t.Fatalf("CompileTemplates: %v", err)
+	}
+
+	stream := "data: {\"model\":\"m\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"Hi \"}}]}\n\n" +
+		"data: {\"model\":\"m\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"there\"},\"finish_reason\":\"stop\"}]}\n\n" +
+		"data: [DONE]\n\n"
+	resp := &http.Response{
+		StatusCode: 200,
+		Header:     http.Header{"Content-Type": []string{"text/event-stream"}},
+		Body:       io.NopCloser(strings.NewReader(stream)),
+		Request:    &http.Request{Method: "POST", URL: mustParseURL("/v1/chat/completions")},
+	}
+	route := &cfg.Proxies[0].Routes[0]
+	if err := ModifyStreamingResponse(resp, []*config.Route{route}, []int{0}); err != nil {
+		t.Fatalf("ModifyStreamingResponse failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+
+	// Chunks pass through as on_response left them; the summary lands on a choice-less chunk before [DONE]
+	want := "data: {\"choices\":[{\"delta\":{\"content\":\"Hi \"},\"index\":0}],\"model\":\"m\",\"seen\":true}\n\n" +
+		"data: {\"choices\":[{\"delta\":{\"content\":\"there\"},\"finish_reason\":\"stop\",\"index\":0}],\"model\":\"m\",\"seen\":true}\n\n" +
+		"data: {\"choices\":[],\"model\":\"m\",\"object\":\"chat.completion.chunk\",\"summary\":{\"content\":\"Hi there\",\"model\":\"m\"}}\n\n" +
+		"data: [DONE]\n\n"
+	if string(body) != want {
+		t.Fatalf("body = %q, want %q", body, want)
+	}
+}
+
+func TestModifyStreamingResponse_SetStatus(t *testing.T) {
+	cfg := &config.Config{Proxies: []config.ProxyConfig{{Listen: "localhost:8080", Target: "http://localhost:9000", Routes: []config.Route{{
+		Methods: newPatternField("POST"),
+		Paths:   newPatternField("/v1/chat/completions"),
+		OnResponse: []config.Action{
+			{MatchStatus: config.StatusField{Values: []string{"503"}}, SetStatus: 502, SetHeaders: map[string]string{"X-Upstream-Status": "503"}},
+			{MatchStatus: config.StatusField{Values: []string{"503"}}, Merge: map[string]any{"failed": true}},
+		},
+	}}}}}
+	if err := config.Validate(cfg); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	if err := config.CompileTemplates(cfg); err != nil {
+		t.Fatalf("CompileTemplates: %v", err)
+	}
+
+	resp := &http.Response{
+		StatusCode: 503,
+		Header:     http.Header{"Content-Type": []string{"text/event-stream"}},
+		Body:       io.NopCloser(strings.NewReader("data: {\"error\":\"busy\"}\n\n")),
+		Request:    &http.Request{Method: "POST", URL: mustParseURL("/v1/chat/completions")},
+	}
+	route := &cfg.Proxies[0].Routes[0]
+	if err := ModifyStreamingResponse(resp, []*config.Route{route}, []int{0}); err != nil {
+		t.Fatalf("ModifyStreamingResponse failed: %v", err)
+	}
+
+	// Status and headers are final before the first chunk is read
+	if resp.StatusCode != 502 || resp.Header.Get("X-Upstream-Status") != "503" {
+		t.Fatalf("status = %d, headers = %v", resp.StatusCode, resp.Header)
+	}
+
+	// Chunks still match against the upstream's status
+	body, _ := io.ReadAll(resp.Body)
+	if want := "data: {\"error\":\"busy\",\"failed\":true}\n\n"; string(body) != want {
+		t.Fatalf("body = %q, want %q", body, want)
+	}
+}
+
+func TestModifyStreamingResponse_OnStreamEndDoesNotRepeatContent(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		stream      string
+		done        func(chunk map[string]any) bool
+		content     func(chunk map[string]any) string
+	}{
+		{
+			name:        "openai",
+			contentType: "text/event-stream",
+			stream: "data: {\"id\":\"c1\",\"object\":\"chat.completion.chunk\",\"model\":\"m\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"Hi \"}}]}\n\n" +
+				"data: {\"id\":\"c1\",\"object\":\"chat.completion.chunk\",\"model\":\"m\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"there\"},\"finish_reason\":\"stop\"}]}\n\n" +
+				"data: [DONE]\n\n",
+			done: func(chunk map[string]any) bool { return false },
+			content: func(chunk map[string]any) string {
+				choices, _ := chunk["choices"].([]any)
+				if len(choices) == 0 {
+					return ""
+				}
+				delta, _ := choices[0].(map[string]any)["delta"].(map[string]any)
+				text, _ := delta["content"].(string)
+				return text
+			},
+		},
+		{
+			name:        "ollama",
+			contentType: "application/x-ndjson",
+			stream: "{\"model\":\"m\",\"message\":{\"role\":\"assistant\",\"content\":\"Hi \"},\"done\":false}\n" +
+				"{\"model\":\"m\",\"message\":{\"role\":\"assistant\",\"content\":\"there\"},\"done\":false}\n" +
+				"{\"model\":\"m\",\"message\":{\"role\":\"assistant\",\"content\":\"\"},\"done\":true,\"eval_count\":2}\n",
+			done: func(chunk map[string]any) bool { done, _ := chunk["done"].(bool); return done },
+			content: func(chunk map[string]any) string {
+				message, _ := chunk["message"].(map[string]any)
+				text, _ := message["content"].(string)
+				return text
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{Proxies: []config.ProxyConfig{{Listen: "localhost:8080", Target: "http://localhost:9000", Routes: []config.Route{{
+				Methods:     newPatternField("POST"),
+				Paths:       newPatternField("/chat"),
+				OnStreamEnd: []config.Action{{Merge: map[string]any{"moderated": true}}},
+			}}}}}
+			if err := config.Validate(cfg); err != nil {
+				t.Fatalf("Validate: %v", err)
+			}
+			if err := config.CompileTemplates(cfg); err != nil {
+				t.Fatalf("CompileTemplates: %v", err)
+			}
+
+			resp := &http.Response{
+				StatusCode: 200,
+				Header:     http.Header{"Content-Type": []string{tt.contentType}},
+				Body:       io.NopCloser(strings.NewReader(tt.stream)),
+				Request:    &http.Request{Method: "POST", URL: mustParseURL("/chat")},
+			}
+			route := &cfg.Proxies[0].Routes[0]
+			if err := ModifyStreamingResponse(resp, []*config.Route{route}, []int{0}); err != nil {
+				t.Fatalf("ModifyStreamingResponse failed: %v", err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+
+			var content strings.Builder
+			dones, moderated := 0, 0
+			for _, payload := range streamPayloads(string(body)) {
+				if payload == "[DONE]" {
+					continue
+				}
+				var chunk map[string]any
+				if err := json.Unmarshal([]byte(payload), &chunk); err != nil {
+					t.Fatalf("client chunk is not JSON: %q", payload)
+				}
+				if object, ok := chunk["object"]; ok && object != "chat.completion.chunk" {
+					t.Errorf("chunk object = %v, want chat.completion.chunk", object)
+				}
+				content.WriteString(tt.content(chunk))
+				if tt.done(chunk) {
+					dones++
+				}
+				if chunk["moderated"] == true {
+					moderated++
+				}
+			}
+
+			if content.String() != "Hi there" {
+				t.Errorf("client content = %q, want it streamed once\n%s", content.String(), body)
+			}
+			if moderated != 1 {
+				t.Errorf("on_stream_end fields sent %d times, want once\n%s", moderated, body)
+			}
+			if tt.name == "ollama" && dones != 1 {
+				t.Errorf("done chunks = %d, want 1\n%s", dones, body)
+			}
+		})
+	}
+}
+
+// streamPayloads splits an SSE or NDJSON body into its data payloads
+func streamPayloads(body string) []string {
+	var payloads []string
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimPrefix(line, "data: ")
+		if strings.TrimSpace(line) != "" {
+			payloads = append(payloads, line)
+		}
+	}
+	return payloads
+}
@@ -0,0 +1,820 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/spicyneuron/llama-matchmaker/config"
+	"github.com/spicyneuron/llama-matchmaker/logger"
+)
+
+// Endpoints a translator understands
+const (
+	endpointChat   = "chat"
+	endpointModels = "models"
+)
+
+var (
+	ollamaPaths = map[string]string{endpointChat: "/api/chat", endpointModels: "/api/tags"}
+	openAIPaths = map[string]string{endpointChat: "/v1/chat/completions", endpointModels: "/v1/models"}
+)
+
+// Ollama options that map onto OpenAI request fields
+var ollamaToOpenAIOptions = map[string]string{
+	"temperature":       "temperature",
+	"top_p":             "top_p",
+	"top_k":             "top_k",
+	"min_p":             "min_p",
+	"seed":              "seed",
+	"stop":              "stop",
+	"num_predict":       "max_tokens",
+	"frequency_penalty": "frequency_penalty",
+	"presence_penalty":  "presence_penalty",
+	"repeat_penalty":    "repeat_penalty",
+}
+
+// translator converts one exchange between the client's protocol and the upstream's
+// Requests are translated after on_request actions and responses before on_response actions,
+// so actions always see the client's protocol.
+type translator struct {
+	mode     string
+	endpoint string
+
+	// Streaming state, carried across chunks of one response
+	id        string
+	model     string
+	created   int64
+	sentRole  bool
+	sawTools  bool
+	finish    string
+	usage     map[string]any
+	toolCalls []map[string]any
+	finished  bool
+
+	// Tool calls sent so far, so indices and ids keep counting across chunks
+	toolCount int
+}
+
+// newRouteTranslator returns a translator for the first matched route with translate set
+// Returns nil when no route translates or the path is not a translatable endpoint.
+func newRouteTranslator(routes []*config.Route, path string) *translator {
+	for _, route := range routes {
+		if route.Translate == "" {
+			continue
+		}
+		t := &translator{mode: route.Translate}
+		client, _ := t.paths()
+		for endpoint, suffix := range client {
+			if strings.HasSuffix(path, suffix) {
+				t.endpoint = endpoint
+				return t
+			}
+		}
+		logger.Debug("Translation skipped for unsupported endpoint", "mode", route.Translate, "path", path)
+		return nil
+	}
+	return nil
+}
+
+// translatorFor returns the translator attached to a request by ModifyRequest
+func translatorFor(req *http.Request) *translator {
+	if rc := routeContextFor(req); rc != nil {
+		return rc.translator
+	}
+	return nil
+}
+
+func (t *translator) clientIsOllama() bool {
+	return t.mode == config.TranslateOllamaToOpenAI
+}
+
+func (t *translator) paths() (client, upstream map[string]string) {
+	if t.clientIsOllama() {
+		return ollamaPaths, openAIPaths
+	}
+	return openAIPaths, ollamaPaths
+}
+
+// rewritePath swaps the client endpoint for the upstream one, keeping any base path
+// Paths already rewritten elsewhere (ex: target_path) are left alone.
+func (t *translator) rewritePath(path string) string {
+	client, upstream := t.paths()
+	if !strings.HasSuffix(path, client[t.endpoint]) {
+		return path
+	}
+	return strings.TrimSuffix(path, client[t.endpoint]) + upstream[t.endpoint]
+}
+
+// request converts a client request body into the upstream protocol
+func (t *translator) request(data map[string]any) map[string]any {
+	if t.endpoint != endpointChat {
+		return data
+	}
+	if t.clientIsOllama() {
+		return ollamaToOpenAIRequest(data)
+	}
+	return openAIToOllamaRequest(data)
+}
+
+// response converts an upstream JSON response body into the client protocol
+// Returns false when the body is not JSON and should pass through unchanged.
+func (t *translator) response(body []byte, status int) ([]byte, bool) {
+	var data map[string]any
+	if err := json.Unmarshal(body, &data); err != nil {
+		return nil, false
+	}
+
+	var out map[string]any
+	switch {
+	case status >= 400:
+		out = t.errorBody(data)
+	case t.endpoint == endpointModels && t.clientIsOllama():
+		out = openAIModelsToOllamaTags(data)
+	case t.endpoint == endpointModels:
+		out = ollamaTagsToOpenAIModels(data)
+	case t.clientIsOllama():
+		out = openAIToOllamaResponse(data)
+	default:
+		out = ollamaToOpenAIResponse(data)
+	}
+
+	translated, err := json.Marshal(out)
+	if err != nil {
+		logger.Error("Failed to marshal translated response", "mode", t.mode, "err", err)
+		return nil, false
+	}
+	return translated, true
+}
+
+// errorBody converts between Ollama's string errors and OpenAI's error objects
+func (t *translator) errorBody(data map[string]any) map[string]any {
+	if t.clientIsOllama() {
+		if e, ok := data["error"].(map[string]any); ok {
+			return map[string]any{"error": fmt.Sprint(e["message"])}
+		}
+		return data
+	}
+	if msg, ok := data["error"].(string); ok {
+		return map[string]any{"error": map[string]any{"message": msg, "type": "api_error"}}
+	}
+	return data
+}
+
+// clientStreamFormat is the stream framing the client's protocol expects
+func (t *translator) clientStreamFormat() string {
+	if t.clientIsOllama() {
+		return config.StreamFormatNDJSON
+	}
+	return config.StreamFormatSSE
+}
+
+// streamChunk converts one upstream stream payload into zero or more client chunks
+func (t *translator) streamChunk(payload string) []map[string]any {
+	payload = strings.TrimSpace(payload)
+	if payload == "" {
+		return nil
+	}
+	if payload == "[DONE]" {
+		return t.streamEnd()
+	}
+
+	var data map[string]any
+	if err := json.Unmarshal([]byte(payload), &data); err != nil {
+		logger.Debug("Dropping untranslatable streaming line", "mode", t.mode, "line", payload)
+		return nil
+	}
+	if t.clientIsOllama() {
+		return t.openAIChunkToOllama(data)
+	}
+	return t.ollamaChunkToOpenAI(data)
+}
+
+// streamEnd returns the closing chunk if the upstream did not already send one
+func (t *translator) streamEnd() []map[string]any {
+	if t.finished {
+		return nil
+	}
+	t.finished = true
+
+	if !t.clientIsOllama() {
+		return []map[string]any{t.openAIChunk(map[string]any{}, "stop", nil)}
+	}
+
+	var chunks []map[string]any
+	if len(t.toolCalls) > 0 {
+		chunks = append(chunks, t.ollamaChunk(map[string]any{"role": "assistant", "content": "", "tool_calls": t.flushToolCalls()}, false))
+	}
+	final := t.ollamaChunk(map[string]any{"role": "assistant", "content": ""}, true)
+	final["done_reason"] = ollamaDoneReason(t.finish)
+	if t.usage != nil {
+		final["prompt_eval_count"] = t.usage["prompt_tokens"]
+		final["eval_count"] = t.usage["completion_tokens"]
+	}
+	return append(chunks, final)
+}
+
+// openAIChunkToOllama converts an OpenAI chat.completion.chunk into Ollama stream messages
+// Tool call fragments are buffered until the choice finishes.
+func (t *translator) openAIChunkToOllama(data map[string]any) []map[string]any {
+	if model, ok := data["model"].(string); ok {
+		t.model = model
+	}
+	if created, ok := toInt64(data["created"]); ok {
+		t.created = created
+	}
+	if usage, ok := data["usage"].(map[string]any); ok {
+		t.usage = usage
+	}
+
+	choices, _ := data["choices"].([]any)
+	if len(choices) == 0 {
+		return nil
+	}
+	choice, _ := choices[0].(map[string]any)
+	delta, _ := choice["delta"].(map[string]any)
+
+	var chunks []map[string]any
+	message := map[string]any{"role": "assistant", "content": stringValue(delta["content"])}
+	if thinking := stringValue(delta["reasoning_content"]); thinking != "" {
+		message["thinking"] = thinking
+	}
+	if message["content"] != "" || message["thinking"] != nil {
+		chunks = append(chunks, t.ollamaChunk(message, false))
+	}
+
+	if calls, ok := delta["tool_calls"].([]any); ok {
+		t.bufferToolCalls(calls)
+	}
+
+	if reason, ok := choice["finish_reason"].(string); ok && reason != "" {
+		t.finish = reason
+		if len(t.toolCalls) > 0 {
+			chunks = append(chunks, t.ollamaChunk(map[string]any{"role": "assistant", "content": "", "tool_calls": t.flushToolCalls()}, false))
+		}
+	}
+	return chunks
+}
+
+// bufferToolCalls merges streamed tool call deltas by index
+func (t *translator) bufferToolCalls(calls []any) {
+	t.toolCalls = mergeToolCallDeltas(t.toolCalls, calls)
+}
+
+func (t *translator) flushToolCalls() []any {
+	calls := make([]any, 0, len(t.toolCalls))
+	for _, call := range t.toolCalls {
+		fn := call["function"].(map[string]any)
+		calls = append(calls, map[string]any{"function": map[string]any{
+			"name":      fn["name"],
+			"arguments": parseArguments(fn["arguments"]),
+		}})
+	}
+	t.toolCalls = nil
+	return calls
+}
+
+func (t *translator) ollamaChunk(message map[string]any, done bool) map[string]any {
+	return map[string]any{
+		"model":      t.model,
+		"created_at": unixToRFC3339(t.created),
+		"message":    message,
+		"done":       done,
+	}
+}
+
+// ollamaChunkToOpenAI converts an Ollama stream message into OpenAI chat.completion.chunk objects
+func (t *translator) ollamaChunkToOpenAI(data map[string]any) []map[string]any {
+	if t.id == "" {
+		t.id = newChatID()
+	}
+	if model, ok := data["model"].(string); ok {
+		t.model = model
+	}
+	if t.created == 0 {
+		t.created = rfc3339ToUnix(data["created_at"])
+	}
+
+	var chunks []map[string]any
+	message, _ := data["message"].(map[string]any)
+	delta := map[string]any{}
+	if !t.sentRole {
+		delta["role"] = "assistant"
+		t.sentRole = true
+	}
+	if content := stringValue(message["content"]); content != "" || delta["role"] != nil {
+		delta["content"] = content
+	}
+	if thinking := stringValue(message["thinking"]); thinking != "" {
+		delta["reasoning_content"] = thinking
+	}
+	if calls, ok := message["tool_calls"].([]any); ok && len(calls) > 0 {
+		base := t.toolCount
+		converted := ollamaToOpenAIToolCalls(calls, func(i int) string {
+			return fmt.Sprintf("call_%d", base+i)
+		})
+		for i, call := range converted {
+			call.(map[string]any)["index"] = base + i
+		}
+		t.toolCount += len(converted)
+		delta["tool_calls"] = converted
+		t.sawTools = true
+	}
+	if len(delta) > 0 {
+		chunks = append(chunks, t.openAIChunk(delta, "", nil))
+	}
+
+	if done, _ := data["done"].(bool); done {
+		t.finished = true
+		reason := openAIFinishReason(stringValue(data["done_reason"]), t.sawTools)
+		chunks = append(chunks, t.openAIChunk(map[string]any{}, reason, ollamaUsage(data)))
+	}
+	return chunks
+}
+
+func (t *translator) openAIChunk(delta map[string]any, finish string, usage map[string]any) map[string]any {
+	if t.id == "" {
+		t.id = newChatID()
+	}
+	var finishReason any
+	if finish != "" {
+		finishReason = finish
+	}
+	chunk := map[string]any{
+		"id":      t.id,
+		"object":  "chat.completion.chunk",
+		"created": t.created,
+		"model":   t.model,
+		"choices": []any{map[string]any{"index": 0, "delta": delta, "finish_reason": finishReason}},
+	}
+	if usage != nil {
+		chunk["usage"] = usage
+	}
+	return chunk
+}
+
+// ollamaToOpenAIRequest converts an Ollama /api/chat body into a chat completions body
+// Ollama streams unless told otherwise, so stream is always set explicitly.
+func ollamaToOpenAIRequest(in map[string]any) map[string]any {
+	out := make(map[string]any, len(in))
+	for k, v := range in {
+		switch k {
+		case "messages":
+			msgs, _ := v.([]any)
+			out["messages"] = ollamaToOpenAIMessages(msgs)
+		case "options":
+			opts, _ := v.(map[string]any)
+			for name, val := range opts {
+				if mapped, ok := ollamaToOpenAIOptions[name]; ok {
+					out[mapped] = val
+				}
+			}
+		case "format":
+			if v == "json" {
+				out["response_format"] = map[string]any{"type": "json_object"}
+			} else if schema, ok := v.(map[string]any); ok {
+				out["response_format"] = map[string]any{
+					"type":        "json_schema",
+					"json_schema": map[string]any{"name": "response", "schema": schema},
+				}
+			}
+		case "stream", "keep_alive", "think":
+		default:
+			out[k] = v
+		}
+	}
+
+	stream := true
+	if s, ok := in["stream"].(bool); ok {
+		stream = s
+	}
+	out["stream"] = stream
+	if stream {
+		out["stream_options"] = map[string]any{"include_usage": true}
+	}
+	return out
+}
+
+// ollamaToOpenAIMessages converts messages, pairing tool results with the calls that produced them
+func ollamaToOpenAIMessages(msgs []any) []any {
+	type pendingCall struct{ name, id string }
+	var pending []pendingCall
+	out := make([]any, 0, len(msgs))
+
+	for mi, raw := range msgs {
+		msg, ok := raw.(map[string]any)
+		if !ok {
+			out = append(out, raw)
+			continue
+		}
+		converted := make(map[string]any, len(msg))
+		for k, v := range msg {
+			switch k {
+			case "images", "tool_calls", "thinking", "tool_name":
+			default:
+				converted[k] = v
+			}
+		}
+
+		if images, ok := msg["images"].([]any); ok && len(images) > 0 {
+			parts := []any{map[string]any{"type": "text", "text": stringValue(msg["content"])}}
+			for _, img := range images {
+				data := stringValue(img)
+				parts = append(parts, map[string]any{
+					"type":      "image_url",
+					"image_url": map[string]any{"url": "data:" + imageMIME(data) + ";base64," + data},
+				})
+			}
+			converted["content"] = parts
+		}
+
+		if calls, ok := msg["tool_calls"].([]any); ok && len(calls) > 0 {
+			converted["tool_calls"] = ollamaToOpenAIToolCalls(calls, func(i int) string {
+				return fmt.Sprintf("call_%d_%d", mi, i)
+			})
+			for _, call := range converted["tool_calls"].([]any) {
+				c := call.(map[string]any)
+				fn := c["function"].(map[string]any)
+				pending = append(pending, pendingCall{name: stringValue(fn["name"]), id: c["id"].(string)})
+			}
+		}
+
+		if msg["role"] == "tool" {
+			name := stringValue(msg["tool_name"])
+			if name != "" {
+				converted["name"] = name
+			}
+			for i, p := range pending {
+				if name == "" || p.name == name {
+					converted["tool_call_id"] = p.id
+					pending = append(pending[:i], pending[i+1:]...)
+					break
+				}
+			}
+		}
+		out = append(out, converted)
+	}
+	return out
+}
+
+// ollamaToOpenAIToolCalls converts object arguments to JSON strings and assigns call IDs
+func ollamaToOpenAIToolCalls(calls []any, idFor func(int) string) []any {
+	out := make([]any, 0, len(calls))
+	for i, raw := range calls {
+		call, _ := raw.(map[string]any)
+		fn, _ := call["function"].(map[string]any)
+		args, err := json.Marshal(fn["arguments"])
+		if err != nil || fn["arguments"] == nil {
+			args = []byte("{}")
+		}
+		id := stringValue(call["id"])
+		if id == "" {
+			id = fmt.Sprintf("call_%d", i)
+			if idFor != nil {
+				id = idFor(i)
+			}
+		}
+		out = append(out, map[string]any{
+			"id":   id,
+			"type": "function",
+			"function": map[string]any{
+				"name":      fn["name"],
+				"arguments": string(args),
+			},
+		})
+	}
+	return out
+}
+
+// openAIToOllamaRequest converts a chat completions body into an Ollama /api/chat body
+func openAIToOllamaRequest(in map[string]any) map[string]any {
+	out := make(map[string]any, len(in))
+	options := map[string]any{}
+	for k, v := range in {
+		switch k {
+		case "messages":
+			msgs, _ := v.([]any)
+			out["messages"] = openAIToOllamaMessages(msgs)
+		case "max_tokens", "max_completion_tokens":
+			options["num_predict"] = v
+		case "stop":
+			if s, ok := v.(string); ok {
+				v = []any{s}
+			}
+			options["stop"] = v
+		case "response_format":
+			rf, _ := v.(map[string]any)
+			switch rf["type"] {
+			case "json_object":
+				out["format"] = "json"
+			case "json_schema":
+				if js, ok := rf["json_schema"].(map[string]any); ok && js["schema"] != nil {
+					out["format"] = js["schema"]
+				}
+			}
+		case "stream_options", "n", "user", "logprobs", "top_logprobs", "parallel_tool_calls", "tool_choice":
+		default:
+			if isOllamaOption(k) {
+				options[k] = v
+			} else {
+				out[k] = v
+			}
+		}
+	}
+
+	if len(options) > 0 {
+		out["options"] = options
+	}
+	// OpenAI defaults to a single response; Ollama defaults to streaming
+	stream, _ := in["stream"].(bool)
+	out["stream"] = stream
+	return out
+}
+
+func isOllamaOption(name string) bool {
+	mapped, ok := ollamaToOpenAIOptions[name]
+	return ok && mapped == name
+}
+
+// openAIToOllamaMessages flattens content parts and resolves tool results to tool names
+func openAIToOllamaMessages(msgs []any) []any {
+	names := map[string]string{}
+	out := make([]any, 0, len(msgs))
+	for _, raw := range msgs {
+		msg, ok := raw.(map[string]any)
+		if !ok {
+			out = append(out, raw)
+			continue
+		}
+		converted := openAIToOllamaMessage(msg)
+		if calls, ok := msg["tool_calls"].([]any); ok {
+			for _, c := range calls {
+				call, _ := c.(map[string]any)
+				fn, _ := call["function"].(map[string]any)
+				names[stringValue(call["id"])] = stringValue(fn["name"])
+			}
+		}
+		if id := stringValue(msg["tool_call_id"]); id != "" {
+			delete(converted, "tool_call_id")
+			if name := names[id]; name != "" {
+				converted["tool_name"] = name
+			}
+		}
+		out = append(out, converted)
+	}
+	return out
+}
+
+func openAIToOllamaMessage(msg map[string]any) map[string]any {
+	converted := make(map[string]any, len(msg))
+	for k, v := range msg {
+		switch k {
+		case "content", "tool_calls", "reasoning_content", "refusal", "annotations":
+		default:
+			converted[k] = v
+		}
+	}
+	if converted["role"] == nil {
+		converted["role"] = "assistant"
+	}
+
+	switch content := msg["content"].(type) {
+	case string:
+		converted["content"] = content
+	case []any:
+		var texts []string
+		var images []any
+		for _, p := range content {
+			part, _ := p.(map[string]any)
+			switch part["type"] {
+			case "text":
+				texts = append(texts, stringValue(part["text"]))
+			case "image_url":
+				img, _ := part["image_url"].(map[string]any)
+				url := stringValue(img["url"])
+				if _, data, ok := strings.Cut(url, ";base64,"); ok && strings.HasPrefix(url, "data:") {
+					images = append(images, data)
+				} else {
+					logger.Debug("Dropping remote image; Ollama only accepts inline images", "url", url)
+				}
+			}
+		}
+		converted["content"] = strings.Join(texts, "\n")
+		if len(images) > 0 {
+			converted["images"] = images
+		}
+	default:
+		converted["content"] = ""
+	}
+
+	if thinking := stringValue(msg["reasoning_content"]); thinking != "" {
+		converted["thinking"] = thinking
+	}
+
+	if calls, ok := msg["tool_calls"].([]any); ok && len(calls) > 0 {
+		out := make([]any, 0, len(calls))
+		for _, c := range calls {
+			call, _ := c.(map[string]any)
+			fn, _ := call["function"].(map[string]any)
+			out = append(out, map[string]any{"function": map[string]any{
+				"name":      fn["name"],
+				"arguments": parseArguments(fn["arguments"]),
+			}})
+		}
+		converted["tool_calls"] = out
+	}
+	return converted
+}
+
+// openAIToOllamaResponse converts a chat.completion into an Ollama /api/chat response
+func openAIToOllamaResponse(in map[string]any) map[string]any {
+	created, _ := toInt64(in["created"])
+	out := map[string]any{
+		"model":       in["model"],
+		"created_at":  unixToRFC3339(created),
+		"message":     map[string]any{"role": "assistant", "content": ""},
+		"done":        true,
+		"done_reason": "stop",
+	}
+
+	if choices, ok := in["choices"].([]any); ok && len(choices) > 0 {
+		choice, _ := choices[0].(map[string]any)
+		if msg, ok := choice["message"].(map[string]any); ok {
+			out["message"] = openAIToOllamaMessage(msg)
+		}
+		out["done_reason"] = ollamaDoneReason(stringValue(choice["finish_reason"]))
+	}
+	if usage, ok := in["usage"].(map[string]any); ok {
+		out["prompt_eval_count"] = usage["prompt_tokens"]
+		out["eval_count"] = usage["completion_tokens"]
+	}
+	return out
+}
+
+// ollamaToOpenAIResponse converts an Ollama /api/chat response into a chat.completion
+func ollamaToOpenAIResponse(in map[string]any) map[string]any {
+	msg, _ := in["message"].(map[string]any)
+	message := map[string]any{"role": "assistant", "content": stringValue(msg["content"])}
+	if role, ok := msg["role"].(string); ok {
+		message["role"] = role
+	}
+	if thinking := stringValue(msg["thinking"]); thinking != "" {
+		message["reasoning_content"] = thinking
+	}
+	calls, _ := msg["tool_calls"].([]any)
+	if len(calls) > 0 {
+		message["tool_calls"] = ollamaToOpenAIToolCalls(calls, nil)
+	}
+
+	out := map[string]any{
+		"id":      newChatID(),
+		"object":  "chat.completion",
+		"created": rfc3339ToUnix(in["created_at"]),
+		"model":   in["model"],
+		"choices": []any{map[string]any{
+			"index":         0,
+			"message":       message,
+			"finish_reason": openAIFinishReason(stringValue(in["done_reason"]), len(calls) > 0),
+		}},
+	}
+	if usage := ollamaUsage(in); usage != nil {
+		out["usage"] = usage
+	}
+	return out
+}
+
+// openAIModelsToOllamaTags converts a /v1/models list into an /api/tags response
+func openAIModelsToOllamaTags(in map[string]any) map[string]any {
+	data, _ := in["data"].([]any)
+	models := make([]any, 0, len(data))
+	for _, raw := range data {
+		m, _ := raw.(map[string]any)
+		created, _ := toInt64(m["created"])
+		models = append(models, map[string]any{
+			"name":        m["id"],
+			"model":       m["id"],
+			"modified_at": unixToRFC3339(created),
+			"size":        0,
+			"digest":      "",
+			"details":     map[string]any{},
+		})
+	}
+	return map[string]any{"models": models}
+}
+
+// ollamaTagsToOpenAIModels converts an /api/tags response into a /v1/models list
+func ollamaTagsToOpenAIModels(in map[string]any) map[string]any {
+	tags, _ := in["models"].([]any)
+	data := make([]any, 0, len(tags))
+	for _, raw := range tags {
+		m, _ := raw.(map[string]any)
+		data = append(data, map[string]any{
+			"id":       m["name"],
+			"object":   "model",
+			"created":  rfc3339ToUnix(m["modified_at"]),
+			"owned_by": "library",
+		})
+	}
+	return map[string]any{"object": "list", "data": data}
+}
+
+func ollamaUsage(data map[string]any) map[string]any {
+	prompt, okPrompt := toInt64(data["prompt_eval_count"])
+	completion, okCompletion := toInt64(data["eval_count"])
+	if !okPrompt && !okCompletion {
+		return nil
+	}
+	return map[string]any{
+		"prompt_tokens":     prompt,
+		"completion_tokens": completion,
+		"total_tokens":      prompt + completion,
+	}
+}
+
+func openAIFinishReason(doneReason string, toolCalls bool) string {
+	switch {
+	case toolCalls:
+		return "tool_calls"
+	case doneReason == "length":
+		return "length"
+	}
+	return "stop"
+}
+
+func ollamaDoneReason(finishReason string) string {
+	if finishReason == "length" {
+		return "length"
+	}
+	return "stop"
+}
+
+// parseArguments turns OpenAI's JSON-string tool arguments into the object Ollama expects
+func parseArguments(v any) any {
+	s, ok := v.(string)
+	if !ok {
+		return v
+	}
+	if strings.TrimSpace(s) == "" {
+		return map[string]any{}
+	}
+	var args any
+	if err := json.Unmarshal([]byte(s), &args); err != nil {
+		logger.Debug("Tool call arguments are not JSON, passing as string", "arguments", s)
+		return s
+	}
+	return args
+}
+
+// imageMIME guesses an image type from the start of its base64 data
+func imageMIME(data string) string {
+	switch {
+	case strings.HasPrefix(data, "/9j/"):
+		return "image/jpeg"
+	case strings.HasPrefix(data, "R0lGOD"):
+		return "image/gif"
+	case strings.HasPrefix(data, "UklGR"):
+		return "image/webp"
+	}
+	return "image/png"
+}
+
+func unixToRFC3339(sec int64) string {
+	if sec == 0 {
+		return time.Now().UTC().Format(time.RFC3339Nano)
+	}
+	return time.Unix(sec, 0).UTC().Format(time.RFC3339Nano)
+}
+
+func rfc3339ToUnix(v any) int64 {
+	if t, err := time.Parse(time.RFC3339Nano, stringValue(v)); err == nil {
+		return t.Unix()
+	}
+	return time.Now().Unix()
+}
+
+func toInt64(v any) (int64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return int64(n), true
+	case int:
+		return int64(n), true
+	case int64:
+		return n, true
+	}
+	return 0, false
+}
+
+func stringValue(v any) string {
+	s, _ := v.(string)
+	return s
+}
+
+func newChatID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return "chatcmpl-" + hex.EncodeToString(b)
+}
@@ -0,0 +1,186 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spicyneuron/llama-matchmaker/config"
+)
+
+func TestTranslateRequests(t *testing.T) {
+	ollama := map[string]any{
+		"model":  "llava",
+		"format": "json",
+		"options": map[string]any{
+			"num_predict": 64.0,
+			"num_ctx":     8192.0,
+		},
+		"messages": []any{
+			map[string]any{"role": "user", "content": "describe", "images": []any{"iVBORw0KGgo="}},
+			map[string]any{"role": "assistant", "content": "", "tool_calls": []any{
+				map[string]any{"function": map[string]any{"name": "lookup", "arguments": map[string]any{"q": "x"}}},
+			}},
+			map[string]any{"role": "tool", "tool_name": "lookup", "content": "found"},
+		},
+	}
+
+	openai := ollamaToOpenAIRequest(ollama)
+	if openai["max_tokens"] != 64.0 || openai["num_ctx"] != nil || openai["stream"] != true {
+		t.Fatalf("unexpected option mapping: %v", openai)
+	}
+	if !reflect.DeepEqual(openai["response_format"], map[string]any{"type": "json_object"}) {
+		t.Fatalf("unexpected response_format: %v", openai["response_format"])
+	}
+	msgs := openai["messages"].([]any)
+	parts := msgs[0].(map[string]any)["content"].([]any)
+	if url := parts[1].(map[string]any)["image_url"].(map[string]any)["url"]; url != "data:image/png;base64,iVBORw0KGgo=" {
+		t.Fatalf("unexpected image part: %v", url)
+	}
+	call := msgs[1].(map[string]any)["tool_calls"].([]any)[0].(map[string]any)
+	if call["function"].(map[string]any)["arguments"] != `{"q":"x"}` {
+		t.Fatalf("expected JSON string arguments, got %v", call)
+	}
+	if msgs[2].(map[string]any)["tool_call_id"] != call["id"] {
+		t.Fatalf("tool result not paired with call id %v: %v", call["id"], msgs[2])
+	}
+
+	// And back again: images, arguments and tool names are restored
+	back := openAIToOllamaRequest(openai)
+	backMsgs := back["messages"].([]any)
+	if !reflect.DeepEqual(backMsgs[0].(map[string]any)["images"], []any{"iVBORw0KGgo="}) {
+		t.Fatalf("images not restored: %v", backMsgs[0])
+	}
+	backCall := backMsgs[1].(map[string]any)["tool_calls"].([]any)[0].(map[string]any)
+	if !reflect.DeepEqual(backCall["function"].(map[string]any)["arguments"], map[string]any{"q": "x"}) {
+		t.Fatalf("arguments not restored: %v", backCall)
+	}
+	if backMsgs[2].(map[string]any)["tool_name"] != "lookup" {
+		t.Fatalf("tool_name not restored: %v", backMsgs[2])
+	}
+	if back["options"].(map[string]any)["num_predict"] != 64.0 || back["format"] != "json" || back["stream"] != true {
+		t.Fatalf("unexpected round trip: %v", back)
+	}
+}
+
+func TestTranslateStreamOllamaToOpenAI(t *testing.T) {
+	stream := `{"model":"llama3.2","created_at":"2024-07-22T20:33:28Z","message":{"role":"assistant","content":"Hi"},"done":false}
+{"model":"llama3.2","created_at":"2024-07-22T20:33:28Z","message":{"role":"assistant","content":"","tool_calls":[{"function":{"name":"lookup","arguments":{"q":"x"}}}]},"done":false}
+{"model":"llama3.2","created_at":"2024-07-22T20:33:29Z","message":{"role":"assistant","content":""},"done":true,"done_reason":"stop","prompt_eval_count":10,"eval_count":5}
+`
+	route := config.Route{Translate: config.TranslateOpenAIToOllama}
+	req, _ := http.NewRequest("POST", "http://proxy/v1/chat/completions", nil)
+	tr := newRouteTranslator([]*config.Route{&route}, req.URL.Path)
+	if tr == nil || tr.endpoint != endpointChat {
+		t.Fatalf("expected chat translator, got %+v", tr)
+	}
+	req = req.WithContext(context.WithValue(req.Context(), routeContextKey, &responseRouteContext{
+		rules: []*config.Route{&route}, indices: []int{0}, translator: tr,
+	}))
+
+	resp := &http.Response{
+		StatusCode: 200,
+		Header:     http.Header{"Content-Type": []string{"application/x-ndjson"}},
+		Body:       io.NopCloser(strings.NewReader(stream)),
+		Request:    req,
+	}
+	if err := ModifyResponse(resp, nil); err != nil {
+		t.Fatalf("ModifyResponse: %v", err)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %s, want text/event-stream", ct)
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	events := strings.Split(strings.TrimSpace(string(body)), "\n\n")
+	if len(events) != 4 || events[3] != "data: [DONE]" {
+		t.Fatalf("expected 3 chunks and [DONE], got %q", body)
+	}
+
+	var chunks []map[string]any
+	for _, e := range events[:3] {
+		var chunk map[string]any
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(e, "data: ")), &chunk); err != nil {
+			t.Fatalf("invalid chunk %q: %v", e, err)
+		}
+		chunks = append(chunks, chunk)
+	}
+
+	first := chunks[0]["choices"].([]any)[0].(map[string]any)["delta"].(map[string]any)
+	if first["role"] != "assistant" || first["content"] != "Hi" {
+		t.Fatalf("unexpected first delta: %v", first)
+	}
+	if chunks[0]["id"] != chunks[2]["id"] {
+		t.Fatalf("chunk ids differ: %v vs %v", chunks[0]["id"], chunks[2]["id"])
+	}
+	last := chunks[2]["choices"].([]any)[0].(map[string]any)
+	if last["finish_reason"] != "tool_calls" || chunks[2]["usage"].(map[string]any)["total_tokens"] != 15.0 {
+		t.Fatalf("unexpected final chunk: %v", chunks[2])
+	}
+}
+
+func TestTranslateStreamOllamaToolCallsAcrossChunks(t *testing.T) {
+	tr := &translator{mode: config.TranslateOpenAIToOllama, endpoint: endpointChat}
+	chunks := []string{
+		`{"model":"llama3.2","message":{"role":"assistant","content":"","tool_calls":[{"function":{"name":"lookup","arguments":{"q":"x"}}}]},"done":false}`,
+		`{"model":"llama3.2","message":{"role":"assistant","content":"","tool_calls":[{"function":{"name":"fetch","arguments":{}}},{"function":{"name":"lookup","arguments":{"q":"y"}}}]},"done":false}`,
+	}
+
+	type call struct {
+		index any
+		id    any
+		name  any
+	}
+	var got []call
+	for _, raw := range chunks {
+		for _, chunk := range tr.ollamaChunkToOpenAI(decodeChunk(t, raw)) {
+			delta := chunk["choices"].([]any)[0].(map[string]any)["delta"].(map[string]any)
+			calls, _ := delta["tool_calls"].([]any)
+			for _, c := range calls {
+				c := c.(map[string]any)
+				got = append(got, call{c["index"], c["id"], c["function"].(map[string]any)["name"]})
+			}
+		}
+	}
+
+	want := []call{
+		{0, "call_0", "lookup"},
+		{1, "call_1", "fetch"},
+		{2, "call_2", "lookup"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("tool calls = %v, want %v", got, want)
+	}
+}
+
+func TestTranslateStreamOpenAIToolCallsToOllama(t *testing.T) {
+	tr := &translator{mode: config.TranslateOllamaToOpenAI, endpoint: endpointChat}
+	lines := []string{
+		`{"model":"m","created":1,"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"c1","function":{"name":"lookup","arguments":"{\"q\""}}]}}]}`,
+		`{"model":"m","created":1,"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":":\"x\"}"}}]},"finish_reason":"tool_calls"}]}`,
+		`{"model":"m","created":1,"choices":[],"usage":{"prompt_tokens":3,"completion_tokens":4}}`,
+		`[DONE]`,
+	}
+
+	var chunks []map[string]any
+	for _, line := range lines {
+		chunks = append(chunks, tr.streamChunk(line)...)
+	}
+	chunks = append(chunks, tr.streamEnd()...)
+
+	if len(chunks) != 2 {
+		t.Fatalf("expected tool call chunk and done chunk, got %v", chunks)
+	}
+	calls := chunks[0]["message"].(map[string]any)["tool_calls"].([]any)
+	args := calls[0].(map[string]any)["function"].(map[string]any)["arguments"]
+	if !reflect.DeepEqual(args, map[string]any{"q": "x"}) {
+		t.Fatalf("fragments not reassembled: %v", args)
+	}
+	if chunks[1]["done"] != true || chunks[1]["eval_count"] != 4.0 {
+		t.Fatalf("unexpected done chunk: %v", chunks[1])
+	}
+}
@@ -0,0 +1,351 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spicyneuron/llama-matchmaker/config"
+	"github.com/spicyneuron/llama-matchmaker/logger"
+)
+
+const upstreamContextKey contextKey = "upstream"
+
+const (
+	defaultMaxFailures = 3
+	defaultCooldown    = 30 * time.Second
+)
+
+// Upstream is one target a proxy can forward to
+type Upstream struct {
+	URL    *url.URL
+	Weight int
+
+	outstanding   int
+	failures      int
+	downUntil     time.Time
+	currentWeight int
+}
+
+// Balancer picks an upstream for each request and tracks passive health
+type Balancer struct {
+	strategy    string
+	maxFailures int
+	cooldown    time.Duration
+	upstreams   []*Upstream
+
+	mu   sync.Mutex
+	next int
+	now  func() time.Time
+}
+
+// upstreamLease ties a request to its upstream until the response finishes
+type upstreamLease struct {
+	balancer *Balancer
+	upstream *Upstream
+	once     sync.Once
+}
+
+// NewBalancer builds a balancer from a proxy's target or targets
+func NewBalancer(cfg config.ProxyConfig) (*Balancer, error) {
+	targets := cfg.TargetList()
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no targets configured")
+	}
+
+	b := &Balancer{
+		strategy:    cfg.Balance,
+		maxFailures: cfg.Health.MaxFailures,
+		cooldown:    cfg.Health.Cooldown,
+		now:         time.Now,
+	}
+	if b.strategy == "" {
+		b.strategy = config.BalanceRoundRobin
+	}
+	if b.maxFailures == 0 {
+		b.maxFailures = defaultMaxFailures
+	}
+	if b.cooldown == 0 {
+		b.cooldown = defaultCooldown
+	}
+
+	for _, t := range targets {
+		u, err := url.Parse(t.URL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid target URL %s: %w", t.URL, err)
+		}
+		weight := t.Weight
+		if weight == 0 {
+			weight = 1
+		}
+		b.upstreams = append(b.upstreams, &Upstream{URL: u, Weight: weight})
+	}
+	return b, nil
+}
+
+// Upstreams returns the configured upstreams in order
+func (b *Balancer) Upstreams() []*Upstream {
+	return b.upstreams
+}
+
+// Pick selects the next upstream, skipping ones marked down
+// If every upstream is down, all are considered so requests still have somewhere to go.
+func (b *Balancer) Pick() *Upstream {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	candidates := make([]*Upstream, 0, len(b.upstreams))
+	for _, u := range b.upstreams {
+		if !now.Before(u.downUntil) {
+			candidates = append(candidates, u)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = b.upstreams
+	}
+
+	var chosen *Upstream
+	switch b.strategy {
+	case config.BalanceLeastRequests:
+		// Ties rotate so idle upstreams share load evenly
+		start := b.next % len(candidates)
+		for i := range candidates {
+			u := candidates[(start+i)%len(candidates)]
+			if chosen == nil || u.outstanding < chosen.outstanding {
+				chosen = u
+			}
+		}
+		b.next++
+	case config.BalanceWeighted:
+		// Smooth weighted round-robin
+		total := 0
+		for _, u := range candidates {
+			u.currentWeight += u.Weight
+			total += u.Weight
+			if chosen == nil || u.currentWeight > chosen.currentWeight {
+				chosen = u
+			}
+		}
+		chosen.currentWeight -= total
+	default:
+		chosen = candidates[b.next%len(candidates)]
+		b.next++
+	}
+
+	chosen.outstanding++
+	return chosen
+}
+
+// release ends a request on an upstream and records whether it failed
+func (b *Balancer) release(u *Upstream, failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	u.outstanding--
+	if !failed {
+		u.failures = 0
+		return
+	}
+
+	u.failures++
+	if u.failures >= b.maxFailures && len(b.upstreams) > 1 {
+		u.downUntil = b.now().Add(b.cooldown)
+		u.failures = 0
+		logger.Error("Upstream marked down", "target", config.RedactURL(u.URL.String()), "cooldown", b.cooldown)
+	}
+}
+
+// abandon ends a request on an upstream it never reached, leaving its health untouched
+func (b *Balancer) abandon(u *Upstream) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	u.outstanding--
+}
+
+// Direct picks an upstream and points the outbound request at it
+// Used as the reverse proxy Director before ModifyRequest.
+func (b *Balancer) Direct(req *http.Request) {
+	u := b.Pick()
+	rewriteRequestURL(req, u.URL)
+	if _, ok := req.Header["User-Agent"]; !ok {
+		// Explicitly disable the default User-Agent, as httputil does
+		req.Header.Set("User-Agent", "")
+	}
+
+	lease := &upstreamLease{balancer: b, upstream: u}
+	ctx := context.WithValue(req.Context(), upstreamContextKey, lease)
+	*req = *req.WithContext(ctx)
+
+	logger.Debug("Upstream selected", "target", config.RedactURL(u.URL.String()), "strategy", b.strategy)
+}
+
+// Track releases the upstream once the response body is fully consumed
+// Gateway errors count toward marking the upstream down.
+func (b *Balancer) Track(resp *http.Response) {
+	lease := leaseFor(resp.Request)
+	if lease == nil {
+		return
+	}
+	failed := isUpstreamFailure(resp.StatusCode)
+	if resp.Body == nil || resp.Body == http.NoBody {
+		lease.release(failed)
+		return
+	}
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: func() { lease.release(failed) }}
+}
+
+// Fail releases the upstream for a request that errored before a response arrived
+func (b *Balancer) Fail(req *http.Request) {
+	if lease := leaseFor(req); lease != nil {
+		lease.release(true)
+	}
+}
+
+func (l *upstreamLease) release(failed bool) {
+	l.once.Do(func() {
+		// Route and action targets bypass the balancer
+		if l.balancer != nil {
+			l.balancer.release(l.upstream, failed)
+		}
+	})
+}
+
+// abandon releases the lease without counting the request as a success or failure
+func (l *upstreamLease) abandon() {
+	l.once.Do(func() {
+		if l.balancer != nil {
+			l.balancer.abandon(l.upstream)
+		}
+	})
+}
+
+// renew starts a new lease on the same upstream for another attempt
+func (l *upstreamLease) renew() *upstreamLease {
+	if l.balancer != nil {
+		l.balancer.mu.Lock()
+		l.upstream.outstanding++
+		l.balancer.mu.Unlock()
+	}
+	return &upstreamLease{balancer: l.balancer, upstream: l.upstream}
+}
+
+// Retarget points an already-directed request at a route or action target
+// The balancer's pick is abandoned without affecting its health, and the proxy target's base path is swapped for the new one.
+func Retarget(req *http.Request, target string) error {
+	u, err := url.Parse(target)
+	if err != nil {
+		return fmt.Errorf("invalid target URL %s: %w", target, err)
+	}
+
+	if lease := leaseFor(req); lease != nil {
+		if lease.upstream.URL.String() == u.String() {
+			return nil
+		}
+		lease.abandon()
+		if base := strings.TrimSuffix(lease.upstream.URL.Path, "/"); base != "" && strings.HasPrefix(req.URL.Path, base+"/") {
+			req.URL.Path = strings.TrimPrefix(req.URL.Path, base)
+			req.URL.RawPath = ""
+		}
+	}
+
+	req.URL.Scheme = u.Scheme
+	req.URL.Host = u.Host
+	if u.Path != "" && u.Path != "/" {
+		req.URL.Path = singleJoiningSlash(u.Path, req.URL.Path)
+		req.URL.RawPath = ""
+	}
+
+	lease := &upstreamLease{upstream: &Upstream{URL: u, Weight: 1}}
+	ctx := context.WithValue(req.Context(), upstreamContextKey, lease)
+	*req = *req.WithContext(ctx)
+	return nil
+}
+
+// RedactedUpstreamFor returns the upstream URL chosen for a request with its password and query values masked, for logging
+func RedactedUpstreamFor(req *http.Request) string {
+	return config.RedactURL(UpstreamFor(req))
+}
+
+// UpstreamFor returns the upstream URL chosen for a request, or "" if none was recorded
+func UpstreamFor(req *http.Request) string {
+	if lease := leaseFor(req); lease != nil {
+		return lease.upstream.URL.String()
+	}
+	return ""
+}
+
+func leaseFor(req *http.Request) *upstreamLease {
+	if req == nil {
+		return nil
+	}
+	lease, _ := req.Context().Value(upstreamContextKey).(*upstreamLease)
+	return lease
+}
+
+func isUpstreamFailure(status int) bool {
+	return status == http.StatusBadGateway ||
+		status == http.StatusServiceUnavailable ||
+		status == http.StatusGatewayTimeout
+}
+
+// releasingBody runs release exactly once when the body is closed
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (r *releasingBody) Close() error {
+	err := r.ReadCloser.Close()
+	r.release()
+	return err
+}
+
+// rewriteRequestURL mirrors httputil.NewSingleHostReverseProxy's target joining
+func rewriteRequestURL(req *http.Request, target *url.URL) {
+	targetQuery := target.RawQuery
+	req.URL.Scheme = target.Scheme
+	req.URL.Host = target.Host
+	req.URL.Path, req.URL.RawPath = joinURLPath(target, req.URL)
+	if targetQuery == "" || req.URL.RawQuery == "" {
+		req.URL.RawQuery = targetQuery + req.URL.RawQuery
+	} else {
+		req.URL.RawQuery = targetQuery + "&" + req.URL.RawQuery
+	}
+}
+
+func joinURLPath(a, b *url.URL) (path, rawpath string) {
+	if a.RawPath == "" && b.RawPath == "" {
+		return singleJoiningSlash(a.Path, b.Path), ""
+	}
+	apath := a.EscapedPath()
+	bpath := b.EscapedPath()
+
+	aslash := strings.HasSuffix(apath, "/")
+	bslash := strings.HasPrefix(bpath, "/")
+
+	switch {
+	case aslash && bslash:
+		return a.Path + b.Path[1:], apath + bpath[1:]
+	case !aslash && !bslash:
+		return a.Path + "/" + b.Path, apath + "/" + bpath
+	}
+	return a.Path + b.Path, apath + bpath
+}
+
+func singleJoiningSlash(a, b string) string {
+	aslash := strings.HasSuffix(a, "/")
+	bslash := strings.HasPrefix(b, "/")
+	switch {
+	case aslash && bslash:
+		return a + b[1:]
+	case !aslash && !bslash:
+		return a + "/" + b
+	}
+	return a + b
+}
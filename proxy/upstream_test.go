@@ -0,0 +1,186 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spicyneuron/llama-matchmaker/config"
+)
+
+func newTestBalancer(t *testing.T, cfg config.ProxyConfig) *Balancer {
+	t.Helper()
+	b, err := NewBalancer(cfg)
+	if err != nil {
+		t.Fatalf("NewBalancer: %v", err)
+	}
+	return b
+}
+
+func pickHosts(b *Balancer, n int, release bool) []string {
+	hosts := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		u := b.Pick()
+		hosts = append(hosts, u.URL.Host)
+		if release {
+			b.release(u, false)
+		}
+	}
+	return hosts
+}
+
+func TestBalancerRoundRobin(t *testing.T) {
+	b := newTestBalancer(t, config.ProxyConfig{Targets: []config.TargetConfig{
+		{URL: "http://a:1"}, {URL: "http://b:1"}, {URL: "http://c:1"},
+	}})
+
+	got := strings.Join(pickHosts(b, 6, true), ",")
+	if want := "a:1,b:1,c:1,a:1,b:1,c:1"; got != want {
+		t.Fatalf("round robin order = %s, want %s", got, want)
+	}
+}
+
+func TestBalancerSingleTarget(t *testing.T) {
+	b := newTestBalancer(t, config.ProxyConfig{Target: "http://only:8080/base"})
+
+	req := httptest.NewRequest("POST", "http://proxy/v1/chat?x=1", nil)
+	b.Direct(req)
+
+	if req.URL.String() != "http://only:8080/base/v1/chat?x=1" {
+		t.Fatalf("rewritten URL = %s", req.URL.String())
+	}
+	if UpstreamFor(req) != "http://only:8080/base" {
+		t.Fatalf("UpstreamFor = %q", UpstreamFor(req))
+	}
+}
+
+func TestBalancerLeastRequests(t *testing.T) {
+	b := newTestBalancer(t, config.ProxyConfig{
+		Balance: config.BalanceLeastRequests,
+		Targets: []config.TargetConfig{{URL: "http://a:1"}, {URL: "http://b:1"}},
+	})
+
+	// Hold two requests open on whichever upstreams are picked, then release one
+	first := b.Pick()
+	second := b.Pick()
+	if first == second {
+		t.Fatalf("expected idle upstreams to share load, both went to %s", first.URL.Host)
+	}
+	b.release(first, false)
+
+	for i := 0; i < 3; i++ {
+		u := b.Pick()
+		if u != first {
+			t.Fatalf("expected least loaded upstream %s, got %s", first.URL.Host, u.URL.Host)
+		}
+		b.release(u, false)
+	}
+}
+
+func TestBalancerWeighted(t *testing.T) {
+	b := newTestBalancer(t, config.ProxyConfig{
+		Balance: config.BalanceWeighted,
+		Targets: []config.TargetConfig{{URL: "http://a:1", Weight: 3}, {URL: "http://b:1", Weight: 1}},
+	})
+
+	counts := map[string]int{}
+	for _, host := range pickHosts(b, 8, true) {
+		counts[host]++
+	}
+	if counts["a:1"] != 6 || counts["b:1"] != 2 {
+		t.Fatalf("weighted distribution = %v, want a:1=6 b:1=2", counts)
+	}
+}
+
+func TestBalancerPassiveHealth(t *testing.T) {
+	b := newTestBalancer(t, config.ProxyConfig{
+		Targets: []config.TargetConfig{{URL: "http://a:1"}, {URL: "http://b:1"}},
+		Health:  config.HealthConfig{MaxFailures: 2, Cooldown: time.Minute},
+	})
+	now := time.Unix(0, 0)
+	b.now = func() time.Time { return now }
+
+	bad := b.upstreams[0]
+	for i := 0; i < 2; i++ {
+		markInFlight(b, bad)
+		b.release(bad, true)
+	}
+
+	for _, host := range pickHosts(b, 4, true) {
+		if host != "b:1" {
+			t.Fatalf("expected down upstream to be skipped, got %s", host)
+		}
+	}
+
+	// Every upstream down: fail open rather than refusing traffic
+	other := b.upstreams[1]
+	for i := 0; i < 2; i++ {
+		markInFlight(b, other)
+		b.release(other, true)
+	}
+	if u := b.Pick(); u == nil {
+		t.Fatal("expected an upstream when all are down")
+	}
+
+	now = now.Add(2 * time.Minute)
+	hosts := strings.Join(pickHosts(b, 2, true), ",")
+	if !strings.Contains(hosts, "a:1") {
+		t.Fatalf("expected upstream to recover after cooldown, got %s", hosts)
+	}
+}
+
+func TestBalancerTrackReleasesOnBodyClose(t *testing.T) {
+	b := newTestBalancer(t, config.ProxyConfig{Targets: []config.TargetConfig{{URL: "http://a:1"}, {URL: "http://b:1"}}})
+
+	req := httptest.NewRequest("GET", "http://proxy/v1/models", nil)
+	b.Direct(req)
+	u := b.upstreams[0]
+	if u.outstanding != 1 {
+		t.Fatalf("expected outstanding request, got %d", u.outstanding)
+	}
+
+	resp := &http.Response{Request: req, StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("{}"))}
+	b.Track(resp)
+	if u.outstanding != 1 {
+		t.Fatal("upstream should stay leased until the body is closed")
+	}
+	resp.Body.Close()
+	resp.Body.Close()
+	b.Fail(req)
+	if u.outstanding != 0 {
+		t.Fatalf("expected lease released exactly once, outstanding=%d", u.outstanding)
+	}
+}
+
+// markInFlight simulates an in-flight request on u
+func markInFlight(b *Balancer, u *Upstream) {
+	b.mu.Lock()
+	u.outstanding++
+	b.mu.Unlock()
+}
+
+func TestRetargetLeavesBalancerHealthAlone(t *testing.T) {
+	b := newTestBalancer(t, config.ProxyConfig{
+		Targets: []config.TargetConfig{{URL: "http://a:1"}, {URL: "http://b:1"}},
+		Health:  config.HealthConfig{MaxFailures: 3, Cooldown: time.Minute},
+	})
+	picked := b.upstreams[0]
+	markInFlight(b, picked)
+	b.release(picked, true)
+
+	req := httptest.NewRequest("POST", "http://proxy/v1/chat", nil)
+	b.Direct(req)
+	if UpstreamFor(req) != "http://a:1" {
+		t.Fatalf("expected balancer to pick a:1, got %s", UpstreamFor(req))
+	}
+	if err := Retarget(req, "http://other:2"); err != nil {
+		t.Fatalf("Retarget: %v", err)
+	}
+
+	if picked.failures != 1 || picked.outstanding != 0 {
+		t.Fatalf("after retarget failures = %d, outstanding = %d; want 1 and 0", picked.failures, picked.outstanding)
+	}
+}
@@ -0,0 +1,440 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/spicyneuron/llama-matchmaker/config"
+	"github.com/spicyneuron/llama-matchmaker/logger"
+	"github.com/spicyneuron/llama-matchmaker/proxy"
+)
+
+// testSuite is a YAML file of rule test cases
+// config lists the configs to test, relative to the suite file, unless -config is given.
+type testSuite struct {
+	Config suiteConfigs `yaml:"config"`
+	Tests  []testCase   `yaml:"tests"`
+}
+
+// suiteConfigs accepts a single path or a list
+type suiteConfigs []string
+
+func (c *suiteConfigs) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*c = []string{value.Value}
+		return nil
+	}
+	var paths []string
+	if err := value.Decode(&paths); err != nil {
+		return err
+	}
+	*c = paths
+	return nil
+}
+
+// testCase sends one request through the rules, and optionally one upstream response back
+type testCase struct {
+	Name string `yaml:"name"`
+
+	// Listen picks the proxy to test when the config has several (default: the first)
+	Listen string `yaml:"listen"`
+
+	Request        testRequest       `yaml:"request"`
+	ExpectRequest  *expectedRequest  `yaml:"expect_request"`
+	Response       *testResponse     `yaml:"response"`
+	ExpectResponse *expectedResponse `yaml:"expect_response"`
+}
+
+type testRequest struct {
+	Method   string            `yaml:"method"`
+	Path     string            `yaml:"path"`
+	Headers  map[string]string `yaml:"headers"`
+	Body     any               `yaml:"body"`
+	BodyFile string            `yaml:"body_file"`
+}
+
+// expectedRequest is what should reach the upstream; empty fields are not checked
+type expectedRequest struct {
+	Method   string            `yaml:"method"`
+	Path     string            `yaml:"path"`
+	Target   string            `yaml:"target"`
+	Headers  map[string]string `yaml:"headers"`
+	Body     any               `yaml:"body"`
+	BodyFile string            `yaml:"body_file"`
+}
+
+// testResponse is the upstream's reply; chunks make it a stream framed as format (sse or ndjson)
+type testResponse struct {
+	Status   int               `yaml:"status"`
+	Headers  map[string]string `yaml:"headers"`
+	Body     any               `yaml:"body"`
+	BodyFile string            `yaml:"body_file"`
+	Chunks   []any             `yaml:"chunks"`
+	Format   string            `yaml:"format"`
+}
+
+// expectedResponse is what the client should get; empty fields are not checked
+type expectedResponse struct {
+	Status   int               `yaml:"status"`
+	Headers  map[string]string `yaml:"headers"`
+	Body     any               `yaml:"body"`
+	BodyFile string            `yaml:"body_file"`
+	Chunks   []any             `yaml:"chunks"`
+}
+
+// runTests runs rule test suites against their configs without a network
+// It returns the exit code: 1 when any case fails or a suite can't be loaded, else 0.
+func runTests(args []string, stdout, stderr io.Writer) int {
+	var paths configFiles
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Var(&paths, "config", "Path to YAML configuration, overriding the suites' config (can be specified multiple times)")
+	fs.Var(&paths, "c", "Alias for -config")
+	debug := fs.Bool("debug", false, "Print proxy logs to stderr")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: llama-matchmaker test [-config <config.yml> ...] <tests.yml> [<tests.yml> ...]")
+		fmt.Fprintln(stderr)
+		fmt.Fprintln(stderr, "Runs rule test cases through the configured routes without a network, reporting differences.")
+		fmt.Fprintln(stderr)
+		fmt.Fprintln(stderr, "Options:")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	// Request logs would bury the report
+	logger.SetOutput(io.Discard)
+	if *debug {
+		logger.SetOutput(stderr)
+	}
+	defer logger.SetOutput(os.Stdout)
+	logger.EnableDebug(*debug)
+
+	passed, failed := 0, 0
+	for _, suitePath := range fs.Args() {
+		p, f, err := runSuite(suitePath, paths, stdout)
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %v\n", suitePath, err)
+			failed++
+			continue
+		}
+		passed += p
+		failed += f
+	}
+
+	fmt.Fprintf(stdout, "%d passed, %d failed\n", passed, failed)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// runSuite loads a suite and its config, then runs every case
+func runSuite(suitePath string, configOverride []string, stdout io.Writer) (int, int, error) {
+	data, err := os.ReadFile(suitePath)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to read test file: %w", err)
+	}
+	var suite testSuite
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&suite); err != nil {
+		return 0, 0, fmt.Errorf("failed to parse test file: %w", err)
+	}
+
+	baseDir := filepath.Dir(suitePath)
+	configs := []string(configOverride)
+	if len(configs) == 0 {
+		for _, path := range suite.Config {
+			configs = append(configs, config.ResolvePath(path, baseDir))
+		}
+	}
+	if len(configs) == 0 {
+		return 0, 0, fmt.Errorf("no config: add config: to the test file or pass -config")
+	}
+
+	cfg, _, err := config.Load(configs, config.CliOverrides{})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	passed, failed := 0, 0
+	for i, tc := range suite.Tests {
+		name := tc.Name
+		if name == "" {
+			name = fmt.Sprintf("test %d", i)
+		}
+		problems := runTestCase(cfg, &tc, baseDir)
+		if len(problems) == 0 {
+			passed++
+			fmt.Fprintf(stdout, "PASS %s: %s\n", suitePath, name)
+			continue
+		}
+		failed++
+		fmt.Fprintf(stdout, "FAIL %s: %s\n", suitePath, name)
+		for _, problem := range problems {
+			fmt.Fprintf(stdout, "    %s\n", strings.ReplaceAll(problem, "\n", "\n    "))
+		}
+	}
+	return passed, failed, nil
+}
+
+// runTestCase sends a case through the proxy's rules and lists every expectation it misses
+func runTestCase(cfg *config.Config, tc *testCase, baseDir string) []string {
+	proxyCfg, ok := selectProxy(cfg, tc.Listen)
+	if !ok {
+		return []string{fmt.Sprintf("no proxy listens on %s", tc.Listen)}
+	}
+	if tc.Request.Path == "" {
+		return []string{"request.path is required"}
+	}
+
+	body, err := caseBody(tc.Request.Body, tc.Request.BodyFile, baseDir)
+	if err != nil {
+		return []string{fmt.Sprintf("request: %v", err)}
+	}
+	method := tc.Request.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+	headers := make(http.Header)
+	for name, value := range tc.Request.Headers {
+		headers.Set(name, value)
+	}
+
+	req, err := offlineRequest(context.Background(), proxyCfg, method, tc.Request.Path, headers, body)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	outbound, _ := io.ReadAll(req.Body)
+
+	var problems []string
+	if want := tc.ExpectRequest; want != nil {
+		if want.Method != "" && !strings.EqualFold(want.Method, req.Method) {
+			problems = append(problems, fmt.Sprintf("outbound method: got %s, want %s", req.Method, strings.ToUpper(want.Method)))
+		}
+		if want.Path != "" {
+			got := req.URL.Path
+			if strings.Contains(want.Path, "?") {
+				got = req.URL.RequestURI()
+			}
+			if got != want.Path {
+				problems = append(problems, fmt.Sprintf("outbound path: got %s, want %s", got, want.Path))
+			}
+		}
+		if want.Target != "" {
+			if got := req.URL.Scheme + "://" + req.URL.Host; strings.TrimSuffix(want.Target, "/") != got {
+				problems = append(problems, fmt.Sprintf("outbound target: got %s, want %s", got, want.Target))
+			}
+		}
+		problems = append(problems, compareHeaders("outbound", req.Header, want.Headers)...)
+		problems = append(problems, compareBody("outbound", outbound, want.Body, want.BodyFile, baseDir)...)
+	}
+
+	if tc.Response == nil {
+		if tc.ExpectResponse != nil {
+			problems = append(problems, "expect_response needs a response")
+		}
+		return problems
+	}
+
+	upstreamBody, contentType, err := upstreamResponseBody(tc.Response, baseDir)
+	if err != nil {
+		return append(problems, fmt.Sprintf("response: %v", err))
+	}
+	status := tc.Response.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	respHeaders := http.Header{"Content-Type": {contentType}}
+	for name, value := range tc.Response.Headers {
+		respHeaders.Set(name, value)
+	}
+
+	resp := offlineResponse(req, status, respHeaders, upstreamBody)
+	if err := proxy.ModifyResponse(resp, proxyCfg.Routes); err != nil {
+		return append(problems, fmt.Sprintf("response processing failed: %v", err))
+	}
+	clientBody, _ := io.ReadAll(resp.Body)
+
+	if want := tc.ExpectResponse; want != nil {
+		if want.Status != 0 && want.Status != resp.StatusCode {
+			problems = append(problems, fmt.Sprintf("client status: got %d, want %d", resp.StatusCode, want.Status))
+		}
+		problems = append(problems, compareHeaders("client", resp.Header, want.Headers)...)
+		problems = append(problems, compareBody("client", clientBody, want.Body, want.BodyFile, baseDir)...)
+		if want.Chunks != nil {
+			problems = append(problems, compareChunks(clientBody, resp.Header.Get("Content-Type"), want.Chunks)...)
+		}
+	}
+	return problems
+}
+
+// caseBody encodes a body given inline (maps and lists as JSON, strings as they are) or in a file
+func caseBody(body any, bodyFile, baseDir string) ([]byte, error) {
+	if bodyFile != "" {
+		data, err := os.ReadFile(config.ResolvePath(bodyFile, baseDir))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read body file: %w", err)
+		}
+		return data, nil
+	}
+	switch v := body.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []byte(v), nil
+	default:
+		return json.Marshal(v)
+	}
+}
+
+// upstreamResponseBody frames a case's upstream response and picks its content type
+func upstreamResponseBody(r *testResponse, baseDir string) ([]byte, string, error) {
+	if r.Chunks == nil {
+		body, err := caseBody(r.Body, r.BodyFile, baseDir)
+		if err != nil {
+			return nil, "", err
+		}
+		return body, responseContentType(body), nil
+	}
+
+	var buf bytes.Buffer
+	for _, chunk := range r.Chunks {
+		encoded, err := caseBody(chunk, "", baseDir)
+		if err != nil {
+			return nil, "", err
+		}
+		switch r.Format {
+		case "", config.StreamFormatSSE:
+			fmt.Fprintf(&buf, "data: %s\n\n", encoded)
+		case config.StreamFormatNDJSON:
+			fmt.Fprintf(&buf, "%s\n", encoded)
+		default:
+			return nil, "", fmt.Errorf("format must be sse or ndjson")
+		}
+	}
+	if r.Format == config.StreamFormatNDJSON {
+		return buf.Bytes(), "application/x-ndjson", nil
+	}
+	return buf.Bytes(), "text/event-stream", nil
+}
+
+// compareHeaders checks the listed headers; an empty value expects the header to be absent
+func compareHeaders(side string, got http.Header, want map[string]string) []string {
+	var problems []string
+	for _, name := range sortedMapKeys(want) {
+		if value := got.Get(name); value != want[name] {
+			problems = append(problems, fmt.Sprintf("%s header %s: got %q, want %q", side, name, value, want[name]))
+		}
+	}
+	return problems
+}
+
+// compareBody checks a body against the expected one, listing differences by field for JSON
+func compareBody(side string, got []byte, want any, wantFile, baseDir string) []string {
+	if want == nil && wantFile == "" {
+		return nil
+	}
+	expected, err := caseBody(want, wantFile, baseDir)
+	if err != nil {
+		return []string{fmt.Sprintf("%s body: %v", side, err)}
+	}
+
+	var gotJSON, wantJSON any
+	if json.Unmarshal(got, &gotJSON) != nil || json.Unmarshal(expected, &wantJSON) != nil {
+		if strings.TrimSpace(string(got)) != strings.TrimSpace(string(expected)) {
+			return []string{fmt.Sprintf("%s body: got %q, want %q", side, got, expected)}
+		}
+		return nil
+	}
+	if reflect.DeepEqual(gotJSON, wantJSON) {
+		return nil
+	}
+
+	gotMap, gotIsMap := gotJSON.(map[string]any)
+	wantMap, wantIsMap := wantJSON.(map[string]any)
+	if !gotIsMap || !wantIsMap {
+		return []string{fmt.Sprintf("%s body: got %s, want %s", side, got, expected)}
+	}
+	return []string{fmt.Sprintf("%s body differs (- missing, + unexpected, ~ want -> got):\n  %s", side, strings.Join(diffBodies(wantMap, gotMap), "\n  "))}
+}
+
+// compareChunks checks a streamed client body chunk by chunk
+// Chunks are the JSON payloads of SSE data fields or NDJSON lines; [DONE] compares as a string.
+func compareChunks(body []byte, contentType string, want []any) []string {
+	got := streamChunks(body, strings.Contains(contentType, "application/x-ndjson"))
+
+	var problems []string
+	for i := 0; i < max(len(got), len(want)); i++ {
+		switch {
+		case i >= len(got):
+			encoded, _ := json.Marshal(want[i])
+			problems = append(problems, fmt.Sprintf("client chunk %d: missing, want %s", i, encoded))
+		case i >= len(want):
+			problems = append(problems, fmt.Sprintf("client chunk %d: unexpected %s", i, got[i]))
+		default:
+			expected, _ := caseBody(want[i], "", "")
+			var gotJSON, wantJSON any
+			json.Unmarshal([]byte(got[i]), &gotJSON)
+			json.Unmarshal(expected, &wantJSON)
+			if got[i] != string(expected) && (gotJSON == nil || !reflect.DeepEqual(gotJSON, wantJSON)) {
+				problems = append(problems, fmt.Sprintf("client chunk %d: got %s, want %s", i, got[i], expected))
+			}
+		}
+	}
+	return problems
+}
+
+// streamChunks splits a streamed body into its payloads
+func streamChunks(body []byte, ndjson bool) []string {
+	var chunks []string
+	if ndjson {
+		for _, line := range strings.Split(string(body), "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				chunks = append(chunks, line)
+			}
+		}
+		return chunks
+	}
+
+	for _, event := range strings.Split(strings.ReplaceAll(string(body), "\r\n", "\n"), "\n\n") {
+		var data []string
+		for _, line := range strings.Split(event, "\n") {
+			if value, ok := strings.CutPrefix(line, "data:"); ok {
+				data = append(data, strings.TrimPrefix(value, " "))
+			}
+		}
+		if len(data) > 0 {
+			chunks = append(chunks, strings.Join(data, "\n"))
+		}
+	}
+	return chunks
+}
+
+func sortedMapKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunTests(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yml")
+	if err := writeFile(configPath, `
+proxy:
+  listen: localhost:8081
+  target: http://localhost:8080
+  routes:
+    - methods: POST
+      paths: ^/v1/chat/completions$
+      on_request:
+        - match_body: {model: ^qwen}
+          merge: {temperature: 0.6}
+      on_response:
+        - merge: {served_by: matchmaker}
+    - methods: GET
+      paths: ^/models$
+      target_path: /v1/models
+      target_query: {remove: [debug]}
+`); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	if err := writeFile(filepath.Join(tmpDir, "req.json"), `{"model":"qwen3"}`); err != nil {
+		t.Fatalf("failed to write body: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		suite    string
+		wantCode int
+		want     []string
+	}{
+		{
+			name: "passing cases",
+			suite: `
+config: config.yml
+tests:
+  - name: qwen sampling
+    request:
+      path: /v1/chat/completions
+      body_file: req.json
+    expect_request:
+      method: post
+      target: http://localhost:8080
+      headers: {Content-Type: application/json}
+      body: {model: qwen3, temperature: 0.6}
+    response:
+      body: {id: chatcmpl-1}
+    expect_response:
+      status: 200
+      body: {id: chatcmpl-1, served_by: matchmaker}
+  - name: models rewrite
+    request:
+      method: GET
+      path: /models?debug=1&limit=5
+    expect_request:
+      path: /v1/models?limit=5
+  - name: ndjson stream
+    request:
+      path: /v1/chat/completions
+      body: {model: llama3}
+    response:
+      format: ndjson
+      chunks:
+        - {done: false}
+        - {done: true}
+    expect_response:
+      headers: {Content-Type: application/x-ndjson}
+      chunks:
+        - {done: false, served_by: matchmaker}
+        - {done: true, served_by: matchmaker}
+`,
+			wantCode: 0,
+			want: []string{
+				"PASS " + filepath.Join(tmpDir, "suite.yml") + ": qwen sampling",
+				"PASS " + filepath.Join(tmpDir, "suite.yml") + ": models rewrite",
+				"PASS " + filepath.Join(tmpDir, "suite.yml") + ": ndjson stream",
+				"3 passed, 0 failed",
+			},
+		},
+		{
+			name: "failing cases",
+			suite: `
+config: config.yml
+tests:
+  - name: wrong body
+    request:
+      path: /v1/chat/completions
+      body: {model: qwen3, debug: true}
+    expect_request:
+      path: /v1/completions
+      body: {model: qwen3, temperature: 0.7, top_k: 40}
+  - name: wrong chunks
+    request:
+      path: /v1/chat/completions
+      body: {model: qwen3}
+    response:
+      status: 503
+      chunks:
+        - {id: one}
+        - "[DONE]"
+    expect_response:
+      status: 200
+      chunks:
+        - {id: one}
+`,
+			wantCode: 1,
+			want: []string{
+				": wrong body",
+				"outbound path: got /v1/chat/completions, want /v1/completions",
+				"+ debug: true",
+				"~ temperature: 0.7 -> 0.6",
+				"- top_k: 40",
+				"client status: got 503, want 200",
+				`client chunk 0: got {"id":"one","served_by":"matchmaker"}, want {"id":"one"}`,
+				"client chunk 1: unexpected [DONE]",
+				"0 passed, 2 failed",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			suitePath := filepath.Join(tmpDir, "suite.yml")
+			if err := writeFile(suitePath, tt.suite); err != nil {
+				t.Fatalf("failed to write suite: %v", err)
+			}
+
+			var stdout, stderr bytes.Buffer
+			if code := runTests([]string{suitePath}, &stdout, &stderr); code != tt.wantCode {
+				t.Fatalf("exit code = %d, want %d\nstdout: %s\nstderr: %s", code, tt.wantCode, stdout.String(), stderr.String())
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(stdout.String(), want) {
+					t.Errorf("output missing %q:\n%s", want, stdout.String())
+				}
+			}
+		})
+	}
+}
+
+func TestRunTestsExample(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if code := runTests([]string{"examples/example.tests.yml"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("exit code = %d\nstdout: %s\nstderr: %s", code, stdout.String(), stderr.String())
+	}
+}
+
+func TestRunTestsErrors(t *testing.T) {
+	tmpDir := t.TempDir()
+	suitePath := filepath.Join(tmpDir, "suite.yml")
+	if err := writeFile(suitePath, "tests:\n  - name: x\n    reqest: {path: /}\n"); err != nil {
+		t.Fatalf("failed to write suite: %v", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := runTests([]string{suitePath}, &stdout, &stderr); code != 1 {
+		t.Errorf("exit code = %d, want 1", code)
+	}
+	if !strings.Contains(stderr.String(), "field reqest not found") {
+		t.Errorf("expected unknown field error, got %q", stderr.String())
+	}
+
+	stderr.Reset()
+	if code := runTests(nil, &stdout, &stderr); code != 2 {
+		t.Errorf("exit code = %d, want 2", code)
+	}
+	if !strings.Contains(stderr.String(), "Usage: llama-matchmaker test") {
+		t.Errorf("expected usage, got %q", stderr.String())
+	}
+}